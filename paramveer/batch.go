@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+const (
+	batchAtomic     = "atomic"
+	batchBestEffort = "best_effort"
+
+	maxBatchOperations = 1000
+)
+
+var (
+	errInvalidBatch   = errors.New("invalid batch")
+	errBatchAborted   = errors.New("not applied because another operation in the batch failed")
+	errRollbackFailed = errors.New("applied, and could not be undone when the batch failed")
+)
+
+// movieBatch is the body of POST /api/movies:batch. In atomic mode, the
+// default, a batch that fails part way is rolled back, as runBatch
+// describes; in best_effort mode each operation stands on its own.
+type movieBatch struct {
+	Mode       string           `json:"mode"`
+	Operations []batchOperation `json:"operations"`
+}
+
+// batchOperation is one create, update or delete. ID names the movie to
+// update or delete, and a nonzero Version is checked like If-Match.
+type batchOperation struct {
+	Op      string `json:"op"`
+	ID      int    `json:"id,omitempty"`
+	Version int    `json:"version,omitempty"`
+	Movie   *Movie `json:"movie,omitempty"`
+}
+
+// batchResponse reports every operation in the order it was given. Failed
+// counts the operations that were not applied. It is sent with 200 unless
+// an atomic batch aborted: 422 if it was rolled back, 500 if some of it
+// could not be undone.
+type batchResponse struct {
+	Failed  int           `json:"failed"`
+	Results []batchResult `json:"results"`
+}
+
+// batchResult is the status and body a single request for the operation
+// would have been answered with.
+type batchResult struct {
+	Status int      `json:"status"`
+	Movie  *Movie   `json:"movie,omitempty"`
+	Error  *problem `json:"error,omitempty"`
+}
+
+func (b movieBatch) validate() error {
+	switch b.Mode {
+	case batchAtomic, batchBestEffort:
+	default:
+		return fmt.Errorf("%w: mode must be %s or %s", errInvalidBatch, batchAtomic, batchBestEffort)
+	}
+	if len(b.Operations) == 0 {
+		return fmt.Errorf("%w: operations is required", errInvalidBatch)
+	}
+	if len(b.Operations) > maxBatchOperations {
+		return fmt.Errorf("%w: at most %d operations are allowed", errInvalidBatch, maxBatchOperations)
+	}
+	return nil
+}
+
+// check catches operations that cannot be run at all, before anything in
+// the batch is applied.
+func (op batchOperation) check() error {
+	switch op.Op {
+	case "create":
+		if op.Movie == nil {
+			return fmt.Errorf("%w: create needs a movie", errInvalidBatch)
+		}
+	case "update":
+		if op.Movie == nil {
+			return fmt.Errorf("%w: update needs a movie", errInvalidBatch)
+		}
+		return validateId(op.ID)
+	case "delete":
+		return validateId(op.ID)
+	default:
+		return fmt.Errorf("%w: unknown op %q", errInvalidBatch, op.Op)
+	}
+	return nil
+}
+
+// undoFunc reverts an operation that was applied, expecting to find the
+// movie it wrote at the given version. It returns the movie as the undo left
+// it, or the zero Movie if the undo removed it.
+type undoFunc func(ctx context.Context, version int) (Movie, error)
+
+// run applies op through serv. With pin set an update only succeeds if the
+// movie is unchanged since it was read, so that it can be undone exactly.
+func (op batchOperation) run(ctx context.Context, serv movieService, pin bool) (Movie, int, undoFunc, error) {
+	if err := op.check(); err != nil {
+		return Movie{}, 0, nil, err
+	}
+
+	switch op.Op {
+	case "create":
+		created, err := serv.CreateMovie(ctx, *op.Movie)
+		if err != nil {
+			return Movie{}, 0, nil, err
+		}
+		undo := func(ctx context.Context, version int) (Movie, error) {
+			if _, err := serv.DeleteMovie(ctx, created.ID, version); err != nil {
+				return Movie{}, err
+			}
+			_, err := serv.PurgeMovie(ctx, created.ID)
+			return Movie{}, err
+		}
+		return created, http.StatusCreated, undo, nil
+
+	case "update":
+		movie := *op.Movie
+		if op.Version != 0 {
+			movie.Version = op.Version
+		}
+		var before Movie
+		if pin {
+			var err error
+			if before, err = serv.GetMovieById(ctx, op.ID); err != nil {
+				return Movie{}, 0, nil, err
+			}
+			if movie.Version == 0 {
+				movie.Version = before.Version
+			}
+			if movie.Version != before.Version {
+				return Movie{}, 0, nil, errVersionConflict
+			}
+		}
+		updated, err := serv.UpdateMovie(ctx, op.ID, movie)
+		if err != nil {
+			return Movie{}, 0, nil, err
+		}
+		undo := func(ctx context.Context, version int) (Movie, error) {
+			reverted := before
+			reverted.Version = version
+			return serv.UpdateMovie(ctx, updated.ID, reverted)
+		}
+		return updated, http.StatusOK, undo, nil
+
+	default:
+		deleted, err := serv.DeleteMovie(ctx, op.ID, op.Version)
+		if err != nil {
+			return Movie{}, 0, nil, err
+		}
+		undo := func(ctx context.Context, version int) (Movie, error) {
+			return serv.RestoreMovie(ctx, deleted.ID, version)
+		}
+		return deleted, http.StatusOK, undo, nil
+	}
+}
+
+func succeeded(status int, movie Movie) batchResult {
+	return batchResult{Status: status, Movie: &movie}
+}
+
+func failed(err error) batchResult {
+	p := newProblem(err)
+	return batchResult{Status: p.Status, Error: &p}
+}
+
+// runBatch applies the operations of b one after another, as separate
+// writes, and returns the status to send the response with.
+//
+// Atomic batches are not transactions, only a best effort at one. A batch
+// that fails part way is rolled back by writing the inverse of each applied
+// operation, newest first: a created movie is deleted and purged, an update
+// is reverted and a deleted movie restored. Other clients, the change feed
+// and the revision history all see the intermediate writes and the undo.
+// An undo that finds the movie changed by someone else in the meantime
+// fails with errRollbackFailed rather than overwriting that change, which
+// leaves the batch partly applied.
+func runBatch(ctx context.Context, serv movieService, b movieBatch) (batchResponse, int) {
+	res := batchResponse{Results: make([]batchResult, len(b.Operations))}
+
+	if b.Mode == batchBestEffort {
+		for i, op := range b.Operations {
+			movie, status, _, err := op.run(ctx, serv, false)
+			if err != nil {
+				res.Results[i] = failed(err)
+				res.Failed++
+				continue
+			}
+			res.Results[i] = succeeded(status, movie)
+		}
+		return res, http.StatusOK
+	}
+
+	abort := func(culprit int, err error) (batchResponse, int) {
+		for i := range res.Results {
+			if res.Results[i].Status == 0 {
+				res.Results[i] = failed(errBatchAborted)
+			}
+		}
+		res.Results[culprit] = failed(err)
+		res.Failed = len(res.Results)
+		return res, http.StatusUnprocessableEntity
+	}
+
+	for i, op := range b.Operations {
+		if err := op.check(); err != nil {
+			return abort(i, err)
+		}
+	}
+
+	// versions holds the version the batch last left each movie at, so an
+	// undo can tell whether anyone else has changed the movie since. Undoing
+	// newest first hands every undo the version the one before left.
+	versions := map[int]int{}
+	track := func(id int, movie Movie) {
+		delete(versions, id)
+		if movie.ID != 0 {
+			versions[movie.ID] = movie.Version
+		}
+	}
+
+	undos := make([]undoFunc, 0, len(b.Operations))
+	written := make([]int, 0, len(b.Operations))
+	for i, op := range b.Operations {
+		movie, status, undo, err := op.run(ctx, serv, true)
+		if err == nil {
+			res.Results[i] = succeeded(status, movie)
+			undos = append(undos, undo)
+			written = append(written, movie.ID)
+			track(op.ID, movie)
+			continue
+		}
+
+		// The rollback has to happen even when the client is gone.
+		rollbackCtx := context.WithoutCancel(ctx)
+		stuck := 0
+		for j := len(undos) - 1; j >= 0; j-- {
+			movie, undoErr := undos[j](rollbackCtx, versions[written[j]])
+			if undoErr != nil {
+				log.Printf("request %s: batch operation %d could not be undone: %v", requestIDFrom(ctx), j, undoErr)
+				res.Results[j] = failed(errRollbackFailed)
+				stuck++
+				continue
+			}
+			track(written[j], movie)
+			res.Results[j] = batchResult{}
+		}
+		res, status := abort(i, err)
+		res.Failed -= stuck
+		if stuck > 0 {
+			status = http.StatusInternalServerError
+		}
+		return res, status
+	}
+	return res, http.StatusOK
+}
+
+func (h *movieHandler) batchMovies(w http.ResponseWriter, r *http.Request) {
+	batch := movieBatch{Mode: batchAtomic}
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		resolveError(w, r, bodyError(errInvalidBody, err))
+		return
+	}
+	if err := batch.validate(); err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	res, status := runBatch(r.Context(), h.serv, batch)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func batchStatuses(res batchResponse) []int {
+	statuses := make([]int, len(res.Results))
+	for i, result := range res.Results {
+		statuses[i] = result.Status
+	}
+	return statuses
+}
+
+func Test_runBatch(t *testing.T) {
+	bhamsa := Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood}
+	hardik := Movie{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9, Industry: IndustryHollywood}
+	singh := Movie{Title: "singh", Director: "paramveer", IMDb: 7}
+	rerated := bhamsa
+	rerated.IMDb = 9.5
+	rerated2 := rerated
+	rerated2.IMDb = 6
+	missing := rerated
+	missing.ID = 9
+	badRating := singh
+	badRating.IMDb = 42
+
+	tests := []struct {
+		name         string
+		mode         string
+		ops          []batchOperation
+		wantStatus   int
+		wantStatuses []int
+		wantFailed   int
+		wantCodes    map[int]string
+		// wantChanged tells whether the stored movies differ from the seeds.
+		wantChanged bool
+	}{
+		{
+			name: "best effort applies what it can",
+			mode: batchBestEffort,
+			ops: []batchOperation{
+				{Op: "create", Movie: &singh},
+				{Op: "create", Movie: &badRating},
+				{Op: "update", ID: 1, Movie: &rerated},
+				{Op: "update", ID: 9, Movie: &missing},
+				{Op: "delete", ID: 2, Version: 5},
+				{Op: "delete", ID: -1},
+				{Op: "rename", ID: 2},
+			},
+			wantStatus:   http.StatusOK,
+			wantStatuses: []int{201, 400, 200, 404, 412, 400, 400},
+			wantFailed:   5,
+			wantCodes:    map[int]string{1: "invalid_movie", 3: "movie_not_found", 4: "version_conflict", 5: "invalid_id", 6: "invalid_batch"},
+			wantChanged:  true,
+		},
+		{
+			name: "atomic applies everything",
+			mode: batchAtomic,
+			ops: []batchOperation{
+				{Op: "create", Movie: &singh},
+				{Op: "update", ID: 1, Movie: &rerated},
+				{Op: "delete", ID: 2, Version: 1},
+			},
+			wantStatus:   http.StatusOK,
+			wantStatuses: []int{201, 200, 200},
+			wantChanged:  true,
+		},
+		{
+			name: "atomic rolls back on failure",
+			mode: batchAtomic,
+			ops: []batchOperation{
+				{Op: "create", Movie: &singh},
+				{Op: "update", ID: 1, Movie: &rerated},
+				{Op: "delete", ID: 2},
+				{Op: "update", ID: 1, Movie: &rerated2},
+				{Op: "delete", ID: 1},
+				{Op: "create", Movie: &hardik},
+			},
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantStatuses: []int{424, 424, 424, 424, 424, 400},
+			wantFailed:   6,
+			wantCodes:    map[int]string{0: "batch_aborted", 5: "client_id"},
+		},
+		{
+			name: "atomic checks every operation first",
+			mode: batchAtomic,
+			ops: []batchOperation{
+				{Op: "delete", ID: 2},
+				{Op: "update", ID: 1},
+			},
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantStatuses: []int{424, 400},
+			wantFailed:   2,
+			wantCodes:    map[int]string{1: "invalid_batch"},
+		},
+		{
+			name: "atomic update pins the version it read",
+			mode: batchAtomic,
+			ops: []batchOperation{
+				{Op: "update", ID: 1, Version: 2, Movie: &rerated},
+			},
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantStatuses: []int{412},
+			wantFailed:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newSeededRepo(t, []Movie{bhamsa, hardik})
+			before := storedMovies(t, repo)
+			serv := Newservice(repo)
+
+			res, status := runBatch(context.Background(), serv, movieBatch{Mode: tt.mode, Operations: tt.ops})
+
+			if status != tt.wantStatus {
+				t.Errorf("got status %d but want %d", status, tt.wantStatus)
+			}
+			if got := batchStatuses(res); !reflect.DeepEqual(got, tt.wantStatuses) {
+				t.Errorf("got statuses %v but want %v", got, tt.wantStatuses)
+			}
+			if res.Failed != tt.wantFailed {
+				t.Errorf("got %d failed but want %d", res.Failed, tt.wantFailed)
+			}
+			for i, code := range tt.wantCodes {
+				if res.Results[i].Error == nil || res.Results[i].Error.Code != code {
+					t.Errorf("result %d: got %+v but want code %q", i, res.Results[i].Error, code)
+				}
+			}
+
+			// A rolled back batch leaves the same movies behind, at newer
+			// versions and with the sequence moved on.
+			after, _ := serv.GetAllMovie(context.Background())
+			unchanged := len(after) == len(before)
+			for i := 0; unchanged && i < len(after); i++ {
+				got, want := after[i], before[i]
+				got.Version = want.Version
+				unchanged = got == want
+			}
+			if unchanged == tt.wantChanged {
+				t.Errorf("got movies %+v but want changed=%t from %+v", after, tt.wantChanged, before)
+			}
+			if trash, _ := serv.ListTrash(context.Background()); !tt.wantChanged && len(trash) != 0 {
+				t.Errorf("got trash %+v but want it empty after a rollback", trash)
+			}
+		})
+	}
+}
+
+// lostRestores fails every restore as if someone had changed the movie
+// first.
+type lostRestores struct {
+	movieService
+}
+
+func (lostRestores) RestoreMovie(ctx context.Context, id int, version int) (Movie, error) {
+	return Movie{}, errVersionConflict
+}
+
+func Test_runBatchRollbackFailed(t *testing.T) {
+	repo := newSeededRepo(t, []Movie{
+		{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8},
+		{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9},
+	})
+	serv := lostRestores{Newservice(repo)}
+
+	res, status := runBatch(context.Background(), serv, movieBatch{Mode: batchAtomic, Operations: []batchOperation{
+		{Op: "delete", ID: 1},
+		{Op: "delete", ID: 9},
+	}})
+
+	if status != http.StatusInternalServerError {
+		t.Errorf("got status %d but want %d", status, http.StatusInternalServerError)
+	}
+	if got, want := batchStatuses(res), []int{500, 404}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got statuses %v but want %v", got, want)
+	}
+	// The delete stuck, so it is not counted as failed.
+	if res.Failed != 1 {
+		t.Errorf("got %d failed but want 1", res.Failed)
+	}
+	if res.Results[0].Error == nil || res.Results[0].Error.Code != "rollback_failed" {
+		t.Errorf("got %+v but want code %q", res.Results[0].Error, "rollback_failed")
+	}
+}
+
+func Test_movieHandler_batchMovies(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		wantStatusCode int
+		wantCode       string
+		wantBody       string
+	}{
+		{
+			name:           "atomic by default",
+			body:           `{"operations": [{"op": "create", "movie": {"title": "singh", "director": "paramveer", "imdb": 7}}, {"op": "delete", "id": 1}]}`,
+			wantStatusCode: http.StatusOK,
+			wantBody:       `{"failed":0,"results":[{"status":201,"movie":{"id":2,`,
+		},
+		{
+			name:           "results carry problems",
+			body:           `{"mode": "best_effort", "operations": [{"op": "delete", "id": 3}]}`,
+			wantStatusCode: http.StatusOK,
+			wantBody:       `{"failed":1,"results":[{"status":404,"error":{"type":"/problems/movie_not_found"`,
+		},
+		{
+			name:           "aborted",
+			body:           `{"operations": [{"op": "delete", "id": 1}, {"op": "delete", "id": 3}]}`,
+			wantStatusCode: http.StatusUnprocessableEntity,
+			wantBody:       `{"failed":2,"results":[{"status":424,`,
+		},
+		{
+			name:           "unknown mode",
+			body:           `{"mode": "eventually", "operations": [{"op": "delete", "id": 1}]}`,
+			wantStatusCode: http.StatusBadRequest,
+			wantCode:       "invalid_batch",
+		},
+		{
+			name:           "no operations",
+			body:           `{"operations": []}`,
+			wantStatusCode: http.StatusBadRequest,
+			wantCode:       "invalid_batch",
+		},
+		{
+			name:           "too many operations",
+			body:           `{"operations": [` + strings.Repeat(`{"op": "delete", "id": 1},`, maxBatchOperations) + `{"op": "delete", "id": 1}]}`,
+			wantStatusCode: http.StatusBadRequest,
+			wantCode:       "invalid_batch",
+		},
+		{
+			name:           "invalid body",
+			body:           `[]`,
+			wantStatusCode: http.StatusBadRequest,
+			wantCode:       "invalid_body",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newSeededRepo(t, []Movie{{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8}})
+			router := registerRoutes(NewMovieHandler(Newservice(repo)))
+
+			req := httptest.NewRequest("POST", "/api/movies:batch", strings.NewReader(tt.body))
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatusCode {
+				t.Fatalf("want statuscode %d but got %d: %s", tt.wantStatusCode, res.Code, res.Body.String())
+			}
+			if tt.wantBody != "" && !strings.HasPrefix(res.Body.String(), tt.wantBody) {
+				t.Errorf("want body starting with %s but got %s", tt.wantBody, res.Body.String())
+			}
+			if tt.wantCode != "" {
+				var p problem
+				if err := json.Unmarshal(res.Body.Bytes(), &p); err != nil {
+					t.Fatalf("invalid problem body %q: %q", res.Body.String(), err)
+				}
+				if p.Code != tt.wantCode {
+					t.Errorf("want code %q but got %q", tt.wantCode, p.Code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	csvContentType    = "text/csv"
+	ndjsonContentType = "application/x-ndjson"
+
+	maxImportRows     = 10000
+	maxNDJSONLineSize = 1 << 20
+)
+
+var (
+	errInvalidImport     = errors.New("invalid import")
+	errInvalidRow        = errors.New("invalid row")
+	errUnsupportedFormat = errors.New("unsupported catalog format")
+)
+
+// catalogColumns are the CSV columns of an export, named like the JSON
+// fields of Movie. Imports map their header onto the same names in any
+// order and case; a missing column leaves the field empty. version is
+// exported for reference and ignored on import, since every imported movie
+// starts at version 1, and id and uid are only kept in import mode. Genres
+// are separated by semicolons.
+var catalogColumns = []string{"id", "uid", "title", "director", "imdb", "industry", "genres", "version"}
+
+// legacyCatalogColumns are still accepted on import, for files exported
+// before movies had an industry. They only count when industry is empty.
+var legacyCatalogColumns = []string{"hollywood", "bollywood"}
+
+// importRow is one movie read from an import, or why it could not be read.
+// Line is where it starts in the file, counting from 1.
+type importRow struct {
+	Line  int
+	Movie Movie
+	Err   error
+}
+
+// importReport is the response of POST /api/movies/import. Imported
+// counts the movies that were created, or would have been on a dry run.
+type importReport struct {
+	DryRun   bool          `json:"dry_run"`
+	Total    int           `json:"total"`
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	Errors   []importError `json:"errors"`
+}
+
+type importError struct {
+	Line  int     `json:"line"`
+	Error problem `json:"error"`
+}
+
+// catalogFormat picks the format of an export from ?format= and of an
+// import from its Content-Type. CSV is the default for both.
+func catalogFormat(name string) (string, error) {
+	switch name {
+	case "", "csv", csvContentType:
+		return "csv", nil
+	case "ndjson", ndjsonContentType, "application/ndjson":
+		return "ndjson", nil
+	default:
+		return "", fmt.Errorf("%w: %q", errUnsupportedFormat, name)
+	}
+}
+
+// exportMovies writes every live movie, in the order GET /api/movies
+// lists them without a sort. The movies are read in one go, so the export
+// is a consistent snapshot; writing them out is streamed.
+func (h *movieHandler) exportMovies(w http.ResponseWriter, r *http.Request) {
+	format, err := catalogFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		resolveError(w, r, fmt.Errorf("%w: format must be csv or ndjson", errInvalidQuery))
+		return
+	}
+
+	movies, err := h.serv.GetAllMovie(r.Context())
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", csvContentType+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+		w.WriteHeader(http.StatusOK)
+		err = writeCSVMovies(w, movies)
+	} else {
+		w.Header().Set("Content-Type", ndjsonContentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.ndjson"`)
+		w.WriteHeader(http.StatusOK)
+		err = writeNDJSONMovies(w, movies)
+	}
+	if err != nil {
+		log.Println("failed to send response:", err)
+	}
+}
+
+func writeCSVMovies(w io.Writer, movies []Movie) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(catalogColumns); err != nil {
+		return err
+	}
+	for _, movie := range movies {
+		record := []string{
+			strconv.Itoa(movie.ID),
+			movie.UID,
+			movie.Title,
+			movie.Director,
+			strconv.FormatFloat(movie.IMDb, 'f', -1, 64),
+			string(movie.Industry),
+			movie.Genres.String(),
+			strconv.Itoa(movie.Version),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeNDJSONMovies(w io.Writer, movies []Movie) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, movie := range movies {
+		if err := enc.Encode(movie); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// importMovies creates a movie for every row of a CSV or NDJSON body, as
+// POST /api/movies would, and reports the rows that failed. Rows are
+// independent: a bad row does not stop the others. With ?dry_run=true the
+// rows are only checked and nothing is written.
+func (h *movieHandler) importMovies(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = r.Header.Get("Content-Type")
+	}
+	format, err := catalogFormat(mediaType)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	dryRun := false
+	if raw := r.URL.Query().Get("dry_run"); raw != "" {
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			resolveError(w, r, fmt.Errorf("%w: dry_run must be true or false", errInvalidQuery))
+			return
+		}
+	}
+
+	var rows []importRow
+	if format == "csv" {
+		rows, err = readCSVMovies(r.Body)
+	} else {
+		rows, err = readNDJSONMovies(r.Body)
+	}
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	report := importReport{DryRun: dryRun, Total: len(rows), Errors: []importError{}}
+	var (
+		movies []Movie
+		lines  []int
+	)
+	for _, row := range rows {
+		if row.Err != nil {
+			report.Errors = append(report.Errors, importError{Line: row.Line, Error: newProblem(row.Err)})
+			continue
+		}
+		movies = append(movies, row.Movie)
+		lines = append(lines, row.Line)
+	}
+
+	for i, err := range h.serv.ImportMovies(r.Context(), movies, dryRun) {
+		if err != nil {
+			report.Errors = append(report.Errors, importError{Line: lines[i], Error: newProblem(err)})
+			continue
+		}
+		report.Imported++
+	}
+	report.Failed = len(report.Errors)
+	// Parse errors were collected first; report everything in file order.
+	slices.SortStableFunc(report.Errors, func(a, b importError) int { return cmp.Compare(a.Line, b.Line) })
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
+
+// readCSVMovies reads a CSV import. A malformed header fails the whole
+// import; a malformed row only fails that row.
+func readCSVMovies(r io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: the header row is missing", errInvalidImport)
+	}
+	if err != nil {
+		return nil, bodyError(errInvalidImport, err)
+	}
+	columns := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if i == 0 {
+			// Spreadsheets like to start UTF-8 files with a byte order mark.
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		if !isCatalogColumn(name) {
+			return nil, fmt.Errorf("%w: unknown column %q", errInvalidImport, header[i])
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: column %q appears twice", errInvalidImport, name)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("%w: at most %d rows are allowed", errInvalidImport, maxImportRows)
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, importRow{Line: parseErr.StartLine, Err: fmt.Errorf("%w: %v", errInvalidRow, parseErr.Err)})
+			continue
+		}
+		if err != nil {
+			return nil, bodyError(errInvalidImport, err)
+		}
+
+		line, _ := cr.FieldPos(0)
+		movie, err := csvMovie(columns, record)
+		rows = append(rows, importRow{Line: line, Movie: movie, Err: err})
+	}
+}
+
+func isCatalogColumn(name string) bool {
+	return slices.Contains(catalogColumns, name) || slices.Contains(legacyCatalogColumns, name)
+}
+
+func csvMovie(columns, record []string) (Movie, error) {
+	var (
+		movie                Movie
+		hollywood, bollywood string
+	)
+	for i, value := range record {
+		var err error
+		switch columns[i] {
+		case "id":
+			if value != "" {
+				movie.ID, err = strconv.Atoi(value)
+			}
+		case "uid":
+			movie.UID = value
+		case "title":
+			movie.Title = value
+		case "director":
+			movie.Director = value
+		case "imdb":
+			if value != "" {
+				movie.IMDb, err = strconv.ParseFloat(value, 64)
+			}
+		case "industry":
+			movie.Industry = Industry(value)
+		case "genres":
+			if movie.Genres, err = parseGenres(value, ";"); err != nil {
+				return Movie{}, fmt.Errorf("%w: genres: %v", errInvalidRow, err)
+			}
+		case "hollywood":
+			hollywood = value
+		case "bollywood":
+			bollywood = value
+		}
+		if err != nil {
+			return Movie{}, fmt.Errorf("%w: %s must be a number", errInvalidRow, columns[i])
+		}
+	}
+	if movie.Industry == "" {
+		movie.Industry = legacyIndustry(hollywood, bollywood)
+	}
+	return movie, nil
+}
+
+// readNDJSONMovies reads one JSON movie per line. Blank lines are skipped.
+func readNDJSONMovies(r io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLineSize)
+
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("%w: at most %d rows are allowed", errInvalidImport, maxImportRows)
+		}
+
+		movie, err := decodeStrictMovie([]byte(data))
+		if err != nil {
+			rows = append(rows, importRow{Line: line, Err: fmt.Errorf("%w: %v", errInvalidRow, err)})
+			continue
+		}
+		movie.Version = 0
+		movie.DeletedAt = nil
+		rows = append(rows, importRow{Line: line, Movie: movie})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, bodyError(errInvalidImport, err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_readCSVMovies(t *testing.T) {
+	tests := []struct {
+		name      string
+		csv       string
+		want      []importRow
+		wantErr   error
+		wantLines []int
+	}{
+		{
+			name: "header in any order and case",
+			csv:  "\ufeffTitle, IMDb,director\nbhamsa,8,paramveer\n\"hardik, again\",9.5,sharma\n",
+			want: []importRow{
+				{Line: 2, Movie: Movie{Title: "bhamsa", Director: "paramveer", IMDb: 8}},
+				{Line: 3, Movie: Movie{Title: "hardik, again", Director: "sharma", IMDb: 9.5}},
+			},
+		},
+		{
+			name: "version is ignored",
+			csv:  "id,title,version\n4,bhamsa,7\n",
+			want: []importRow{{Line: 2, Movie: Movie{ID: 4, Title: "bhamsa"}}},
+		},
+		{
+			name:      "bad rows fail on their own",
+			csv:       "title,imdb\nbhamsa,high\nhardik\n\"singh\n",
+			wantLines: []int{2, 3, 4},
+		},
+		{
+			name: "industry and genres",
+			csv:  "title,industry,genres\nbhamsa,korean,Drama; comedy\n",
+			want: []importRow{{Line: 2, Movie: Movie{Title: "bhamsa", Industry: IndustryKorean, Genres: GenreDrama | GenreComedy}}},
+		},
+		{
+			name: "legacy industry columns",
+			csv:  "title,hollywood,bollywood\nbhamsa,no,yes\nhardik,yes,yes\n",
+			want: []importRow{
+				{Line: 2, Movie: Movie{Title: "bhamsa", Industry: IndustryBollywood}},
+				{Line: 3, Movie: Movie{Title: "hardik"}},
+			},
+		},
+		{name: "unknown genre", csv: "title,genres\nbhamsa,noir\n", wantLines: []int{2}},
+		{name: "unknown column", csv: "title,budget\nbhamsa,100\n", wantErr: errInvalidImport},
+		{name: "column twice", csv: "title,Title\nbhamsa,bhamsa\n", wantErr: errInvalidImport},
+		{name: "no header", csv: "", wantErr: errInvalidImport},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readCSVMovies(strings.NewReader(tt.csv))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %q but got %q", tt.wantErr, err)
+			}
+
+			if tt.wantLines != nil {
+				for i, row := range got {
+					if !errors.Is(row.Err, errInvalidRow) || row.Line != tt.wantLines[i] {
+						t.Errorf("row %d: got %+v but want an invalid row on line %d", i, row, tt.wantLines[i])
+					}
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v but want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_movieHandler_exportMovies(t *testing.T) {
+	movies := []Movie{
+		{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Genres: GenreDrama | GenreComedy},
+		{ID: 2, Title: "hardik, again", Director: "sharma", IMDb: 9.5},
+	}
+
+	tests := []struct {
+		name            string
+		query           string
+		wantStatusCode  int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "csv by default",
+			wantStatusCode:  http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody: "id,uid,title,director,imdb,industry,genres,version\n" +
+				"1,,bhamsa,paramveer,8,bollywood,comedy;drama,1\n" +
+				"2,,\"hardik, again\",sharma,9.5,,,1\n",
+		},
+		{
+			name:            "ndjson",
+			query:           "?format=ndjson",
+			wantStatusCode:  http.StatusOK,
+			wantContentType: ndjsonContentType,
+			wantBody: `{"id":1,"title":"bhamsa","director":"paramveer","imdb":8,"industry":"bollywood","genres":["comedy","drama"],"version":1}` + "\n" +
+				`{"id":2,"title":"hardik, again","director":"sharma","imdb":9.5,"industry":"","genres":[],"version":1}` + "\n",
+		},
+		{
+			name:            "unknown format",
+			query:           "?format=xlsx",
+			wantStatusCode:  http.StatusBadRequest,
+			wantContentType: problemContentType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := registerRoutes(NewMovieHandler(Newservice(newSeededRepo(t, movies))))
+
+			req := httptest.NewRequest("GET", "/api/movies/export"+tt.query, nil)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatusCode {
+				t.Fatalf("want statuscode %d but got %d: %s", tt.wantStatusCode, res.Code, res.Body.String())
+			}
+			if got := res.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("want content type %q but got %q", tt.wantContentType, got)
+			}
+			if tt.wantBody != "" && res.Body.String() != tt.wantBody {
+				t.Errorf("want body\n%s\nbut got\n%s", tt.wantBody, res.Body.String())
+			}
+		})
+	}
+}
+
+func Test_movieHandler_importMovies(t *testing.T) {
+	existing := Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8}
+	trashed := Movie{ID: 2, Title: "dunki", Director: "hirani", IMDb: 6}
+
+	tests := []struct {
+		name           string
+		contentType    string
+		query          string
+		body           string
+		importMode     bool
+		wantStatusCode int
+		wantReport     importReport
+		wantCodes      []string
+		wantStored     int
+	}{
+		{
+			name:           "csv",
+			contentType:    "text/csv; charset=utf-8",
+			body:           "title,director,imdb\nhardik,sharma,9\nsingh,,7\nsingh,paramveer,7\n",
+			wantStatusCode: http.StatusOK,
+			wantReport:     importReport{Total: 3, Imported: 2, Failed: 1},
+			wantCodes:      []string{"invalid_movie"},
+			wantStored:     3,
+		},
+		{
+			name:           "dry run writes nothing",
+			contentType:    "text/csv",
+			query:          "?dry_run=true",
+			body:           "title,director,imdb\nhardik,sharma,9\nsingh,paramveer,70\n",
+			wantStatusCode: http.StatusOK,
+			wantReport:     importReport{DryRun: true, Total: 2, Imported: 1, Failed: 1},
+			wantCodes:      []string{"invalid_movie"},
+			wantStored:     1,
+		},
+		{
+			name:           "dry run checks ids",
+			contentType:    ndjsonContentType,
+			query:          "?dry_run=1",
+			importMode:     true,
+			body:           `{"id": 1, "title": "hardik", "director": "sharma", "imdb": 9}` + "\n" + `{"id": 2, "title": "dunki", "director": "hirani", "imdb": 6}` + "\n" + `{"id": 5, "title": "singh", "director": "paramveer", "imdb": 7}` + "\n" + `{"id": 5, "title": "singh", "director": "paramveer", "imdb": 7}`,
+			wantStatusCode: http.StatusOK,
+			wantReport:     importReport{DryRun: true, Total: 4, Imported: 1, Failed: 3},
+			wantCodes:      []string{"movie_conflict", "movie_conflict", "movie_conflict"},
+			wantStored:     1,
+		},
+		{
+			name:        "ndjson reports errors in file order",
+			contentType: ndjsonContentType,
+			body: `{"title": "hardik", "director": "sharma", "imdb": 11}` + "\n\n" +
+				`{"title": "singh", "budget": 5}` + "\n" +
+				`{"title": "singh", "director": "paramveer", "imdb": 7}`,
+			wantStatusCode: http.StatusOK,
+			wantReport:     importReport{Total: 3, Imported: 1, Failed: 2},
+			wantCodes:      []string{"invalid_movie", "invalid_row"},
+			wantStored:     2,
+		},
+		{
+			name:           "ids are reassigned outside import mode",
+			contentType:    "text/csv",
+			body:           "id,uid,title,director,imdb\n1,01HZX3J6V2Q0W7TQ8Z9Y4B5C6D,bhamsa,paramveer,8\n9,,singh,paramveer,7\n",
+			wantStatusCode: http.StatusOK,
+			wantReport:     importReport{Total: 2, Imported: 2},
+			wantStored:     3,
+		},
+		{
+			name:           "import mode keeps ids",
+			contentType:    ndjsonContentType,
+			importMode:     true,
+			body:           `{"id": 9, "title": "singh", "director": "paramveer", "imdb": 7}`,
+			wantStatusCode: http.StatusOK,
+			wantReport:     importReport{Total: 1, Imported: 1},
+			wantStored:     2,
+		},
+		{
+			name:           "unsupported content type",
+			contentType:    "application/vnd.ms-excel",
+			body:           "title\nhardik\n",
+			wantStatusCode: http.StatusUnsupportedMediaType,
+			wantStored:     1,
+		},
+		{
+			name:           "bad header",
+			contentType:    "text/csv",
+			body:           "name\nhardik\n",
+			wantStatusCode: http.StatusBadRequest,
+			wantStored:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newSeededRepo(t, []Movie{existing, trashed})
+			if _, err := repo.deleteMovie(context.Background(), trashed.ID, 0); err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+			var opts []serviceOption
+			if tt.importMode {
+				opts = append(opts, withClientIds())
+			}
+			router := registerRoutes(NewMovieHandler(Newservice(repo, opts...)))
+
+			req := httptest.NewRequest("POST", "/api/movies/import"+tt.query, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatusCode {
+				t.Fatalf("want statuscode %d but got %d: %s", tt.wantStatusCode, res.Code, res.Body.String())
+			}
+			if got := len(storedMovies(t, repo)); got != tt.wantStored {
+				t.Errorf("got %d stored movies but want %d", got, tt.wantStored)
+			}
+			if res.Code != http.StatusOK {
+				return
+			}
+
+			var report importReport
+			if err := json.Unmarshal(res.Body.Bytes(), &report); err != nil {
+				t.Fatalf("invalid report %q: %q", res.Body.String(), err)
+			}
+			var codes []string
+			for _, e := range report.Errors {
+				codes = append(codes, e.Error.Code)
+			}
+			if !reflect.DeepEqual(codes, tt.wantCodes) {
+				t.Errorf("got codes %v but want %v in %s", codes, tt.wantCodes, res.Body.String())
+			}
+			report.Errors = nil
+			if !reflect.DeepEqual(report, tt.wantReport) {
+				t.Errorf("got report %+v but want %+v", report, tt.wantReport)
+			}
+		})
+	}
+}
+
+func Test_catalogRoundTrip(t *testing.T) {
+	movies := []Movie{
+		{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 1},
+		{ID: 4, UID: "01HZX3J6V2Q0W7TQ8Z9Y4B5C6D", Title: "hardik, \"again\"", Director: "sharma", IMDb: 9.5, Version: 1},
+	}
+	source := registerRoutes(NewMovieHandler(Newservice(newSeededRepo(t, movies))))
+
+	for _, format := range []string{"csv", "ndjson"} {
+		t.Run(format, func(t *testing.T) {
+			target := NewInMemoryRepo()
+			sink := registerRoutes(NewMovieHandler(Newservice(target, withClientIds())))
+
+			exported := httptest.NewRecorder()
+			source.ServeHTTP(exported, httptest.NewRequest("GET", "/api/movies/export?format="+format, nil))
+
+			req := httptest.NewRequest("POST", "/api/movies/import", exported.Body)
+			req.Header.Set("Content-Type", exported.Header().Get("Content-Type"))
+			imported := httptest.NewRecorder()
+			sink.ServeHTTP(imported, req)
+
+			if !strings.Contains(imported.Body.String(), `"imported":2,"failed":0`) {
+				t.Fatalf("import failed: %s", imported.Body.String())
+			}
+			if got := storedMovies(t, target); !reflect.DeepEqual(got, movies) {
+				t.Errorf("got %+v but want %+v", got, movies)
+			}
+		})
+	}
+
+	// Without import mode an export can be imported back into the catalog
+	// it came from, as copies with new IDs.
+	t.Run("copies", func(t *testing.T) {
+		repo := newSeededRepo(t, movies)
+		router := registerRoutes(NewMovieHandler(Newservice(repo)))
+
+		exported := httptest.NewRecorder()
+		router.ServeHTTP(exported, httptest.NewRequest("GET", "/api/movies/export", nil))
+
+		req := httptest.NewRequest("POST", "/api/movies/import", exported.Body)
+		req.Header.Set("Content-Type", exported.Header().Get("Content-Type"))
+		imported := httptest.NewRecorder()
+		router.ServeHTTP(imported, req)
+
+		if !strings.Contains(imported.Body.String(), `"imported":2,"failed":0`) {
+			t.Fatalf("import failed: %s", imported.Body.String())
+		}
+		stored := storedMovies(t, repo)
+		if len(stored) != 4 || stored[2].ID != 5 || stored[3].ID != 6 || stored[3].UID != "" || stored[3].Title != movies[1].Title {
+			t.Errorf("got %+v but want copies of %+v with ids 5 and 6", stored, movies)
+		}
+	})
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the name of every environment variable the server reads.
+const envPrefix = "PARAMVEER_"
+
+// Storage backends for storageConfig.Backend.
+const (
+	backendMemory  = "memory"
+	backendJournal = "journal"
+	backendSQLite  = "sqlite"
+)
+
+// config is everything the server is started with. Each setting is read, in
+// increasing order of precedence, from defaultConfig, the YAML file named by
+// -config or PARAMVEER_CONFIG, a PARAMVEER_* environment variable and a
+// command line flag.
+type config struct {
+	Listen         string         `yaml:"listen"`
+	Storage        storageConfig  `yaml:"storage"`
+	Timeouts       timeoutsConfig `yaml:"timeouts"`
+	MaxBodyBytes   int64          `yaml:"max_body_bytes"`
+	LogLevel       string         `yaml:"log_level"`
+	IDStrategy     string         `yaml:"id_strategy"`
+	EventLogSize   int            `yaml:"event_log_size"`
+	TrashRetention time.Duration  `yaml:"trash_retention"`
+	Features       featureConfig  `yaml:"features"`
+}
+
+// storageConfig picks where movies are kept. Path is the SQLite database
+// file or the journal directory, and must be empty for the memory backend.
+type storageConfig struct {
+	Backend string `yaml:"backend"`
+	Path    string `yaml:"path"`
+}
+
+// timeoutsConfig bounds how long a connection may take to send a request,
+// to receive a response and to sit idle between requests, and how long
+// shutting down may take to drain requests. Zero means no limit.
+type timeoutsConfig struct {
+	Read     time.Duration `yaml:"read"`
+	Write    time.Duration `yaml:"write"`
+	Idle     time.Duration `yaml:"idle"`
+	Shutdown time.Duration `yaml:"shutdown"`
+}
+
+// featureConfig switches optional behaviour on.
+type featureConfig struct {
+	ImportMode     bool `yaml:"import_mode"`
+	RequireIfMatch bool `yaml:"require_if_match"`
+}
+
+func defaultConfig() config {
+	return config{
+		Listen:  ":8080",
+		Storage: storageConfig{Backend: backendMemory},
+		Timeouts: timeoutsConfig{
+			Read:     15 * time.Second,
+			Write:    30 * time.Second,
+			Idle:     2 * time.Minute,
+			Shutdown: 30 * time.Second,
+		},
+		MaxBodyBytes:   10 << 20,
+		LogLevel:       "info",
+		IDStrategy:     "sequence",
+		EventLogSize:   defaultEventLogSize,
+		TrashRetention: defaultTrashRetention,
+	}
+}
+
+// setting is a config field that can be set from the environment and the
+// command line. The environment variable is the flag name in upper case
+// with dashes turned into underscores, behind envPrefix.
+type setting struct {
+	name  string
+	usage string
+	// field points into c at a string, int, int64, bool or time.Duration.
+	field func(c *config) any
+}
+
+var settings = []setting{
+	{"listen", "address to listen on", func(c *config) any { return &c.Listen }},
+	{"storage", "where movies are kept: memory, journal or sqlite", func(c *config) any { return &c.Storage.Backend }},
+	{"storage-path", "SQLite database file or journal directory", func(c *config) any { return &c.Storage.Path }},
+	{"read-timeout", "how long a client may take to send a request; 0 means no limit", func(c *config) any { return &c.Timeouts.Read }},
+	{"write-timeout", "how long a response may take to be written; 0 means no limit", func(c *config) any { return &c.Timeouts.Write }},
+	{"idle-timeout", "how long a kept-alive connection may wait for its next request; 0 means no limit", func(c *config) any { return &c.Timeouts.Idle }},
+	{"shutdown-timeout", "how long shutting down waits for requests and streams to finish; 0 means no limit", func(c *config) any { return &c.Timeouts.Shutdown }},
+	{"max-body-bytes", "largest request body accepted", func(c *config) any { return &c.MaxBodyBytes }},
+	{"log-level", "least severe messages logged: debug, info, warn or error", func(c *config) any { return &c.LogLevel }},
+	{"id-strategy", "how new movies are labelled: sequence, ulid or uuid", func(c *config) any { return &c.IDStrategy }},
+	{"event-log-size", "number of recent movie events kept for clients resuming a change feed", func(c *config) any { return &c.EventLogSize }},
+	{"trash-retention", "how long deleted movies stay in the trash before they are purged; 0 keeps them forever", func(c *config) any { return &c.TrashRetention }},
+	{"import-mode", "accept client-supplied ids on POST /api/movies", func(c *config) any { return &c.Features.ImportMode }},
+	{"require-if-match", "reject PUT, PATCH and DELETE on a movie without an If-Match header", func(c *config) any { return &c.Features.RequireIfMatch }},
+}
+
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+func setField(field any, value string) error {
+	var err error
+	switch p := field.(type) {
+	case *string:
+		*p = value
+	case *int:
+		*p, err = strconv.Atoi(value)
+	case *int64:
+		*p, err = strconv.ParseInt(value, 10, 64)
+	case *bool:
+		*p, err = strconv.ParseBool(value)
+	case *time.Duration:
+		*p, err = time.ParseDuration(value)
+	default:
+		panic(fmt.Sprintf("unsupported setting type %T", field))
+	}
+	if errors.Is(err, strconv.ErrSyntax) || errors.Is(err, strconv.ErrRange) {
+		// strconv errors repeat the value and name the parser; the value
+		// is all the reader needs.
+		err = fmt.Errorf("invalid value %q", value)
+	}
+	return err
+}
+
+// fieldString formats a setting the way setField reads it.
+func fieldString(field any) string {
+	switch p := field.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *int64:
+		return strconv.FormatInt(*p, 10)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *time.Duration:
+		return p.String()
+	default:
+		panic(fmt.Sprintf("unsupported setting type %T", field))
+	}
+}
+
+// settingFlag is a flag.Value that only records what it was given, so
+// flags can be applied after the config file and the environment.
+type settingFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *settingFlag) String() string     { return f.value }
+func (f *settingFlag) Set(v string) error { f.value = v; return nil }
+func (f *settingFlag) IsBoolFlag() bool   { return f.isBool }
+
+// loadConfig builds the config from args, the environment as seen through
+// getenv, and the config file they name. Every invalid setting is reported,
+// not just the first, as one error per line.
+func loadConfig(args []string, getenv func(string) string, output io.Writer) (config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("paramveer", flag.ContinueOnError)
+	fs.SetOutput(output)
+	configPath := fs.String("config", "", "YAML config file; also "+envPrefix+"CONFIG")
+	dbPath := fs.String("db", "", "shorthand for -storage sqlite -storage-path `file`")
+	journalDir := fs.String("journal", "", "shorthand for -storage journal -storage-path `dir`")
+	flags := make([]*settingFlag, len(settings))
+	for i, s := range settings {
+		field := s.field(&cfg)
+		_, isBool := field.(*bool)
+		flags[i] = &settingFlag{value: fieldString(field), isBool: isBool}
+		fs.Var(flags[i], s.name, fmt.Sprintf("%s; also %s", s.usage, s.env()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+	if fs.NArg() > 0 {
+		return config{}, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var errs []error
+	path := getenv(envPrefix + "CONFIG")
+	if set["config"] {
+		path = *configPath
+	}
+	if path != "" {
+		if err := readConfigFile(path, &cfg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env()); value != "" {
+			if err := setField(s.field(&cfg), value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env(), err))
+			}
+		}
+	}
+
+	for i, s := range settings {
+		if set[s.name] {
+			if err := setField(s.field(&cfg), flags[i].value); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", s.name, err))
+			}
+		}
+	}
+	switch {
+	case set["db"] && set["journal"]:
+		errs = append(errs, errors.New("-db and -journal cannot be used together"))
+	case set["db"]:
+		cfg.Storage = storageConfig{Backend: backendSQLite, Path: *dbPath}
+	case set["journal"]:
+		cfg.Storage = storageConfig{Backend: backendJournal, Path: *journalDir}
+	}
+
+	errs = append(errs, cfg.validate()...)
+	return cfg, errors.Join(errs...)
+}
+
+func readConfigFile(path string, cfg *config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// validate reports every setting that is out of range, naming it the way
+// the YAML file does.
+func (c config) validate() []error {
+	var errs []error
+	invalid := func(name, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{name}, args...)...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		invalid("listen", "must be host:port, got %q", c.Listen)
+	}
+	switch c.Storage.Backend {
+	case backendMemory:
+		if c.Storage.Path != "" {
+			invalid("storage.path", "must be empty for the %s backend", backendMemory)
+		}
+	case backendJournal, backendSQLite:
+		if c.Storage.Path == "" {
+			invalid("storage.path", "is required for the %s backend", c.Storage.Backend)
+		}
+	default:
+		invalid("storage.backend", "must be %s, %s or %s, got %q", backendMemory, backendJournal, backendSQLite, c.Storage.Backend)
+	}
+	for _, timeout := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"timeouts.read", c.Timeouts.Read},
+		{"timeouts.write", c.Timeouts.Write},
+		{"timeouts.idle", c.Timeouts.Idle},
+		{"timeouts.shutdown", c.Timeouts.Shutdown},
+	} {
+		if timeout.d < 0 {
+			invalid(timeout.name, "must not be negative, got %s", timeout.d)
+		}
+	}
+	if c.MaxBodyBytes <= 0 {
+		invalid("max_body_bytes", "must be positive, got %d", c.MaxBodyBytes)
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		invalid("log_level", "%v", err)
+	}
+	if _, err := parseIDStrategy(c.IDStrategy); err != nil {
+		invalid("id_strategy", "%v", err)
+	}
+	if c.EventLogSize < 0 {
+		invalid("event_log_size", "must not be negative, got %d", c.EventLogSize)
+	}
+	if c.TrashRetention < 0 {
+		invalid("trash_retention", "must not be negative, got %s", c.TrashRetention)
+	}
+	return errs
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_loadConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "paramveer.yaml")
+	if err := os.WriteFile(file, []byte(`
+listen: "127.0.0.1:9000"
+storage:
+  backend: journal
+  path: /var/lib/paramveer
+timeouts:
+  read: 5s
+log_level: warn
+features:
+  import_mode: true
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		want    func(c *config)
+		wantErr []string
+	}{
+		{name: "defaults", want: func(c *config) {}},
+		{
+			name: "file",
+			args: []string{"-config", file},
+			want: func(c *config) {
+				c.Listen = "127.0.0.1:9000"
+				c.Storage = storageConfig{Backend: backendJournal, Path: "/var/lib/paramveer"}
+				c.Timeouts.Read = 5 * time.Second
+				c.LogLevel = "warn"
+				c.Features.ImportMode = true
+			},
+		},
+		{
+			name: "env overrides the file",
+			env:  map[string]string{"PARAMVEER_CONFIG": file, "PARAMVEER_READ_TIMEOUT": "1m", "PARAMVEER_IMPORT_MODE": "false"},
+			want: func(c *config) {
+				c.Listen = "127.0.0.1:9000"
+				c.Storage = storageConfig{Backend: backendJournal, Path: "/var/lib/paramveer"}
+				c.Timeouts.Read = time.Minute
+				c.LogLevel = "warn"
+			},
+		},
+		{
+			name: "flags override the env",
+			args: []string{"-listen", ":7000", "-require-if-match", "-db", "movies.db"},
+			env:  map[string]string{"PARAMVEER_LISTEN": ":6000", "PARAMVEER_MAX_BODY_BYTES": "1024"},
+			want: func(c *config) {
+				c.Listen = ":7000"
+				c.Storage = storageConfig{Backend: backendSQLite, Path: "movies.db"}
+				c.MaxBodyBytes = 1024
+				c.Features.RequireIfMatch = true
+			},
+		},
+		{
+			name: "every problem is reported",
+			args: []string{"-storage", "postgres", "-idle-timeout", "-1s", "-log-level", "loud"},
+			env:  map[string]string{"PARAMVEER_EVENT_LOG_SIZE": "many", "PARAMVEER_LISTEN": "8080"},
+			wantErr: []string{
+				`PARAMVEER_EVENT_LOG_SIZE: invalid value "many"`,
+				`listen: must be host:port, got "8080"`,
+				`storage.backend: must be memory, journal or sqlite, got "postgres"`,
+				`timeouts.idle: must not be negative, got -1s`,
+				`log_level: unknown log level "loud"`,
+			},
+		},
+		{
+			name:    "storage needs a path",
+			args:    []string{"-storage", "sqlite"},
+			wantErr: []string{`storage.path: is required for the sqlite backend`},
+		},
+		{
+			name:    "db and journal",
+			args:    []string{"-db", "movies.db", "-journal", "journal"},
+			wantErr: []string{`-db and -journal cannot be used together`},
+		},
+		{
+			name:    "missing file",
+			args:    []string{"-config", filepath.Join(dir, "missing.yaml")},
+			wantErr: []string{`config file: open ` + filepath.Join(dir, "missing.yaml") + `: no such file or directory`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string { return tt.env[key] }
+			got, err := loadConfig(tt.args, getenv, &bytes.Buffer{})
+
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatalf("want errors %q but got none", tt.wantErr)
+				}
+				if got := strings.Split(err.Error(), "\n"); strings.Join(got, "\n") != strings.Join(tt.wantErr, "\n") {
+					t.Errorf("got errors\n%s\nbut want\n%s", err, strings.Join(tt.wantErr, "\n"))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+			want := defaultConfig()
+			tt.want(&want)
+			if got != want {
+				t.Errorf("got %+v but want %+v", got, want)
+			}
+		})
+	}
+}
+
+func Test_loadConfigRejectsUnknownFileFields(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "paramveer.yaml")
+	if err := os.WriteFile(file, []byte("listen: \":8080\"\nport: 8080\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := loadConfig([]string{"-config", file}, func(string) string { return "" }, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "field port not found") {
+		t.Errorf("got %v but want the unknown field reported", err)
+	}
+}
+
+func Test_loadConfigHelp(t *testing.T) {
+	var out bytes.Buffer
+	_, err := loadConfig([]string{"-h"}, func(string) string { return "" }, &out)
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("want error %q but got %q", flag.ErrHelp, err)
+	}
+	if !strings.Contains(out.String(), "PARAMVEER_WRITE_TIMEOUT") {
+		t.Errorf("help does not name the environment variables:\n%s", out.String())
+	}
+}
+
+func Test_withBodyLimit(t *testing.T) {
+	router := registerRoutes(NewMovieHandler(Newservice(NewInMemoryRepo())))
+	router.Use(withBodyLimit(64))
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		chunked        bool
+		wantStatusCode int
+		wantCode       string
+	}{
+		{name: "small body", body: `{"title":"bhamsa","director":"paramveer","imdb":8}`, wantStatusCode: http.StatusCreated},
+		{name: "announced too large", body: `{"title":"` + strings.Repeat("a", 64) + `"}`, wantStatusCode: http.StatusRequestEntityTooLarge, wantCode: "body_too_large"},
+		{name: "streamed too large", body: `{"title":"` + strings.Repeat("a", 64) + `"}`, chunked: true, wantStatusCode: http.StatusRequestEntityTooLarge, wantCode: "body_too_large"},
+		{name: "streamed import too large", path: "/api/movies/import", body: "title,director,imdb\n" + strings.Repeat("bhamsa,paramveer,8\n", 4), chunked: true, wantStatusCode: http.StatusRequestEntityTooLarge, wantCode: "body_too_large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path
+			if path == "" {
+				path = "/api/movies"
+			}
+			req := httptest.NewRequest("POST", path, strings.NewReader(tt.body))
+			if path == "/api/movies/import" {
+				req.Header.Set("Content-Type", "text/csv")
+			}
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatusCode {
+				t.Fatalf("want statuscode %d but got %d: %s", tt.wantStatusCode, res.Code, res.Body.String())
+			}
+			if tt.wantCode != "" && !strings.Contains(res.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("want code %q but got %s", tt.wantCode, res.Body.String())
+			}
+		})
+	}
+}
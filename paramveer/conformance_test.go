@@ -0,0 +1,690 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRepoConformance checks the behaviour every Repo implementation must
+// share. newRepo must return an empty, independent repo on every call.
+// The fixtures carry version 1, the version every repo stores on create.
+func testRepoConformance(t *testing.T, newRepo func() Repo) {
+	bhamsa := Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 1}
+	hardik := Movie{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9.5, Industry: IndustryHollywood, Version: 1}
+	singh := Movie{ID: 3, Title: "singh", Director: "paramveer", IMDb: 7.1, Industry: IndustryBollywood, Version: 1}
+
+	seed := func(t *testing.T, repo Repo, movies ...Movie) {
+		t.Helper()
+		for _, movie := range movies {
+			if err := repo.createMovie(context.Background(), movie); err != nil {
+				t.Fatalf("failed to seed movie %+v: %q", movie, err)
+			}
+		}
+	}
+
+	list := func(t *testing.T, repo Repo) []Movie {
+		t.Helper()
+		movies, err := repo.getAllMovie(context.Background())
+		if err != nil {
+			t.Fatalf("failed to list movies: %q", err)
+		}
+		return movies
+	}
+
+	t.Run("empty repo lists no movies", func(t *testing.T) {
+		repo := newRepo()
+
+		if got := list(t, repo); got == nil || len(got) != 0 {
+			t.Errorf("got %#v but want an empty, non-nil slice", got)
+		}
+	})
+
+	t.Run("create then get", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa)
+
+		got, err := repo.getMovieById(context.Background(), bhamsa.ID)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if got != bhamsa {
+			t.Errorf("got %+v but want %+v", got, bhamsa)
+		}
+	})
+
+	t.Run("create ignores version and trash state", func(t *testing.T) {
+		repo := newRepo()
+		at := time.Now()
+		given := bhamsa
+		given.Version = 7
+		given.DeletedAt = &at
+		seed(t, repo, given)
+
+		if got, err := repo.getMovieById(context.Background(), bhamsa.ID); err != nil || got != bhamsa {
+			t.Errorf("got %+v, %v but want %+v", got, err, bhamsa)
+		}
+	})
+
+	t.Run("create conflict", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa)
+
+		duplicate := hardik
+		duplicate.ID = bhamsa.ID
+		if err := repo.createMovie(context.Background(), duplicate); !errors.Is(err, errConflict) {
+			t.Errorf("want error %q but got %q", errConflict, err)
+		}
+
+		if got, want := list(t, repo), []Movie{bhamsa}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+	})
+
+	t.Run("get missing", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa)
+
+		if _, err := repo.getMovieById(context.Background(), 42); !errors.Is(err, errNotFound) {
+			t.Errorf("want error %q but got %q", errNotFound, err)
+		}
+	})
+
+	t.Run("listing keeps insertion order", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, singh, bhamsa, hardik)
+
+		if got, want := list(t, repo), []Movie{singh, bhamsa, hardik}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+	})
+
+	t.Run("update keeps position", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa, hardik, singh)
+
+		updated := hardik
+		updated.IMDb = 6.5
+		got, err := repo.updateMovie(context.Background(), hardik.ID, updated)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		updated.Version = 2
+		if got != updated {
+			t.Errorf("got %+v but want %+v", got, updated)
+		}
+
+		if got, want := list(t, repo), []Movie{bhamsa, updated, singh}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+	})
+
+	t.Run("update keeps the uid", func(t *testing.T) {
+		repo := newRepo()
+		labelled := bhamsa
+		labelled.UID = "01ARZ3NDEKTSV4RRFFQ69G5FAV"
+		seed(t, repo, labelled)
+
+		for _, uid := range []string{"", "mine"} {
+			updated := labelled
+			updated.UID = uid
+			updated.Version = 0
+			got, err := repo.updateMovie(context.Background(), labelled.ID, updated)
+			if err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+			if got.UID != labelled.UID {
+				t.Errorf("update with uid %q stored %q but want %q", uid, got.UID, labelled.UID)
+			}
+		}
+
+		stored, err := repo.getMovieById(context.Background(), labelled.ID)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if stored.UID != labelled.UID {
+			t.Errorf("got uid %q but want %q", stored.UID, labelled.UID)
+		}
+	})
+
+	t.Run("update missing", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa)
+
+		missing := hardik
+		if _, err := repo.updateMovie(context.Background(), missing.ID, missing); !errors.Is(err, errNotFound) {
+			t.Errorf("want error %q but got %q", errNotFound, err)
+		}
+
+		if got, want := list(t, repo), []Movie{bhamsa}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+	})
+
+	t.Run("version conflict", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa, hardik)
+
+		stale := hardik
+		stale.Version = 2
+		if _, err := repo.updateMovie(context.Background(), hardik.ID, stale); !errors.Is(err, errVersionConflict) {
+			t.Errorf("update: want error %q but got %q", errVersionConflict, err)
+		}
+		if _, err := repo.deleteMovie(context.Background(), hardik.ID, 2); !errors.Is(err, errVersionConflict) {
+			t.Errorf("delete: want error %q but got %q", errVersionConflict, err)
+		}
+		if _, err := repo.deleteMovie(context.Background(), 42, 1); !errors.Is(err, errNotFound) {
+			t.Errorf("delete missing: want error %q but got %q", errNotFound, err)
+		}
+
+		// Version 0 skips the check; each update bumps the version.
+		unchecked := hardik
+		unchecked.Version = 0
+		unchecked.IMDb = 6
+		if got, err := repo.updateMovie(context.Background(), hardik.ID, unchecked); err != nil || got.Version != 2 {
+			t.Fatalf("got %+v, %v but want version 2", got, err)
+		}
+		if got, err := repo.deleteMovie(context.Background(), hardik.ID, 2); err != nil || got.Version != 3 {
+			t.Errorf("got %+v, %v but want version 3", got, err)
+		}
+
+		if got, want := list(t, repo), []Movie{bhamsa}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+	})
+
+	// trashed checks that got is want moved to the trash, one version later.
+	trashed := func(t *testing.T, got, want Movie) {
+		t.Helper()
+		if got.DeletedAt == nil {
+			t.Errorf("got %+v but want it trashed", got)
+			return
+		}
+		got.DeletedAt = nil
+		want.Version++
+		if got != want {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+	}
+
+	t.Run("delete then get", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa, hardik, singh)
+
+		got, err := repo.deleteMovie(context.Background(), hardik.ID, 0)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		trashed(t, got, hardik)
+
+		if _, err := repo.getMovieById(context.Background(), hardik.ID); !errors.Is(err, errNotFound) {
+			t.Errorf("want error %q but got %q", errNotFound, err)
+		}
+		if _, err := repo.updateMovie(context.Background(), hardik.ID, hardik); !errors.Is(err, errNotFound) {
+			t.Errorf("want error %q but got %q", errNotFound, err)
+		}
+		if _, err := repo.deleteMovie(context.Background(), hardik.ID, 0); !errors.Is(err, errNotFound) {
+			t.Errorf("want error %q but got %q", errNotFound, err)
+		}
+
+		if got, want := list(t, repo), []Movie{bhamsa, singh}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+		page, err := repo.listMovies(context.Background(), movieQuery{})
+		if err != nil || page.Total != 2 {
+			t.Errorf("got %+v, %v but want 2 movies", page, err)
+		}
+		trash, err := repo.listTrash(context.Background())
+		if err != nil || len(trash) != 1 {
+			t.Fatalf("got trash %+v, %v but want one movie", trash, err)
+		}
+		trashed(t, trash[0], hardik)
+		if n, err := repo.countTrash(context.Background()); err != nil || n != 1 {
+			t.Errorf("got trash count %d, %v but want 1", n, err)
+		}
+	})
+
+	t.Run("trashed movies keep their id", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa, hardik)
+
+		if _, err := repo.deleteMovie(context.Background(), bhamsa.ID, 0); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if err := repo.createMovie(context.Background(), bhamsa); !errors.Is(err, errConflict) {
+			t.Errorf("create: want error %q but got %q", errConflict, err)
+		}
+		rekeyed := hardik
+		rekeyed.ID = bhamsa.ID
+		if _, err := repo.updateMovie(context.Background(), hardik.ID, rekeyed); !errors.Is(err, errConflict) {
+			t.Errorf("update: want error %q but got %q", errConflict, err)
+		}
+
+		// Once purged the ID is free again.
+		if _, err := repo.purgeMovie(context.Background(), bhamsa.ID); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		seed(t, repo, bhamsa)
+
+		if got, want := list(t, repo), []Movie{hardik, bhamsa}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa, hardik, singh)
+
+		if _, err := repo.restoreMovie(context.Background(), hardik.ID, 0); !errors.Is(err, errNotFound) {
+			t.Errorf("live movie: want error %q but got %q", errNotFound, err)
+		}
+		if _, err := repo.restoreMovie(context.Background(), 42, 0); !errors.Is(err, errNotFound) {
+			t.Errorf("missing movie: want error %q but got %q", errNotFound, err)
+		}
+
+		if _, err := repo.deleteMovie(context.Background(), hardik.ID, 0); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if _, err := repo.restoreMovie(context.Background(), hardik.ID, 1); !errors.Is(err, errVersionConflict) {
+			t.Errorf("stale version: want error %q but got %q", errVersionConflict, err)
+		}
+		got, err := repo.restoreMovie(context.Background(), hardik.ID, 2)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		restored := hardik
+		restored.Version = 3
+		if got != restored {
+			t.Errorf("got %+v but want %+v", got, restored)
+		}
+
+		// A restored movie is back in its old place.
+		if got, want := list(t, repo), []Movie{bhamsa, restored, singh}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+		if trash, err := repo.listTrash(context.Background()); err != nil || len(trash) != 0 {
+			t.Errorf("got trash %+v, %v but want it empty", trash, err)
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa, hardik, singh)
+
+		if _, err := repo.purgeMovie(context.Background(), bhamsa.ID); !errors.Is(err, errNotFound) {
+			t.Errorf("live movie: want error %q but got %q", errNotFound, err)
+		}
+
+		if _, err := repo.deleteMovie(context.Background(), bhamsa.ID, 0); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		got, err := repo.purgeMovie(context.Background(), bhamsa.ID)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		trashed(t, got, bhamsa)
+		if _, err := repo.restoreMovie(context.Background(), bhamsa.ID, 0); !errors.Is(err, errNotFound) {
+			t.Errorf("restore purged: want error %q but got %q", errNotFound, err)
+		}
+
+		// purgeTrash only takes what was trashed before the cutoff.
+		if _, err := repo.deleteMovie(context.Background(), hardik.ID, 0); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		cutoff := time.Now()
+		time.Sleep(2 * time.Millisecond)
+		if _, err := repo.deleteMovie(context.Background(), singh.ID, 0); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if n, err := repo.purgeTrash(context.Background(), cutoff); err != nil || n != 1 {
+			t.Errorf("got %d, %v but want 1 movie purged", n, err)
+		}
+		trash, err := repo.listTrash(context.Background())
+		if err != nil || len(trash) != 1 || trash[0].ID != singh.ID {
+			t.Errorf("got trash %+v, %v but want only singh", trash, err)
+		}
+		if n, err := repo.purgeTrash(context.Background(), time.Now()); err != nil || n != 1 {
+			t.Errorf("got %d, %v but want 1 movie purged", n, err)
+		}
+		if got := list(t, repo); len(got) != 0 {
+			t.Errorf("got %+v but want no movies", got)
+		}
+	})
+
+	t.Run("revisions", func(t *testing.T) {
+		repo := newRepo()
+		ctx := context.WithValue(context.Background(), actorKey{}, "asha")
+		before := time.Now().Add(-time.Second)
+
+		var written []Movie
+		if err := repo.createMovie(ctx, bhamsa); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		written = append(written, bhamsa)
+		changed := bhamsa
+		changed.IMDb = 9
+		steps := []func() (Movie, error){
+			func() (Movie, error) { return repo.updateMovie(ctx, bhamsa.ID, changed) },
+			func() (Movie, error) { return repo.deleteMovie(ctx, bhamsa.ID, 0) },
+			func() (Movie, error) { return repo.restoreMovie(ctx, bhamsa.ID, 0) },
+			func() (Movie, error) {
+				rekeyed := changed
+				rekeyed.ID = 7
+				rekeyed.Version = 0
+				return repo.updateMovie(context.Background(), bhamsa.ID, rekeyed)
+			},
+		}
+		for _, step := range steps {
+			movie, err := step()
+			if err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+			written = append(written, movie)
+		}
+
+		// The history follows the movie to its new ID.
+		if _, err := repo.listRevisions(context.Background(), bhamsa.ID); !errors.Is(err, errNotFound) {
+			t.Errorf("old id: want error %q but got %q", errNotFound, err)
+		}
+		revisions, err := repo.listRevisions(context.Background(), 7)
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		changes := []string{movieCreated, movieUpdated, movieDeleted, movieRestored, movieUpdated}
+		actors := []string{"asha", "asha", "asha", "asha", anonymousActor}
+		if len(revisions) != len(written) {
+			t.Fatalf("got %d revisions but want %d: %+v", len(revisions), len(written), revisions)
+		}
+		for i, rev := range revisions {
+			if rev.Number != i+1 || rev.Change != changes[i] || rev.Actor != actors[i] || !reflect.DeepEqual(rev.Movie, written[i]) {
+				t.Errorf("revision %d: got %+v but want %s by %s of %+v", i+1, rev, changes[i], actors[i], written[i])
+			}
+			if rev.Time.Before(before) || rev.Time.After(time.Now()) {
+				t.Errorf("revision %d: time %v is not when it was written", i+1, rev.Time)
+			}
+		}
+		if at := revisions[2].Time; !at.Equal(*written[2].DeletedAt) {
+			t.Errorf("deletion revision at %v but movie deleted at %v", at, written[2].DeletedAt)
+		}
+
+		// Purging drops the history along with the movie.
+		if _, err := repo.deleteMovie(ctx, 7, 0); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if _, err := repo.purgeMovie(ctx, 7); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if _, err := repo.listRevisions(ctx, 7); !errors.Is(err, errNotFound) {
+			t.Errorf("purged: want error %q but got %q", errNotFound, err)
+		}
+		seed(t, repo, Movie{ID: 7, Title: "singh", Director: "paramveer", IMDb: 7})
+		if revisions, err := repo.listRevisions(ctx, 7); err != nil || len(revisions) != 1 {
+			t.Errorf("got %+v, %v but want only the new movie's revision", revisions, err)
+		}
+	})
+
+	t.Run("listing is a copy", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa, hardik)
+
+		got := list(t, repo)
+		got[0].Title = "changed"
+
+		if got, want := list(t, repo), []Movie{bhamsa, hardik}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+	})
+
+	t.Run("id sequence", func(t *testing.T) {
+		repo := newRepo()
+
+		next := func() int {
+			t.Helper()
+			id, err := repo.nextMovieID(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+			return id
+		}
+
+		if got := next(); got != 1 {
+			t.Errorf("got id %d but want 1", got)
+		}
+
+		// Movies created with an explicit ID push the sequence past it.
+		seed(t, repo, singh)
+		if got := next(); got != singh.ID+1 {
+			t.Errorf("got id %d but want %d", got, singh.ID+1)
+		}
+
+		// IDs are not reused once the newest movie is gone.
+		if _, err := repo.deleteMovie(context.Background(), singh.ID, 0); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if got := next(); got != singh.ID+2 {
+			t.Errorf("got id %d but want %d", got, singh.ID+2)
+		}
+	})
+
+	t.Run("purged ids are not reused", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, Movie{ID: 50, Title: "bhamsa", Director: "paramveer", IMDb: 8}, Movie{ID: 1, Title: "hardik", Director: "sharma", IMDb: 9})
+		// Moved rather than created with the high ID.
+		if _, err := repo.updateMovie(context.Background(), 1, Movie{ID: 60, Title: "hardik", Director: "sharma", IMDb: 9}); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		for _, id := range []int{50, 60} {
+			if _, err := repo.deleteMovie(context.Background(), id, 0); err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+			if _, err := repo.purgeMovie(context.Background(), id); err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+		}
+
+		if id, err := repo.nextMovieID(context.Background()); err != nil || id != 61 {
+			t.Errorf("got id %d, %v but want 61", id, err)
+		}
+	})
+
+	t.Run("list movies", func(t *testing.T) {
+		kingdom := Movie{ID: 4, Title: "Kingdom", Director: "Sharma", IMDb: 8, Industry: IndustryHollywood, Genres: GenreDrama | GenreComedy | GenreWar, Version: 1}
+		// Case is folded for every letter, not just ASCII ones.
+		emile := Movie{ID: 5, Title: "Émile", Director: "Öztürk", IMDb: 6, Version: 1}
+		elan := Movie{ID: 6, Title: "élan", Director: "ÖZTÜRK", IMDb: 6, Version: 1}
+		movies := []Movie{singh, bhamsa, hardik, kingdom, emile, elan}
+
+		repo := newRepo()
+		seed(t, repo, movies...)
+
+		rating := func(v float64) *float64 { return &v }
+		queries := map[string]movieQuery{
+			"everything":         {},
+			"director":           {Director: "PARAMVEER"},
+			"title contains":     {TitleContains: "in"},
+			"rating range":       {MinIMDb: rating(7.1), MaxIMDb: rating(8)},
+			"industry":           {Industries: []Industry{IndustryHollywood}},
+			"industries":         {Industries: []Industry{IndustryHollywood, IndustryKorean}},
+			"unknown industry":   {Industries: []Industry{"", IndustryKorean}},
+			"genres":             {Genres: GenreDrama | GenreComedy},
+			"sort with ties":     {Sort: []sortKey{{Field: "imdb", Desc: true}}},
+			"sort several":       {Sort: []sortKey{{Field: "director"}, {Field: "title", Desc: true}}},
+			"non-ascii director": {Director: "öztürk"},
+			"non-ascii title":    {TitleContains: "ÉLA"},
+			"non-ascii sort":     {Sort: []sortKey{{Field: "title"}}},
+			"first page":         {Sort: []sortKey{{Field: "id"}}, Limit: 3},
+			"second page":        {Sort: []sortKey{{Field: "id"}}, Limit: 3, Cursor: encodeCursor(3)},
+			"past the end":       {Limit: 3, Cursor: encodeCursor(10)},
+			"filtered and paged": {Industries: []Industry{IndustryBollywood}, Limit: 1, Cursor: encodeCursor(1)},
+		}
+		for name, q := range queries {
+			want, err := applyMovieQuery(movies, q)
+			if err != nil {
+				t.Fatalf("%s: unexpected error %q", name, err)
+			}
+
+			got, err := repo.listMovies(context.Background(), q)
+			if err != nil {
+				t.Errorf("%s: unexpected error %q", name, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: got %+v but want %+v", name, got, want)
+			}
+		}
+	})
+
+	t.Run("movie stats", func(t *testing.T) {
+		kingdom := Movie{ID: 4, Title: "Kingdom", Director: "sharma", IMDb: 10, Industry: IndustryKorean, Genres: GenreDrama, Version: 1}
+		trashed := Movie{ID: 5, Title: "dunki", Director: "hirani", IMDb: 4, Version: 1}
+		movies := []Movie{singh, bhamsa, hardik, kingdom}
+
+		repo := newRepo()
+		seed(t, repo, append(movies, trashed)...)
+		if _, err := repo.deleteMovie(context.Background(), trashed.ID, 0); err != nil {
+			t.Fatalf("failed to trash movie: %q", err)
+		}
+
+		everything := []string{metricCount, metricAvg, metricMin, metricMax, metricPercentiles, metricHistogram}
+		queries := map[string]statsQuery{
+			"everything":   {Metrics: everything, BucketWidth: 1},
+			"director":     {GroupBy: groupByDirector, Metrics: everything, BucketWidth: 0.5},
+			"industry":     {GroupBy: groupByIndustry, Metrics: everything, BucketWidth: 0.1},
+			"no histogram": {GroupBy: groupByIndustry, Metrics: defaultStatsMetrics, BucketWidth: 1},
+		}
+		for name, q := range queries {
+			want := aggregateMovies(movies, q)
+
+			got, err := repo.movieStats(context.Background(), q)
+			if err != nil {
+				t.Errorf("%s: unexpected error %q", name, err)
+				continue
+			}
+			// Repos may sum ratings in a different order.
+			for _, groups := range [][]statsGroup{got, want} {
+				for i := range groups {
+					avg := roundRating(*groups[i].AvgIMDb)
+					groups[i].AvgIMDb = &avg
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: got %+v but want %+v", name, got, want)
+			}
+		}
+
+		empty, err := newRepo().movieStats(context.Background(), statsQuery{Metrics: everything, BucketWidth: 1})
+		if err != nil || empty == nil || len(empty) != 0 {
+			t.Errorf("got %#v, %v but want no groups for an empty repo", empty, err)
+		}
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		repo := newRepo()
+		if err := repo.ping(context.Background()); err != nil {
+			t.Errorf("unexpected error %q", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := repo.ping(ctx); err == nil {
+			t.Errorf("ping succeeded with a cancelled context")
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		repo := newRepo()
+		seed(t, repo, bhamsa)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := repo.createMovie(ctx, hardik); !errors.Is(err, context.Canceled) {
+			t.Errorf("create: want error %q but got %q", context.Canceled, err)
+		}
+		if _, err := repo.getAllMovie(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("list: want error %q but got %q", context.Canceled, err)
+		}
+		if _, err := repo.getMovieById(ctx, bhamsa.ID); !errors.Is(err, context.Canceled) {
+			t.Errorf("get: want error %q but got %q", context.Canceled, err)
+		}
+		if _, err := repo.updateMovie(ctx, bhamsa.ID, singh); !errors.Is(err, context.Canceled) {
+			t.Errorf("update: want error %q but got %q", context.Canceled, err)
+		}
+		if _, err := repo.deleteMovie(ctx, bhamsa.ID, 0); !errors.Is(err, context.Canceled) {
+			t.Errorf("delete: want error %q but got %q", context.Canceled, err)
+		}
+
+		if got, want := list(t, repo), []Movie{bhamsa}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+	})
+
+	t.Run("concurrent access", func(t *testing.T) {
+		const workers, perWorker = 8, 25
+
+		repo := newRepo()
+
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < perWorker; i++ {
+					movie := bhamsa
+					movie.ID = w*perWorker + i + 1
+
+					if err := repo.createMovie(context.Background(), movie); err != nil {
+						t.Errorf("create %d: %q", movie.ID, err)
+						return
+					}
+					if _, err := repo.getMovieById(context.Background(), movie.ID); err != nil {
+						t.Errorf("get %d: %q", movie.ID, err)
+						return
+					}
+					if _, err := repo.getAllMovie(context.Background()); err != nil {
+						t.Errorf("list: %q", err)
+						return
+					}
+
+					movie.IMDb = 9
+					if _, err := repo.updateMovie(context.Background(), movie.ID, movie); err != nil {
+						t.Errorf("update %d: %q", movie.ID, err)
+						return
+					}
+					if i%2 == 1 {
+						if _, err := repo.deleteMovie(context.Background(), movie.ID, 0); err != nil {
+							t.Errorf("delete %d: %q", movie.ID, err)
+							return
+						}
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		movies := list(t, repo)
+		if want := workers * (perWorker - perWorker/2); len(movies) != want {
+			t.Errorf("got %d movies but want %d", len(movies), want)
+		}
+		for _, movie := range movies {
+			if movie.IMDb != 9 {
+				t.Errorf("movie %d was not updated", movie.ID)
+			}
+		}
+	})
+}
+
+func TestInMemoryRepo_conformance(t *testing.T) {
+	testRepoConformance(t, func() Repo { return NewInMemoryRepo() })
+}
+
+func TestSQLiteRepo_conformance(t *testing.T) {
+	testRepoConformance(t, func() Repo { return newTestSQLiteRepo(t) })
+}
+
+func TestJournalRepo_conformance(t *testing.T) {
+	testRepoConformance(t, func() Repo { return openTestJournal(t, t.TempDir(), 0) })
+}
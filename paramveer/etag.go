@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// movieETag is the strong entity tag of a movie: its quoted version.
+func movieETag(movie Movie) string {
+	return strconv.Quote(strconv.Itoa(movie.Version))
+}
+
+// ifMatchVersion reads the If-Match header of r as the movie version the
+// client expects, 0 meaning any. Without the header it fails with
+// errPreconditionRequired when required is set. Only "*" and a single strong
+// tag in movieETag's form can ever match; anything else, including weak
+// tags, is a failed precondition as RFC 9110 prescribes.
+func ifMatchVersion(r *http.Request, required bool) (int, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch value {
+	case "":
+		if required {
+			return 0, errPreconditionRequired
+		}
+		return 0, nil
+	case "*":
+		return 0, nil
+	}
+
+	unquoted, err := strconv.Unquote(value)
+	if err != nil || !strings.HasPrefix(value, `"`) {
+		return 0, errVersionConflict
+	}
+	version, err := strconv.Atoi(unquoted)
+	if err != nil || version < 1 {
+		return 0, errVersionConflict
+	}
+	return version, nil
+}
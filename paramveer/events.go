@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	movieCreated  = "created"
+	movieUpdated  = "updated"
+	movieDeleted  = "deleted"
+	movieRestored = "restored"
+	// movieMoved is a movie that got a new ID; MovieID is the old one.
+	movieMoved = "moved"
+
+	// feedReset tells a resuming subscriber that events it asked for are no
+	// longer available, so it has to reload the movie list.
+	feedReset = "reset"
+)
+
+const (
+	defaultEventLogSize   = 1024
+	subscriptionQueueSize = 64
+)
+
+// movieEvent is one change to the movie collection. MovieID is the ID the
+// change was made under, which for an update that re-keys the movie differs
+// from Movie.ID. Events about one movie can be published out of order when
+// it is changed concurrently; Movie.Version tells the newer one.
+type movieEvent struct {
+	Token   string    `json:"token"`
+	Type    string    `json:"type"`
+	MovieID int       `json:"movie_id,omitempty"`
+	Movie   *Movie    `json:"movie,omitempty"`
+	Time    time.Time `json:"time"`
+
+	seq uint64
+}
+
+// eventLog fans movie events out to subscribers and keeps the most recent
+// ones, so a subscriber that lost its connection can resume where it left
+// off. Tokens name a position in the log; they are only valid for the
+// process that issued them, since the log is not persisted.
+type eventLog struct {
+	mu     sync.Mutex
+	epoch  string
+	size   int
+	seq    uint64
+	recent []movieEvent
+	subs   map[*subscription]struct{}
+	closed bool
+}
+
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		size = defaultEventLogSize
+	}
+	return &eventLog{
+		epoch: newRequestID(),
+		size:  size,
+		subs:  map[*subscription]struct{}{},
+	}
+}
+
+// publish records an event and hands it to every subscriber. It never
+// blocks: a subscriber whose queue is full is dropped and has to resume.
+func (l *eventLog) publish(eventType string, movieID int, movie Movie) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	event := movieEvent{
+		Token:   l.token(l.seq),
+		Type:    eventType,
+		MovieID: movieID,
+		Movie:   &movie,
+		Time:    time.Now().UTC(),
+		seq:     l.seq,
+	}
+
+	if len(l.recent) == l.size {
+		copy(l.recent, l.recent[1:])
+		l.recent = l.recent[:l.size-1]
+	}
+	l.recent = append(l.recent, event)
+
+	for sub := range l.subs {
+		select {
+		case sub.queue <- event:
+		default:
+			sub.lagged = true
+			l.drop(sub)
+		}
+	}
+}
+
+// subscribe starts delivering events. With an empty resume token delivery
+// starts with the next event; otherwise the events after the token are
+// queued first. If they cannot be replayed, because the token is malformed,
+// from another process or too old, a feedReset event is queued instead.
+func (l *eventLog) subscribe(resume string) *subscription {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var backlog []movieEvent
+	if resume != "" {
+		after, ok := l.position(resume)
+		if oldest := l.seq - uint64(len(l.recent)); !ok || after < oldest {
+			backlog = []movieEvent{{Token: l.token(l.seq), Type: feedReset, Time: time.Now().UTC(), seq: l.seq}}
+		} else {
+			backlog = l.recent[len(l.recent)-int(l.seq-after):]
+		}
+	}
+
+	sub := &subscription{
+		log:   l,
+		queue: make(chan movieEvent, len(backlog)+subscriptionQueueSize),
+	}
+	for _, event := range backlog {
+		sub.queue <- event
+	}
+	if l.closed {
+		close(sub.queue)
+		return sub
+	}
+	l.subs[sub] = struct{}{}
+	return sub
+}
+
+// close ends every subscription; later ones end right after their backlog.
+func (l *eventLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for sub := range l.subs {
+		l.drop(sub)
+	}
+}
+
+// drop ends sub. The caller must hold l.mu.
+func (l *eventLog) drop(sub *subscription) {
+	if _, ok := l.subs[sub]; ok {
+		delete(l.subs, sub)
+		close(sub.queue)
+	}
+}
+
+func (l *eventLog) token(seq uint64) string {
+	return fmt.Sprintf("%s.%d", l.epoch, seq)
+}
+
+// position reads the sequence number out of a token issued by this log.
+// The caller must hold l.mu.
+func (l *eventLog) position(token string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(token, ".")
+	if !ok || epoch != l.epoch {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil || n > l.seq {
+		return 0, false
+	}
+	return n, true
+}
+
+// subscription is one consumer of an eventLog. Events arrive on events()
+// until the channel is closed, either by cancel, by the log closing or
+// because the subscriber fell too far behind, in which case overflowed
+// reports true.
+type subscription struct {
+	log    *eventLog
+	queue  chan movieEvent
+	lagged bool
+}
+
+func (s *subscription) events() <-chan movieEvent {
+	return s.queue
+}
+
+// overflowed is only meaningful once events() has been closed.
+func (s *subscription) overflowed() bool {
+	return s.lagged
+}
+
+func (s *subscription) cancel() {
+	s.log.mu.Lock()
+	defer s.log.mu.Unlock()
+
+	s.log.drop(s)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// drain returns the events queued on sub without waiting for more.
+func drain(sub *subscription) []movieEvent {
+	var events []movieEvent
+	for {
+		select {
+		case event, ok := <-sub.events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func eventTypes(events []movieEvent) []string {
+	types := make([]string, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+	}
+	return types
+}
+
+func TestEventLog_resume(t *testing.T) {
+	events := newEventLog(3)
+	bhamsa := Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8}
+
+	live := events.subscribe("")
+	defer live.cancel()
+
+	events.publish(movieCreated, 1, bhamsa)
+	events.publish(movieUpdated, 1, bhamsa)
+	first := drain(live)
+	if len(first) != 2 {
+		t.Fatalf("got %d events but want 2", len(first))
+	}
+
+	events.publish(movieDeleted, 1, bhamsa)
+
+	tests := []struct {
+		name   string
+		resume string
+		want   []string
+	}{
+		{name: "from now", resume: "", want: nil},
+		{name: "after the first event", resume: first[0].Token, want: []string{movieUpdated, movieDeleted}},
+		{name: "up to date", resume: drain(live)[0].Token, want: nil},
+		{name: "malformed token", resume: "nonsense", want: []string{feedReset}},
+		{name: "token from another process", resume: "0123.1", want: []string{feedReset}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := events.subscribe(tt.resume)
+			defer sub.cancel()
+
+			if got := eventTypes(drain(sub)); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v but want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Once the event after a token has been pushed out of the log, resuming
+	// from that token is impossible.
+	events.publish(movieCreated, 2, bhamsa)
+	events.publish(movieCreated, 3, bhamsa)
+	sub := events.subscribe(first[0].Token)
+	defer sub.cancel()
+	if got := eventTypes(drain(sub)); len(got) != 1 || got[0] != feedReset {
+		t.Errorf("got %v but want a reset", got)
+	}
+}
+
+func TestEventLog_slowSubscriberIsDropped(t *testing.T) {
+	events := newEventLog(0)
+	slow := events.subscribe("")
+
+	for i := 0; i <= subscriptionQueueSize; i++ {
+		events.publish(movieCreated, i+1, Movie{ID: i + 1})
+	}
+
+	got := drain(slow)
+	if len(got) != subscriptionQueueSize {
+		t.Errorf("got %d events but want %d", len(got), subscriptionQueueSize)
+	}
+	if _, ok := <-slow.events(); ok || !slow.overflowed() {
+		t.Errorf("slow subscriber was not dropped")
+	}
+
+	// It can catch up from the last event it received.
+	resumed := events.subscribe(got[len(got)-1].Token)
+	defer resumed.cancel()
+	if got := drain(resumed); len(got) != 1 || got[0].MovieID != subscriptionQueueSize+1 {
+		t.Errorf("got %+v but want the missed event", got)
+	}
+}
+
+func TestEventLog_close(t *testing.T) {
+	events := newEventLog(0)
+	sub := events.subscribe("")
+	events.close()
+
+	if _, ok := <-sub.events(); ok || sub.overflowed() {
+		t.Errorf("subscription was not closed cleanly")
+	}
+	if _, ok := <-events.subscribe("").events(); ok {
+		t.Errorf("subscribing after close did not end at once")
+	}
+}
+
+func Test_service_publishesEvents(t *testing.T) {
+	events := newEventLog(0)
+	sub := events.subscribe("")
+	defer sub.cancel()
+
+	serv := Newservice(NewInMemoryRepo(), withEventLog(events))
+	ctx := context.Background()
+
+	movie, err := serv.CreateMovie(ctx, Movie{Title: "bhamsa", Director: "paramveer", IMDb: 8})
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	movie.IMDb = 9
+	if _, err := serv.UpdateMovie(ctx, 1, movie); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := serv.MoveMovie(ctx, 1, 7, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := serv.UpdateMovie(ctx, 1, movie); err == nil {
+		t.Fatalf("updating a missing movie succeeded")
+	}
+	if _, err := serv.DeleteMovie(ctx, 7, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	got := drain(sub)
+	want := []struct {
+		Type    string
+		MovieID int
+		ID      int
+		Version int
+	}{
+		{movieCreated, 1, 1, 1},
+		{movieUpdated, 1, 1, 2},
+		{movieMoved, 1, 7, 3},
+		{movieDeleted, 7, 7, 4},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events but want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if g := got[i]; g.Type != w.Type || g.MovieID != w.MovieID || g.Movie.ID != w.ID || g.Movie.Version != w.Version {
+			t.Errorf("event %d: got %+v %+v but want %+v", i, g, *g.Movie, w)
+		}
+	}
+}
+
+func Test_service_createMovieIgnoresDeletedAt(t *testing.T) {
+	events := newEventLog(0)
+	sub := events.subscribe("")
+	defer sub.cancel()
+
+	serv := Newservice(NewInMemoryRepo(), withEventLog(events))
+	deletedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	movie, err := serv.CreateMovie(context.Background(), Movie{Title: "bhamsa", Director: "paramveer", IMDb: 8, DeletedAt: &deletedAt})
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if movie.DeletedAt != nil {
+		t.Errorf("got deleted_at %v in the created movie but want none", movie.DeletedAt)
+	}
+	got := drain(sub)
+	if len(got) != 1 || got[0].Type != movieCreated {
+		t.Fatalf("got %+v but want one created event", got)
+	}
+	if got[0].Movie.DeletedAt != nil {
+		t.Errorf("got deleted_at %v in the created event but want none", got[0].Movie.DeletedAt)
+	}
+	if _, err := serv.GetMovieById(context.Background(), movie.ID); err != nil {
+		t.Errorf("got %q but want the movie live", err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// readinessTimeout bounds each readiness check, so a dependency that hangs
+// shows up as not ready instead of hanging the probe.
+const readinessTimeout = 2 * time.Second
+
+var (
+	errShuttingDown  = errors.New("server is shutting down")
+	errCheckTimedOut = errors.New("check did not answer in time")
+)
+
+// readinessCheck tells whether one dependency the server needs can be used.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// withReadinessCheck makes /readyz depend on check, reported under name.
+func withReadinessCheck(name string, check func(ctx context.Context) error) handlerOption {
+	return func(h *movieHandler) {
+		h.checks = append(h.checks, readinessCheck{name: name, check: check})
+	}
+}
+
+// shutdownCheck fails once stopping is set, so that load balancers stop
+// sending requests before the server stops accepting them.
+func shutdownCheck(stopping *atomic.Bool) func(ctx context.Context) error {
+	return func(context.Context) error {
+		if stopping.Load() {
+			return errShuttingDown
+		}
+		return nil
+	}
+}
+
+// healthReport is the response of /healthz and /readyz. Checks maps every
+// readiness check to "ok" or the reason it failed.
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// buildVersion is the response of /version.
+type buildVersion struct {
+	Module    string    `json:"module"`
+	Version   string    `json:"version"`
+	GoVersion string    `json:"go_version"`
+	VCS       *buildVCS `json:"vcs,omitempty"`
+}
+
+// buildVCS describes the commit the binary was built from, when it was
+// built inside a checkout.
+type buildVCS struct {
+	System   string `json:"system"`
+	Revision string `json:"revision"`
+	Time     string `json:"time,omitempty"`
+	Modified bool   `json:"modified"`
+}
+
+func buildVersionOf(info *debug.BuildInfo) buildVersion {
+	v := buildVersion{
+		Module:    info.Main.Path,
+		Version:   info.Main.Version,
+		GoVersion: info.GoVersion,
+	}
+	vcs := buildVCS{}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs":
+			vcs.System = s.Value
+		case "vcs.revision":
+			vcs.Revision = s.Value
+		case "vcs.time":
+			vcs.Time = s.Value
+		case "vcs.modified":
+			vcs.Modified = s.Value == "true"
+		}
+	}
+	if vcs.System != "" {
+		v.VCS = &vcs
+	}
+	return v
+}
+
+func writeHealth(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("failed to send response:", err)
+	}
+}
+
+// healthz answers as long as the process can serve requests at all.
+func (h *movieHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthReport{Status: "ok"})
+}
+
+// readyz runs every readiness check at once and answers 503 unless all of
+// them pass within readinessTimeout. A check that has not answered by then
+// counts as failed.
+func (h *movieHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	type result struct {
+		i   int
+		err error
+	}
+	// Buffered so checks that answer too late do not block forever.
+	results := make(chan result, len(h.checks))
+	for i, c := range h.checks {
+		go func(i int, c readinessCheck) {
+			results <- result{i, c.check(ctx)}
+		}(i, c)
+	}
+
+	errs := make([]error, len(h.checks))
+	for i := range errs {
+		errs[i] = errCheckTimedOut
+	}
+wait:
+	for range h.checks {
+		select {
+		case res := <-results:
+			errs[res.i] = res.err
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	report := healthReport{Status: "ready", Checks: make(map[string]string, len(h.checks))}
+	status := http.StatusOK
+	for i, c := range h.checks {
+		if err := errs[i]; err != nil {
+			report.Checks[c.name] = err.Error()
+			report.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		report.Checks[c.name] = "ok"
+	}
+	if status != http.StatusOK {
+		logf(levelWarn, "request %s: not ready: %v", requestIDFrom(r.Context()), report.Checks)
+	}
+	writeHealth(w, status, report)
+}
+
+// version reports the module version and the commit the binary was built
+// from.
+func (h *movieHandler) version(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		resolveError(w, r, errors.New("build info is not available"))
+		return
+	}
+	writeHealth(w, http.StatusOK, buildVersionOf(info))
+}
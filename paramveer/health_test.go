@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime/debug"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_movieHandler_readyz(t *testing.T) {
+	errDown := errors.New("database is locked")
+	ok := func(context.Context) error { return nil }
+	failing := func(context.Context) error { return errDown }
+
+	tests := []struct {
+		name           string
+		opts           []handlerOption
+		wantStatusCode int
+		want           healthReport
+	}{
+		{
+			name:           "no checks",
+			wantStatusCode: http.StatusOK,
+			want:           healthReport{Status: "ready"},
+		},
+		{
+			name:           "every check passes",
+			opts:           []handlerOption{withReadinessCheck("repo", ok), withReadinessCheck("cache", ok)},
+			wantStatusCode: http.StatusOK,
+			want:           healthReport{Status: "ready", Checks: map[string]string{"repo": "ok", "cache": "ok"}},
+		},
+		{
+			name:           "one check fails",
+			opts:           []handlerOption{withReadinessCheck("repo", failing), withReadinessCheck("cache", ok)},
+			wantStatusCode: http.StatusServiceUnavailable,
+			want:           healthReport{Status: "not_ready", Checks: map[string]string{"repo": "database is locked", "cache": "ok"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := registerRoutes(NewMovieHandler(Newservice(NewInMemoryRepo()), tt.opts...))
+
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, httptest.NewRequest("GET", "/readyz", nil))
+
+			if res.Code != tt.wantStatusCode {
+				t.Fatalf("want statuscode %d but got %d: %s", tt.wantStatusCode, res.Code, res.Body.String())
+			}
+			var got healthReport
+			if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid body %q: %q", res.Body.String(), err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v but want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_shutdownCheck(t *testing.T) {
+	var stopping atomic.Bool
+	check := shutdownCheck(&stopping)
+	if err := check(context.Background()); err != nil {
+		t.Errorf("unexpected error %q", err)
+	}
+	stopping.Store(true)
+	if err := check(context.Background()); !errors.Is(err, errShuttingDown) {
+		t.Errorf("want error %q but got %q", errShuttingDown, err)
+	}
+}
+
+func Test_movieHandler_healthz(t *testing.T) {
+	router := registerRoutes(NewMovieHandler(Newservice(NewInMemoryRepo()),
+		withReadinessCheck("repo", func(context.Context) error { return errors.New("down") })))
+
+	// Liveness does not depend on readiness.
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/healthz", nil))
+	if res.Code != http.StatusOK || res.Body.String() != `{"status":"ok"}`+"\n" {
+		t.Errorf("got %d %s", res.Code, res.Body.String())
+	}
+}
+
+func Test_buildVersionOf(t *testing.T) {
+	tests := []struct {
+		name string
+		info debug.BuildInfo
+		want buildVersion
+	}{
+		{
+			name: "from a checkout",
+			info: debug.BuildInfo{
+				GoVersion: "go1.21.0",
+				Main:      debug.Module{Path: "bitbucket.org/midaas-telemetry/paramveer", Version: "v1.4.0"},
+				Settings: []debug.BuildSetting{
+					{Key: "-trimpath", Value: "true"},
+					{Key: "vcs", Value: "git"},
+					{Key: "vcs.revision", Value: "539f1a3"},
+					{Key: "vcs.time", Value: "2024-01-02T03:04:05Z"},
+					{Key: "vcs.modified", Value: "true"},
+				},
+			},
+			want: buildVersion{
+				Module:    "bitbucket.org/midaas-telemetry/paramveer",
+				Version:   "v1.4.0",
+				GoVersion: "go1.21.0",
+				VCS:       &buildVCS{System: "git", Revision: "539f1a3", Time: "2024-01-02T03:04:05Z", Modified: true},
+			},
+		},
+		{
+			name: "without version control",
+			info: debug.BuildInfo{GoVersion: "go1.21.0", Main: debug.Module{Path: "bitbucket.org/midaas-telemetry/paramveer", Version: "(devel)"}},
+			want: buildVersion{Module: "bitbucket.org/midaas-telemetry/paramveer", Version: "(devel)", GoVersion: "go1.21.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildVersionOf(&tt.info); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v but want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_movieHandler_version(t *testing.T) {
+	router := registerRoutes(NewMovieHandler(Newservice(NewInMemoryRepo())))
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/version", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("want statuscode %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	var got buildVersion
+	if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid body %q: %q", res.Body.String(), err)
+	}
+	if got.GoVersion == "" {
+		t.Errorf("got %+v without a Go version", got)
+	}
+}
@@ -1,106 +1,225 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/gorilla/mux"
 )
 
-func resolveError(w http.ResponseWriter, statusCode int, str string, err error) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-
-	if err := json.NewEncoder(w).Encode(str); err != nil {
-		log.Printf("failed to send response for :%q", err)
+// pathId reads the {id} route variable.
+func pathId(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errInvalidPathId, err)
 	}
+	return id, nil
 }
 
 type movieHandler struct {
-	serv movieService
+	serv           movieService
+	requireIfMatch bool
+	events         *eventLog
+
+	// checks decide whether /readyz reports the server ready.
+	checks []readinessCheck
+	// metrics is served on /metrics when set.
+	metrics *metrics
+
+	// streams counts the WebSocket streams being served. http.Server does
+	// not track hijacked connections, so shutdown waits on them here.
+	streams sync.WaitGroup
+}
+
+type handlerOption func(*movieHandler)
+
+// withRequiredIfMatch makes PUT, PATCH and DELETE on a movie answer 428
+// unless they carry an If-Match header, so no client can overwrite a change
+// it has not seen.
+func withRequiredIfMatch() handlerOption {
+	return func(h *movieHandler) {
+		h.requireIfMatch = true
+	}
 }
 
-func NewMovieHandler(s movieService) *movieHandler {
-	return &movieHandler{serv: s}
+// withChangeFeed serves the events published to events on
+// /api/movies/stream and /api/movies/events.
+func withChangeFeed(events *eventLog) handlerOption {
+	return func(h *movieHandler) {
+		h.events = events
+	}
+}
+
+func NewMovieHandler(s movieService, opts ...handlerOption) *movieHandler {
+	h := &movieHandler{serv: s}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 func (h *movieHandler) createMovie(w http.ResponseWriter, r *http.Request) {
 	var newMovie Movie
 	if err := json.NewDecoder(r.Body).Decode(&newMovie); err != nil {
-		resolveError(w, http.StatusBadRequest, "invalid body", err)
+		resolveError(w, r, bodyError(errInvalidBody, err))
+		return
+	}
+
+	newMovie, err := h.serv.CreateMovie(r.Context(), newMovie)
+	if err != nil {
+		resolveError(w, r, err)
 		return
 	}
 
-	if err := h.serv.CreateMovie(newMovie); err != nil {
-		if errors.Is(err, errConflict) {
-			resolveError(w, http.StatusConflict, "movie already exist", err)
-			return
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/movies/%d", newMovie.ID))
+	w.Header().Set("ETag", movieETag(newMovie))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(newMovie); err != nil {
+		log.Println("failed to send response:", err)
+	}
+}
+
+// legacyIndustries turns the hollywood and bollywood filters from before
+// movies had an Industry into the industries they select: "yes" keeps only
+// that industry, any other value leaves it out. Movies whose industry is
+// not known still match unless a flag is "yes", as they did when both of
+// their flags were unset.
+func legacyIndustries(values url.Values) ([]Industry, error) {
+	selected := append([]Industry{""}, industries...)
+	for _, industry := range []Industry{IndustryHollywood, IndustryBollywood} {
+		if !values.Has(string(industry)) {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(values.Get(string(industry))), "yes") {
+			selected = slices.DeleteFunc(selected, func(i Industry) bool { return i != industry })
+		} else {
+			selected = slices.DeleteFunc(selected, func(i Industry) bool { return i == industry })
 		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w: hollywood and bollywood cannot both be yes", errInvalidQuery)
+	}
+	return selected, nil
+}
+
+// parseMovieQuery reads the filters, sort order and page selection of
+// GET /api/movies from the query string.
+func parseMovieQuery(values url.Values) (movieQuery, error) {
+	q := movieQuery{
+		Director:      values.Get("director"),
+		TitleContains: values.Get("title_contains"),
+		Cursor:        values.Get("cursor"),
+	}
 
-		if errors.Is(err, errInvalidId) {
-			resolveError(w, http.StatusBadRequest, "invalid id", err)
-			return
+	if raw := values.Get("industry"); raw != "" {
+		for _, name := range strings.Split(raw, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				q.Industries = append(q.Industries, Industry(strings.ToLower(name)))
+			}
+		}
+	}
+	if values.Has("hollywood") || values.Has("bollywood") {
+		if values.Has("industry") {
+			return movieQuery{}, fmt.Errorf("%w: hollywood and bollywood cannot be combined with industry", errInvalidQuery)
+		}
+		var err error
+		if q.Industries, err = legacyIndustries(values); err != nil {
+			return movieQuery{}, err
 		}
+	}
 
-		if errors.Is(err, errInvalidRating) {
-			resolveError(w, http.StatusBadRequest, "invalid rating", err)
-			return
+	var err error
+	if q.Genres, err = parseGenres(values.Get("genre"), ","); err != nil {
+		return movieQuery{}, fmt.Errorf("%w: genre: %v", errInvalidQuery, err)
+	}
+	if q.MinIMDb, err = parseFloatParam(values, "min_imdb"); err != nil {
+		return movieQuery{}, err
+	}
+	if q.MaxIMDb, err = parseFloatParam(values, "max_imdb"); err != nil {
+		return movieQuery{}, err
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return movieQuery{}, fmt.Errorf("%w: limit must be a positive number", errInvalidQuery)
 		}
+		q.Limit = limit
+	}
 
-		resolveError(w, http.StatusInternalServerError, "internal server error", err)
-		return
+	if q.Sort, err = parseSort(values.Get("sort")); err != nil {
+		return movieQuery{}, err
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(newMovie); err != nil {
-		log.Println("failed to send response:", err)
+	return q, nil
+}
+
+func parseFloatParam(values url.Values, name string) (*float64, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return nil, nil
 	}
+
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be a number", errInvalidQuery, name)
+	}
+	return &v, nil
 }
 
 func (h *movieHandler) getMovies(w http.ResponseWriter, r *http.Request) {
-	movies, err := h.serv.GetAllMovie()
+	q, err := parseMovieQuery(r.URL.Query())
 	if err != nil {
-		resolveError(w, http.StatusInternalServerError, "internal server error", err)
+		resolveError(w, r, err)
+		return
+	}
+
+	page, err := h.serv.ListMovies(r.Context(), q)
+	if err != nil {
+		resolveError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(movies); err != nil {
+	if err := json.NewEncoder(w).Encode(page); err != nil {
 		log.Println("failed to send response:", err)
 		return
 	}
 }
 
 func (h *movieHandler) getMovie(w http.ResponseWriter, r *http.Request) {
-	var idStr string = mux.Vars(r)["id"]
-	id, err := strconv.Atoi(idStr)
+	id, err := pathId(r)
 	if err != nil {
-		resolveError(w, http.StatusBadRequest, "cannot access id", err)
+		resolveError(w, r, err)
 		return
 	}
 
-	movie, err := h.serv.GetMovieById(id)
+	movie, err := h.serv.GetMovieById(r.Context(), id)
 	if err != nil {
-		if errors.Is(err, errInvalidId) {
-			resolveError(w, http.StatusBadRequest, "invalid id", err)
-			return
-		}
-
-		if errors.Is(err, errNotFound) {
-			resolveError(w, http.StatusNotFound, "movie not found", err)
-			return
-		}
-
-		resolveError(w, http.StatusInternalServerError, "internal server error", err)
+		resolveError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", movieETag(movie))
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(movie); err != nil {
 		log.Println("failed to send response:", err)
@@ -109,68 +228,99 @@ func (h *movieHandler) getMovie(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *movieHandler) updateMovie(w http.ResponseWriter, r *http.Request) {
-	var idStr string = mux.Vars(r)["id"]
-	id, err := strconv.Atoi(idStr)
+	id, err := pathId(r)
 	if err != nil {
-		resolveError(w, http.StatusBadRequest, "cannot access id", err)
+		resolveError(w, r, err)
+		return
+	}
+
+	version, err := ifMatchVersion(r, h.requireIfMatch)
+	if err != nil {
+		resolveError(w, r, err)
 		return
 	}
 
 	var updatedMovie Movie
 	if err := json.NewDecoder(r.Body).Decode(&updatedMovie); err != nil {
-		resolveError(w, http.StatusBadRequest, "invalid body", err)
+		resolveError(w, r, bodyError(errInvalidBody, err))
 		return
 	}
+	// If-Match takes precedence over a version in the body.
+	if r.Header.Get("If-Match") != "" {
+		updatedMovie.Version = version
+	}
 
-	movie, err := h.serv.UpdateMovie(id, updatedMovie)
+	movie, err := h.serv.UpdateMovie(r.Context(), id, updatedMovie)
 	if err != nil {
-		if errors.Is(err, errNotFound) {
-			resolveError(w, http.StatusNotFound, "movie not found", err)
-			return
-		}
+		resolveError(w, r, err)
+		return
+	}
 
-		if errors.Is(err, errInvalidId) {
-			resolveError(w, http.StatusBadRequest, "invalid id", err)
-			return
-		}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", movieETag(movie))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(movie); err != nil {
+		log.Println("failed to send response for :", err)
+		return
+	}
+}
 
-		if errors.Is(err, errInvalidRating) {
-			resolveError(w, http.StatusBadRequest, "invalid rating", err)
-			return
-		}
+func (h *movieHandler) patchMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
 
-		resolveError(w, http.StatusInternalServerError, "internal server error", err)
+	version, err := ifMatchVersion(r, h.requireIfMatch)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		resolveError(w, r, bodyError(errInvalidBody, err))
+		return
+	}
+
+	patch, err := parseMoviePatch(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	movie, err := h.serv.PatchMovie(r.Context(), id, patch, version)
+	if err != nil {
+		resolveError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", movieETag(movie))
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(movie); err != nil {
-		log.Println("failed to send response for :", err)
+		log.Println("failed to send response:", err)
 		return
 	}
 }
 
 func (h *movieHandler) deleteMovie(w http.ResponseWriter, r *http.Request) {
-	var idStr string = mux.Vars(r)["id"]
-	id, err := strconv.Atoi(idStr)
+	id, err := pathId(r)
 	if err != nil {
-		resolveError(w, http.StatusBadRequest, "cannot access id", err)
+		resolveError(w, r, err)
 		return
 	}
 
-	deleteMovie, err := h.serv.DeleteMovie(id)
+	version, err := ifMatchVersion(r, h.requireIfMatch)
 	if err != nil {
-		if errors.Is(err, errInvalidId) {
-			resolveError(w, http.StatusBadRequest, "invalid id", err)
-			return
-		}
+		resolveError(w, r, err)
+		return
+	}
 
-		if errors.Is(err, errNotFound) {
-			resolveError(w, http.StatusNotFound, "movie not found", err)
-			return
-		}
-		resolveError(w, http.StatusInternalServerError, "internal server", err)
+	deleteMovie, err := h.serv.DeleteMovie(r.Context(), id, version)
+	if err != nil {
+		resolveError(w, r, err)
 		return
 	}
 
@@ -182,10 +332,75 @@ func (h *movieHandler) deleteMovie(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// moveRequest is the body of POST /api/movies/{id}/move.
+type moveRequest struct {
+	ID int `json:"id"`
+}
+
+func (h *movieHandler) moveMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	version, err := ifMatchVersion(r, h.requireIfMatch)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	var req moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		resolveError(w, r, bodyError(errInvalidBody, err))
+		return
+	}
+
+	movie, err := h.serv.MoveMovie(r.Context(), id, req.ID, version)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", movieETag(movie))
+	w.Header().Set("Location", fmt.Sprintf("/api/movies/%d", movie.ID))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(movie); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
+
 func registerRoutes(h *movieHandler) *mux.Router {
 	router := mux.NewRouter()
+	router.Use(withRequestID)
+	router.Use(withActor)
+	router.NotFoundHandler = problemHandler(errNoRoute)
+	router.MethodNotAllowedHandler = problemHandler(errMethodNotAllowed)
+	router.Path("/healthz").Methods("GET", "HEAD").HandlerFunc(h.healthz)
+	router.Path("/readyz").Methods("GET", "HEAD").HandlerFunc(h.readyz)
+	router.Path("/version").Methods("GET", "HEAD").HandlerFunc(h.version)
+	router.Path("/metrics").Methods("GET").HandlerFunc(h.serveMetrics)
 	router.Path("/api/movies").Methods("POST").HandlerFunc(h.createMovie)
+	router.Path("/api/movies:batch").Methods("POST").HandlerFunc(h.batchMovies)
+	router.Path("/api/movies/stats").Methods("GET").HandlerFunc(h.movieStats)
+	router.Path("/api/movies/search").Methods("GET").HandlerFunc(h.searchMovies)
+	router.Path("/api/movies/export").Methods("GET").HandlerFunc(h.exportMovies)
+	router.Path("/api/movies/import").Methods("POST").HandlerFunc(h.importMovies)
+	router.Path("/api/movies/stream").Methods("GET").HandlerFunc(h.streamMovies)
+	router.Path("/api/movies/events").Methods("GET").HandlerFunc(h.movieEventStream)
+	router.Path("/api/movies/trash").Methods("GET").HandlerFunc(h.listTrash)
+	router.Path("/api/movies/trash").Methods("DELETE").HandlerFunc(h.purgeTrash)
+	router.Path("/api/movies/trash/{id}").Methods("DELETE").HandlerFunc(h.purgeMovie)
+	router.Path("/api/movies/{id}/restore").Methods("POST").HandlerFunc(h.restoreMovie)
+	router.Path("/api/movies/{id}/move").Methods("POST").HandlerFunc(h.moveMovie)
+	router.Path("/api/movies/{id}/revisions").Methods("GET").HandlerFunc(h.listRevisions)
+	router.Path("/api/movies/{id}/revisions/diff").Methods("GET").HandlerFunc(h.diffRevisions)
+	router.Path("/api/movies/{id}/revisions/{rev}").Methods("GET").HandlerFunc(h.getRevision)
+	router.Path("/api/movies/{id}/revisions/{rev}/revert").Methods("POST").HandlerFunc(h.revertMovie)
 	router.Path("/api/movies/{id}").Methods("PUT").HandlerFunc(h.updateMovie)
+	router.Path("/api/movies/{id}").Methods("PATCH").HandlerFunc(h.patchMovie)
 	router.Path("/api/movies/{id}").Methods("GET").HandlerFunc(h.getMovie)
 	router.Path("/api/movies").Methods("GET").HandlerFunc(h.getMovies)
 	router.Path("/api/movies/{id}").Methods("DELETE").HandlerFunc(h.deleteMovie)
@@ -194,12 +409,146 @@ func registerRoutes(h *movieHandler) *mux.Router {
 }
 
 func main() {
-	repo := NewInMemoryRepo()
-	serv := Newservice(repo)
-	transport := NewMovieHandler(serv)
-	router := registerRoutes(transport)
+	cfg, err := loadConfig(os.Args[1:], os.Getenv, os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n  %s\n", strings.ReplaceAll(err.Error(), "\n", "\n  "))
+		os.Exit(2)
+	}
+	os.Exit(run(cfg))
+}
+
+// openRepo opens the storage backend cfg names.
+func openRepo(cfg storageConfig) (Repo, error) {
+	switch cfg.Backend {
+	case backendSQLite:
+		sqliteRepo, err := NewSQLiteRepo(cfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		return sqliteRepo, nil
+	case backendJournal:
+		journalRepo, err := NewJournalRepo(cfg.Path, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open journal: %w", err)
+		}
+		logf(levelInfo, "recovered %d journal entries from %s", journalRepo.Recovered(), cfg.Path)
+		return journalRepo, nil
+	default:
+		return NewInMemoryRepo(), nil
+	}
+}
+
+// run serves cfg until SIGINT or SIGTERM, then shuts down in order: it stops
+// accepting connections and ends the change feeds, drains requests and
+// streams within the shutdown timeout, stops the trash retention and closes
+// the repo. A second signal kills the process at once. run returns the exit
+// status: 0 after a clean shutdown, 1 if serving or shutting down failed.
+func run(cfg config) int {
+	level, _ := parseLogLevel(cfg.LogLevel)
+	setLogLevel(level)
+	ids, _ := parseIDStrategy(cfg.IDStrategy)
+
+	repo, err := openRepo(cfg.Storage)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	listener, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Println("failed to listen:", err)
+		repo.Close()
+		return 1
+	}
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
 
-	if err := http.ListenAndServe(":8080", router); err != nil {
+	m := newMetrics()
+	m.watchRepo(repo)
+
+	events := newEventLog(cfg.EventLogSize)
+	opts := []serviceOption{withIDStrategy(ids), withEventLog(events)}
+	if cfg.Features.ImportMode {
+		opts = append(opts, withClientIds())
+	}
+	serv := Newservice(newMetricsRepo(repo, m), opts...)
+
+	retention, stopRetention := context.WithCancel(context.Background())
+	retentionDone := make(chan struct{})
+	go func() {
+		defer close(retentionDone)
+		if cfg.TrashRetention > 0 {
+			runTrashRetention(retention, serv, cfg.TrashRetention, trashPurgeInterval)
+		}
+	}()
+
+	var stopping atomic.Bool
+	handlerOpts := []handlerOption{
+		withChangeFeed(events),
+		withReadinessCheck("repo", repo.ping),
+		withReadinessCheck("shutdown", shutdownCheck(&stopping)),
+		withMetrics(m),
+	}
+	if cfg.Features.RequireIfMatch {
+		handlerOpts = append(handlerOpts, withRequiredIfMatch())
+	}
+	transport := NewMovieHandler(serv, handlerOpts...)
+	router := registerRoutes(transport)
+	router.Use(withRequestLog, withBodyLimit(cfg.MaxBodyBytes))
+
+	server := &http.Server{
+		Addr:         cfg.Listen,
+		Handler:      m.instrument(router),
+		ReadTimeout:  cfg.Timeouts.Read,
+		WriteTimeout: cfg.Timeouts.Write,
+		IdleTimeout:  cfg.Timeouts.Idle,
+	}
+	// Ending the subscriptions lets the streams say goodbye and return,
+	// instead of holding the drain up until the deadline.
+	server.RegisterOnShutdown(events.close)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	logf(levelInfo, "listening on %s with %s storage", listener.Addr(), cfg.Storage.Backend)
+
+	status := 0
+	select {
+	case err := <-served:
 		log.Println("http server exited:", err)
+		status = 1
+	case <-signals.Done():
+		stopSignals()
+		stopping.Store(true)
+		logf(levelInfo, "shutting down")
+	}
+
+	drain := context.Background()
+	if cfg.Timeouts.Shutdown > 0 {
+		var cancel context.CancelFunc
+		drain, cancel = context.WithTimeout(drain, cfg.Timeouts.Shutdown)
+		defer cancel()
+	}
+	if err := server.Shutdown(drain); err != nil {
+		log.Println("requests did not finish in time:", err)
+		server.Close()
+		status = 1
+	}
+	if err := transport.waitStreams(drain); err != nil {
+		log.Println("streams did not finish in time:", err)
+		status = 1
+	}
+
+	stopRetention()
+	<-retentionDone
+	if err := repo.Close(); err != nil {
+		log.Println("failed to close storage:", err)
+		status = 1
 	}
+	logf(levelInfo, "shut down")
+	return status
 }
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,12 +18,46 @@ func Test_movieHandler_createMovie(t *testing.T) {
 	tests := []struct {
 		name             string
 		existingMovies   []Movie
+		importMode       bool
 		requestBody      string
 		wantResponseBody string
 		wantStatusCode   int
+		wantLocation     string
 	}{
 		{
-			name:           "new movie",
+			name: "new movie",
+			existingMovies: []Movie{
+				{
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
+				},
+			},
+			requestBody: `
+			{
+				"title":     "singh",
+				"director":  "paramveer",
+				"imdb":      8,
+				"hollywood": "no",
+				"bollywood": "yes"
+				}`,
+			wantResponseBody: `
+			{
+				"id": 2,
+				"title": "singh",
+				"director": "paramveer",
+				"imdb": 8,
+				"industry": "bollywood",
+				"genres": [],
+				"version": 1
+			}`,
+			wantStatusCode: http.StatusCreated,
+			wantLocation:   "/api/movies/2",
+		},
+		{
+			name:           "client id without import mode",
 			existingMovies: []Movie{},
 			requestBody: `
 			{
@@ -31,27 +70,55 @@ func Test_movieHandler_createMovie(t *testing.T) {
 				}`,
 			wantResponseBody: `
 			{
-				"id": 1,
+				"type": "/problems/client_id",
+				"title": "id is assigned by the server",
+				"status": 400,
+				"detail": "id is assigned by the server",
+				"code": "client_id",
+				"errors": [
+					{"field": "id", "code": "client_id", "message": "id is assigned by the server"}
+				]
+			}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "client id in import mode",
+			existingMovies: []Movie{},
+			importMode:     true,
+			requestBody: `
+			{
+				"id":        5,
+				"title":     "bhamsa",
+				"director":  "paramveer",
+				"imdb":      8,
+				"hollywood": "no",
+				"bollywood": "yes"
+				}`,
+			wantResponseBody: `
+			{
+				"id": 5,
 				"title": "bhamsa",
 				"director": "paramveer",
 				"imdb": 8,
-				"hollywood": "no",
-				"bollywood": "yes"
+				"industry": "bollywood",
+				"genres": [],
+				"version": 1
 			}`,
-			wantStatusCode: http.StatusOK,
+			wantStatusCode: http.StatusCreated,
+			wantLocation:   "/api/movies/5",
 		},
 		{
 			name: "conflict",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
 				},
 			},
+			importMode: true,
 			requestBody: `
 			{
 				"id":        1,
@@ -61,61 +128,122 @@ func Test_movieHandler_createMovie(t *testing.T) {
 				"hollywood": "no",
 				"bollywood": "yes"
 				}`,
-			wantResponseBody: `"movie already exist"`,
-			wantStatusCode:   http.StatusConflict,
+			wantResponseBody: `
+			{
+				"type": "/problems/movie_conflict",
+				"title": "movie already exist",
+				"status": 409,
+				"detail": "movie already exist",
+				"code": "movie_conflict"
+			}`,
+			wantStatusCode: http.StatusConflict,
 		},
 		{
 			name:           "invalid id",
 			existingMovies: []Movie{},
+			importMode:     true,
 			requestBody: `
 			{
-				"id":        0,
+				"id":        -1,
 				"title":     "bhamsa",
 				"director":  "paramveer",
 				"imdb":      8,
 				"hollywood": "no",
 				"bollywood": "yes"
 				}`,
-			wantResponseBody: `"invalid id"`,
-			wantStatusCode:   http.StatusBadRequest,
+			wantResponseBody: `
+			{
+				"type": "/problems/invalid_movie",
+				"title": "invalid movie",
+				"status": 400,
+				"detail": "invalid movie: id: must be a positive number",
+				"code": "invalid_movie",
+				"errors": [
+					{"field": "id", "code": "invalid_id", "message": "must be a positive number"}
+				]
+			}`,
+			wantStatusCode: http.StatusBadRequest,
 		},
 		{
 			name:           "invalid rating",
 			existingMovies: []Movie{},
 			requestBody: `
 			{
-				"id":        1,
 				"title":     "bhamsa",
 				"director":  "paramveer",
 				"imdb":      11,
 				"hollywood": "no",
 				"bollywood": "yes"
 				}`,
-			wantResponseBody: `"invalid rating"`,
-			wantStatusCode:   http.StatusBadRequest,
+			wantResponseBody: `
+			{
+				"type": "/problems/invalid_movie",
+				"title": "invalid movie",
+				"status": 400,
+				"detail": "invalid movie: imdb: must be between 1 and 10",
+				"code": "invalid_movie",
+				"errors": [
+					{"field": "imdb", "code": "invalid_rating", "message": "must be between 1 and 10"}
+				]
+			}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "several invalid fields",
+			existingMovies: []Movie{},
+			requestBody: `
+			{
+				"title":     "   ",
+				"director":  "paramveer",
+				"imdb":      7.25,
+				"industry":  "nollywood"
+				}`,
+			wantResponseBody: `
+			{
+				"type": "/problems/invalid_movie",
+				"title": "invalid movie",
+				"status": 400,
+				"detail": "invalid movie: title: is required; imdb: must have at most 1 decimal place(s); industry: must be one of hollywood, bollywood, tollywood, kollywood, korean, other",
+				"code": "invalid_movie",
+				"errors": [
+					{"field": "title", "code": "required", "message": "is required"},
+					{"field": "imdb", "code": "too_precise", "message": "must have at most 1 decimal place(s)"},
+					{"field": "industry", "code": "not_allowed", "message": "must be one of hollywood, bollywood, tollywood, kollywood, korean, other"}
+				]
+			}`,
+			wantStatusCode: http.StatusBadRequest,
 		},
 		{
 			name:           "invalid body",
 			existingMovies: []Movie{},
 			requestBody: `
 			{
-				"id":        1,
 				"title":     "bhamsa",
 				"director":  "paramveer",
 				"imdb":      11,
 				"hollywood": "no",
 				"bollywood": "yes" invalid body
 				}`,
-			wantResponseBody: `"invalid body"`,
-			wantStatusCode:   http.StatusBadRequest,
+			wantResponseBody: `
+			{
+				"type": "/problems/invalid_body",
+				"title": "invalid body",
+				"status": 400,
+				"detail": "invalid body: invalid character 'i' after object key:value pair",
+				"code": "invalid_body"
+			}`,
+			wantStatusCode: http.StatusBadRequest,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			repo.movies = tt.existingMovies
+			repo := newSeededRepo(t, tt.existingMovies)
 
-			serv := Newservice(repo)
+			var opts []serviceOption
+			if tt.importMode {
+				opts = append(opts, withClientIds())
+			}
+			serv := Newservice(repo, opts...)
 			transport := NewMovieHandler(serv)
 
 			reqBody := strings.NewReader(tt.requestBody)
@@ -129,6 +257,10 @@ func Test_movieHandler_createMovie(t *testing.T) {
 			if res.Code != tt.wantStatusCode {
 				t.Errorf("want statuscode %d but got %d", tt.wantStatusCode, res.Code)
 			}
+
+			if got := res.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("want location %q but got %q", tt.wantLocation, got)
+			}
 		})
 	}
 }
@@ -142,15 +274,14 @@ func Test_movieHandler_updateMovie(t *testing.T) {
 		wantStatusCode   int
 	}{
 		{
-			name: "invalid id",
+			name: "id of 0 takes the path id",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 			},
 			requestBody: `
@@ -158,23 +289,58 @@ func Test_movieHandler_updateMovie(t *testing.T) {
 				"id":        0,
 				"title":     "bhamsa",
 				"director":  "paramveer",
-				"imdb":      10,
+				"imdb":      9,
 				"hollywood": "no",
 				"bollywood": "yes"
 				}`,
-			wantResponseBody: `"invalid id"`,
-			wantStatusCode:   http.StatusBadRequest,
+			wantResponseBody: `{
+				"id":        1,
+				"title":     "bhamsa",
+				"director":  "paramveer",
+				"imdb":      9,
+				"industry": "bollywood",
+				"genres": [],
+				"version":   2
+				}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name: "no id takes the path id",
+			existingMovies: []Movie{
+				{
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
+				},
+			},
+			requestBody: `
+			{
+				"title":    "x",
+				"director": "y",
+				"imdb":     7
+			}`,
+			wantResponseBody: `{
+				"id":        1,
+				"title":     "x",
+				"director":  "y",
+				"imdb":      7,
+				"industry":  "",
+				"genres": [],
+				"version":   2
+				}`,
+			wantStatusCode: http.StatusOK,
 		},
 		{
 			name: "invalid rating",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 			},
 			requestBody: `
@@ -186,19 +352,29 @@ func Test_movieHandler_updateMovie(t *testing.T) {
 				"hollywood": "no",
 				"bollywood": "yes"
 				}`,
-			wantResponseBody: `"invalid rating"`,
-			wantStatusCode:   http.StatusBadRequest,
+			wantResponseBody: `
+			{
+				"type": "/problems/invalid_movie",
+				"title": "invalid movie",
+				"status": 400,
+				"detail": "invalid movie: imdb: must be between 1 and 10",
+				"code": "invalid_movie",
+				"request_id": "test-request",
+				"errors": [
+					{"field": "imdb", "code": "invalid_rating", "message": "must be between 1 and 10"}
+				]
+			}`,
+			wantStatusCode: http.StatusBadRequest,
 		},
 		{
 			name: "movie not found",
 			existingMovies: []Movie{
 				{
-					ID:        2,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 			},
 			requestBody: `
@@ -210,19 +386,26 @@ func Test_movieHandler_updateMovie(t *testing.T) {
 				"hollywood": "no",
 				"bollywood": "yes"
 				}`,
-			wantResponseBody: `"movie not found"`,
-			wantStatusCode:   http.StatusNotFound,
+			wantResponseBody: `
+			{
+				"type": "/problems/movie_not_found",
+				"title": "movie not found",
+				"status": 404,
+				"detail": "movie doesn't found",
+				"code": "movie_not_found",
+				"request_id": "test-request"
+			}`,
+			wantStatusCode: http.StatusNotFound,
 		},
 		{
 			name: "invalid body",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 			},
 			requestBody: `
@@ -234,32 +417,38 @@ func Test_movieHandler_updateMovie(t *testing.T) {
 				"hollywood": "no",
 				"bollywood": "yes"dvbdhvdv
 				}`,
-			wantResponseBody: `"invalid body"`,
-			wantStatusCode:   http.StatusBadRequest,
+			wantResponseBody: `
+			{
+				"type": "/problems/invalid_body",
+				"title": "invalid body",
+				"status": 400,
+				"detail": "invalid body: invalid character 'd' after object key:value pair",
+				"code": "invalid_body",
+				"request_id": "test-request"
+			}`,
+			wantStatusCode: http.StatusBadRequest,
 		},
 		{
 			name: "movie exist",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 				{
-					ID:        2,
-					Title:     "Bhamsa",
-					Director:  "Paramveer",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Bhamsa",
+					Director: "Paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 			},
 			requestBody: `
 			{
-				"id":        11,
+				"id":        1,
 				"title":     "bhamsa",
 				"director":  "paramveer",
 				"imdb":      10,
@@ -267,27 +456,61 @@ func Test_movieHandler_updateMovie(t *testing.T) {
 				"bollywood": "yes"
 				}`,
 			wantResponseBody: `{
-				"id":        11,
+				"id":        1,
 				"title":     "bhamsa",
 				"director":  "paramveer",
 				"imdb":      10,
-				"hollywood": "no",
-				"bollywood": "yes"
+				"industry": "bollywood",
+				"genres": [],
+				"version":   2
 				}`,
 			wantStatusCode: http.StatusOK,
 		},
+		{
+			name: "id mismatch",
+			existingMovies: []Movie{
+				{
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
+				},
+			},
+			requestBody: `
+			{
+				"id":        11,
+				"title":     "bhamsa",
+				"director":  "paramveer",
+				"imdb":      10,
+				"industry":  "bollywood"
+			}`,
+			wantResponseBody: `
+			{
+				"type": "/problems/id_mismatch",
+				"title": "id in the body does not match the path",
+				"status": 400,
+				"detail": "id in the body does not match the path: got 11 in the body for movie 1; use move to change it",
+				"code": "id_mismatch",
+				"request_id": "test-request",
+				"errors": [
+					{"field": "id", "code": "id_mismatch", "message": "id in the body does not match the path: got 11 in the body for movie 1; use move to change it"}
+				]
+			}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			repo.movies = tt.existingMovies
+			repo := newSeededRepo(t, tt.existingMovies)
 			serv := Newservice(repo)
 			transport := NewMovieHandler(serv)
 			router := registerRoutes(transport)
 
 			reqBody := strings.NewReader(tt.requestBody)
 			req := httptest.NewRequest("PUT", "/api/movies/1", reqBody)
+			req.Header.Set(requestIDHeader, "test-request")
 
 			res := httptest.NewRecorder()
 
@@ -302,6 +525,189 @@ func Test_movieHandler_updateMovie(t *testing.T) {
 	}
 }
 
+func Test_movieHandler_getMoviesQuery(t *testing.T) {
+	existingMovies := []Movie{
+		{ID: 1, Title: "Bhamsa", Director: "Paramveer", IMDb: 7.5, Industry: IndustryBollywood, Genres: GenreDrama},
+		{ID: 2, Title: "Hardik", Director: "Sharma", IMDb: 9, Industry: IndustryHollywood, Genres: GenreDrama | GenreCrime},
+		{ID: 3, Title: "Singh is King", Director: "Paramveer", IMDb: 8, Industry: IndustryBollywood, Genres: GenreComedy},
+		{ID: 4, Title: "Kingdom", Director: "sharma", IMDb: 6, Industry: IndustryHollywood},
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		wantIds        []int
+		wantTotal      int
+		wantNextCursor bool
+		wantStatusCode int
+	}{
+		{
+			name:           "director is case insensitive",
+			query:          "?director=sharma",
+			wantIds:        []int{2, 4},
+			wantTotal:      2,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "title contains",
+			query:          "?title_contains=KING",
+			wantIds:        []int{3, 4},
+			wantTotal:      2,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "rating range",
+			query:          "?min_imdb=7&max_imdb=8",
+			wantIds:        []int{1, 3},
+			wantTotal:      2,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "industry",
+			query:          "?industry=Hollywood",
+			wantIds:        []int{2, 4},
+			wantTotal:      2,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "any of several industries",
+			query:          "?industry=korean,bollywood",
+			wantIds:        []int{1, 3},
+			wantTotal:      2,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "every genre",
+			query:          "?genre=crime,DRAMA",
+			wantIds:        []int{2},
+			wantTotal:      1,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "genre and industry",
+			query:          "?genre=drama&industry=bollywood",
+			wantIds:        []int{1},
+			wantTotal:      1,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "sort by several fields",
+			query:          "?sort=director,-imdb",
+			wantIds:        []int{3, 1, 2, 4},
+			wantTotal:      4,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "first page",
+			query:          "?sort=-imdb&limit=3",
+			wantIds:        []int{2, 3, 1},
+			wantTotal:      4,
+			wantNextCursor: true,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "last page",
+			query:          "?sort=-imdb&limit=3&cursor=" + encodeCursor(3),
+			wantIds:        []int{4},
+			wantTotal:      4,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "unknown sort field",
+			query:          "?sort=budget",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "limit too large",
+			query:          "?limit=100000",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "rating is not a number",
+			query:          "?min_imdb=good",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "malformed cursor",
+			query:          "?cursor=nope",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown industry",
+			query:          "?industry=nollywood",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown genre",
+			query:          "?genre=noir",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "legacy hollywood flag",
+			query:          "?hollywood=yes",
+			wantIds:        []int{2, 4},
+			wantTotal:      2,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "legacy flags together",
+			query:          "?bollywood=Yes&hollywood=no",
+			wantIds:        []int{1, 3},
+			wantTotal:      2,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "legacy flag set to no",
+			query:          "?hollywood=no",
+			wantIds:        []int{1, 3},
+			wantTotal:      2,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "legacy flags both yes",
+			query:          "?hollywood=yes&bollywood=yes",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "legacy flag with industry",
+			query:          "?hollywood=yes&industry=korean",
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newSeededRepo(t, existingMovies)
+			router := registerRoutes(NewMovieHandler(Newservice(repo)))
+
+			req := httptest.NewRequest("GET", "/api/movies"+tt.query, nil)
+			req.Header.Set(requestIDHeader, "test-request")
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatusCode {
+				t.Fatalf("want statuscode %d but got %d: %s", tt.wantStatusCode, res.Code, res.Body)
+			}
+			if tt.wantStatusCode != http.StatusOK {
+				return
+			}
+
+			var page moviePage
+			if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+				t.Fatalf("failed to decode response: %q", err)
+			}
+
+			var gotIds []int
+			for _, movie := range page.Movies {
+				gotIds = append(gotIds, movie.ID)
+			}
+			assert.Equal(t, tt.wantIds, gotIds)
+			assert.Equal(t, tt.wantTotal, page.Total)
+			assert.Equal(t, tt.wantNextCursor, page.NextCursor != "")
+		})
+	}
+}
+
 func Test_movieHandler_getMovie(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -314,44 +720,60 @@ func Test_movieHandler_getMovie(t *testing.T) {
 			name: "invalid id",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
 				},
 			},
-			path:             "/api/movies/-1",
-			wantResponseBody: `"invalid id"`,
-			wantStatusCode:   http.StatusBadRequest,
+			path: "/api/movies/-1",
+			wantResponseBody: `
+			{
+				"type": "/problems/invalid_id",
+				"title": "invalid id",
+				"status": 400,
+				"detail": "invalid id",
+				"code": "invalid_id",
+				"request_id": "test-request",
+				"errors": [
+					{"field": "id", "code": "invalid_id", "message": "invalid id"}
+				]
+			}`,
+			wantStatusCode: http.StatusBadRequest,
 		},
 		{
 			name: "movie not found",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
 				},
 			},
-			path:             "/api/movies/2",
-			wantResponseBody: `"movie not found"`,
-			wantStatusCode:   http.StatusNotFound,
+			path: "/api/movies/2",
+			wantResponseBody: `
+			{
+				"type": "/problems/movie_not_found",
+				"title": "movie not found",
+				"status": 404,
+				"detail": "movie doesn't found",
+				"code": "movie_not_found",
+				"request_id": "test-request"
+			}`,
+			wantStatusCode: http.StatusNotFound,
 		},
 		{
 			name: "movie found",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
 				},
 			},
 			path: "/api/movies/1",
@@ -361,8 +783,9 @@ func Test_movieHandler_getMovie(t *testing.T) {
 				"title": "bhamsa",
 				"director": "paramveer",
 				"imdb": 8,
-				"hollywood": "no",
-				"bollywood": "yes"
+				"industry": "bollywood",
+				"genres": [],
+				"version": 1
 			}`,
 			wantStatusCode: http.StatusOK,
 		},
@@ -370,13 +793,13 @@ func Test_movieHandler_getMovie(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			repo.movies = tt.existingMovies
+			repo := newSeededRepo(t, tt.existingMovies)
 			serv := Newservice(repo)
 			transport := NewMovieHandler(serv)
 			router := registerRoutes(transport)
 
 			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set(requestIDHeader, "test-request")
 
 			res := httptest.NewRecorder()
 
@@ -401,46 +824,49 @@ func Test_movieHandler_getMovies(t *testing.T) {
 		{
 			name:             "no movie exist  ",
 			existingMovies:   []Movie{},
-			wantResponseBody: `[]`,
+			wantResponseBody: `{"movies": [], "total": 0}`,
 			wantStatusCode:   http.StatusOK,
 		},
 		{
 			name: " movies exist  ",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
 				},
 			},
 			wantResponseBody: `
-			[
-			  {
-				"id": 1,
-				"title": "bhamsa",
-				"director": "paramveer",
-				"imdb": 8,
-				"hollywood": "no",
-				"bollywood": "yes"
-			  }
-			]`,
+			{
+			  "movies": [
+			    {
+				  "id": 1,
+				  "title": "bhamsa",
+				  "director": "paramveer",
+				  "imdb": 8,
+				  "industry": "bollywood",
+				  "genres": [],
+				  "version": 1
+			    }
+			  ],
+			  "total": 1
+			}`,
 			wantStatusCode: http.StatusOK,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			repo.movies = tt.existingMovies
+			repo := newSeededRepo(t, tt.existingMovies)
 
 			serv := Newservice(repo)
 			transport := NewMovieHandler(serv)
 			router := registerRoutes(transport)
 
 			req := httptest.NewRequest("GET", "/api/movies", nil)
+			req.Header.Set(requestIDHeader, "test-request")
 			res := httptest.NewRecorder()
 
 			router.ServeHTTP(res, req)
@@ -466,35 +892,52 @@ func Test_movieHandler_deleteMovie(t *testing.T) {
 			name: "invalid id",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
 				},
 			},
-			path:             "/api/movies/-1",
-			wantResponseBody: `"invalid id"`,
-			wantStatusCode:   http.StatusBadRequest,
+			path: "/api/movies/-1",
+			wantResponseBody: `
+			{
+				"type": "/problems/invalid_id",
+				"title": "invalid id",
+				"status": 400,
+				"detail": "invalid id",
+				"code": "invalid_id",
+				"request_id": "test-request",
+				"errors": [
+					{"field": "id", "code": "invalid_id", "message": "invalid id"}
+				]
+			}`,
+			wantStatusCode: http.StatusBadRequest,
 		},
 		{
-			name:             "movie not found",
-			existingMovies:   []Movie{},
-			path:             "/api/movies/1",
-			wantResponseBody: `"movie not found"`,
-			wantStatusCode:   http.StatusNotFound,
+			name:           "movie not found",
+			existingMovies: []Movie{},
+			path:           "/api/movies/1",
+			wantResponseBody: `
+			{
+				"type": "/problems/movie_not_found",
+				"title": "movie not found",
+				"status": 404,
+				"detail": "movie doesn't found",
+				"code": "movie_not_found",
+				"request_id": "test-request"
+			}`,
+			wantStatusCode: http.StatusNotFound,
 		},
 		{
 			name: "delete ok",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
 				},
 			},
 			path: "/api/movies/1",
@@ -504,8 +947,10 @@ func Test_movieHandler_deleteMovie(t *testing.T) {
 				"title":     "bhamsa",
 				"director":  "paramveer",
 				"imdb":      8,
-				"hollywood": "no",
-				"bollywood": "yes"
+				"industry": "bollywood",
+				"genres": [],
+				"version": 2,
+				"deleted_at": "2024-01-02T03:04:05Z"
 				}`,
 			wantStatusCode: http.StatusOK,
 		},
@@ -513,14 +958,15 @@ func Test_movieHandler_deleteMovie(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			repo.movies = tt.existingMovies
+			repo := newSeededRepo(t, tt.existingMovies)
+			repo.now = func() time.Time { return testDeletedAt }
 
 			serv := Newservice(repo)
 			transport := NewMovieHandler(serv)
 			router := registerRoutes(transport)
 
 			req := httptest.NewRequest("DELETE", tt.path, nil)
+			req.Header.Set(requestIDHeader, "test-request")
 			res := httptest.NewRecorder()
 
 			router.ServeHTTP(res, req)
@@ -533,3 +979,550 @@ func Test_movieHandler_deleteMovie(t *testing.T) {
 		})
 	}
 }
+
+func Test_movieHandler_contextErrors(t *testing.T) {
+	tests := []struct {
+		name             string
+		ctx              func() (context.Context, context.CancelFunc)
+		wantResponseBody string
+		wantStatusCode   int
+	}{
+		{
+			name: "client went away",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			wantResponseBody: `
+			{
+				"type": "/problems/request_canceled",
+				"title": "request canceled",
+				"status": 499,
+				"detail": "context canceled",
+				"code": "request_canceled",
+				"request_id": "test-request"
+			}`,
+			wantStatusCode: statusClientClosedRequest,
+		},
+		{
+			name: "deadline exceeded",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+			},
+			wantResponseBody: `
+			{
+				"type": "/problems/request_timeout",
+				"title": "request timed out",
+				"status": 504,
+				"detail": "context deadline exceeded",
+				"code": "request_timeout",
+				"request_id": "test-request"
+			}`,
+			wantStatusCode: http.StatusGatewayTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newSeededRepo(t, []Movie{
+				{
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
+				},
+			})
+			serv := Newservice(repo)
+			router := registerRoutes(NewMovieHandler(serv))
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			req := httptest.NewRequest("GET", "/api/movies/1", nil).WithContext(ctx)
+			req.Header.Set(requestIDHeader, "test-request")
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			assert.JSONEqf(t, tt.wantResponseBody, res.Body.String(), "want  %s but got %s", tt.wantResponseBody, res.Body.String())
+
+			if res.Code != tt.wantStatusCode {
+				t.Errorf("want statuscode %d but got %d", tt.wantStatusCode, res.Code)
+			}
+		})
+	}
+}
+
+func Test_resolveError(t *testing.T) {
+	tests := []struct {
+		name             string
+		method           string
+		path             string
+		requestID        string
+		wantResponseBody string
+		wantStatusCode   int
+	}{
+		{
+			name:      "unknown route",
+			method:    "GET",
+			path:      "/api/films",
+			requestID: "test-request",
+			wantResponseBody: `{
+				"type": "/problems/route_not_found",
+				"title": "route not found",
+				"status": 404,
+				"detail": "no such route",
+				"code": "route_not_found",
+				"request_id": "test-request"
+			}`,
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:      "method not allowed",
+			method:    "PATCH",
+			path:      "/api/movies",
+			requestID: "test-request",
+			wantResponseBody: `{
+				"type": "/problems/method_not_allowed",
+				"title": "method not allowed",
+				"status": 405,
+				"detail": "method not allowed",
+				"code": "method_not_allowed",
+				"request_id": "test-request"
+			}`,
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:      "path id is not a number",
+			method:    "GET",
+			path:      "/api/movies/one",
+			requestID: "test-request",
+			wantResponseBody: `{
+				"type": "/problems/invalid_path_id",
+				"title": "cannot access id",
+				"status": 400,
+				"detail": "cannot access id: strconv.Atoi: parsing \"one\": invalid syntax",
+				"code": "invalid_path_id",
+				"request_id": "test-request"
+			}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := registerRoutes(NewMovieHandler(Newservice(NewInMemoryRepo())))
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set(requestIDHeader, tt.requestID)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			assert.JSONEqf(t, tt.wantResponseBody, res.Body.String(), "want  %s but got %s", tt.wantResponseBody, res.Body.String())
+
+			if res.Code != tt.wantStatusCode {
+				t.Errorf("want statuscode %d but got %d", tt.wantStatusCode, res.Code)
+			}
+
+			if got := res.Header().Get("Content-Type"); got != problemContentType {
+				t.Errorf("want content type %q but got %q", problemContentType, got)
+			}
+
+			if got := res.Header().Get(requestIDHeader); got != tt.requestID {
+				t.Errorf("want request id %q but got %q", tt.requestID, got)
+			}
+		})
+	}
+}
+
+func Test_resolveErrorHidesInternalErrors(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/movies", nil)
+	res := httptest.NewRecorder()
+
+	withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resolveError(w, r, errors.New("disk on fire"))
+	})).ServeHTTP(res, req)
+
+	var p problem
+	if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+		t.Fatalf("failed to decode response: %q", err)
+	}
+
+	if p.Status != http.StatusInternalServerError || p.Code != "internal_error" {
+		t.Errorf("got status %d and code %q", p.Status, p.Code)
+	}
+	if p.Detail != "" {
+		t.Errorf("internal detail %q leaked to the client", p.Detail)
+	}
+	if p.RequestID == "" || p.RequestID != res.Header().Get(requestIDHeader) {
+		t.Errorf("got request id %q in body and %q in header", p.RequestID, res.Header().Get(requestIDHeader))
+	}
+}
+
+func Test_movieHandler_patchMovie(t *testing.T) {
+	tests := []struct {
+		name             string
+		path             string
+		contentType      string
+		requestBody      string
+		wantResponseBody string
+		wantStatusCode   int
+	}{
+		{
+			name:        "merge patch",
+			path:        "/api/movies/1",
+			contentType: mergePatchContentType,
+			requestBody: `{"imdb": 9.5, "title": "  singh  "}`,
+			wantResponseBody: `{
+				"id": 1,
+				"title": "singh",
+				"director": "paramveer",
+				"imdb": 9.5,
+				"industry": "bollywood",
+				"genres": [],
+				"version": 2
+			}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:        "json patch",
+			path:        "/api/movies/1",
+			contentType: jsonPatchContentType,
+			requestBody: `[{"op": "replace", "path": "/director", "value": "hardik"}]`,
+			wantResponseBody: `{
+				"id": 1,
+				"title": "bhamsa",
+				"director": "hardik",
+				"imdb": 8,
+				"industry": "bollywood",
+				"genres": [],
+				"version": 2
+			}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:        "legacy industry flags",
+			path:        "/api/movies/1",
+			contentType: mergePatchContentType,
+			requestBody: `{"hollywood": "yes", "bollywood": "no"}`,
+			wantResponseBody: `{
+				"id": 1,
+				"title": "bhamsa",
+				"director": "paramveer",
+				"imdb": 8,
+				"industry": "hollywood",
+				"genres": [],
+				"version": 2
+			}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:        "patched movie is revalidated",
+			path:        "/api/movies/1",
+			contentType: mergePatchContentType,
+			requestBody: `{"title": null}`,
+			wantResponseBody: `{
+				"type": "/problems/invalid_movie",
+				"title": "invalid movie",
+				"status": 400,
+				"detail": "invalid movie: title: is required",
+				"code": "invalid_movie",
+				"request_id": "test-request",
+				"errors": [
+					{"field": "title", "code": "required", "message": "is required"}
+				]
+			}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:        "movie not found",
+			path:        "/api/movies/2",
+			contentType: mergePatchContentType,
+			requestBody: `{"imdb": 9}`,
+			wantResponseBody: `{
+				"type": "/problems/movie_not_found",
+				"title": "movie not found",
+				"status": 404,
+				"detail": "movie doesn't found",
+				"code": "movie_not_found",
+				"request_id": "test-request"
+			}`,
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:        "unsupported media type",
+			path:        "/api/movies/1",
+			contentType: "text/plain",
+			requestBody: `imdb=9`,
+			wantResponseBody: `{
+				"type": "/problems/unsupported_patch",
+				"title": "unsupported patch media type",
+				"status": 415,
+				"detail": "unsupported patch media type: \"text/plain\"",
+				"code": "unsupported_patch",
+				"request_id": "test-request"
+			}`,
+			wantStatusCode: http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newSeededRepo(t, []Movie{
+				{
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
+				},
+			})
+			router := registerRoutes(NewMovieHandler(Newservice(repo)))
+
+			req := httptest.NewRequest("PATCH", tt.path, strings.NewReader(tt.requestBody))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set(requestIDHeader, "test-request")
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			assert.JSONEqf(t, tt.wantResponseBody, res.Body.String(), "want  %s but got %s", tt.wantResponseBody, res.Body.String())
+
+			if res.Code != tt.wantStatusCode {
+				t.Errorf("want statuscode %d but got %d", tt.wantStatusCode, res.Code)
+			}
+		})
+	}
+}
+
+func Test_movieHandler_ifMatch(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		ifMatch        string
+		requireIfMatch bool
+		wantStatusCode int
+		wantCode       string
+		wantETag       string
+	}{
+		{name: "get returns etag", method: "GET", wantStatusCode: http.StatusOK, wantETag: `"1"`},
+		{name: "put matching", method: "PUT", body: `{"id": 1, "title": "singh", "director": "paramveer", "imdb": 8}`, ifMatch: `"1"`, wantStatusCode: http.StatusOK, wantETag: `"2"`},
+		{name: "put stale", method: "PUT", body: `{"id": 1, "title": "singh", "director": "paramveer", "imdb": 8}`, ifMatch: `"2"`, wantStatusCode: http.StatusPreconditionFailed, wantCode: "version_conflict"},
+		{name: "if-match wins over body version", method: "PUT", body: `{"id": 1, "title": "singh", "director": "paramveer", "imdb": 8, "version": 7}`, ifMatch: `"1"`, wantStatusCode: http.StatusOK, wantETag: `"2"`},
+		{name: "stale body version", method: "PUT", body: `{"id": 1, "title": "singh", "director": "paramveer", "imdb": 8, "version": 7}`, wantStatusCode: http.StatusPreconditionFailed, wantCode: "version_conflict"},
+		{name: "patch matching", method: "PATCH", body: `{"imdb": 9}`, ifMatch: `"1"`, wantStatusCode: http.StatusOK, wantETag: `"2"`},
+		{name: "patch cannot change version", method: "PATCH", body: `{"version": 5}`, wantStatusCode: http.StatusOK, wantETag: `"2"`},
+		{name: "patch stale", method: "PATCH", body: `{"imdb": 9}`, ifMatch: `"3"`, wantStatusCode: http.StatusPreconditionFailed, wantCode: "version_conflict"},
+		{name: "delete wildcard", method: "DELETE", ifMatch: "*", requireIfMatch: true, wantStatusCode: http.StatusOK},
+		{name: "delete stale", method: "DELETE", ifMatch: `"2"`, wantStatusCode: http.StatusPreconditionFailed, wantCode: "version_conflict"},
+		{name: "weak tag never matches", method: "DELETE", ifMatch: `W/"1"`, wantStatusCode: http.StatusPreconditionFailed, wantCode: "version_conflict"},
+		{name: "required but missing", method: "DELETE", requireIfMatch: true, wantStatusCode: http.StatusPreconditionRequired, wantCode: "precondition_required"},
+		{name: "required on patch", method: "PATCH", body: `{"imdb": 9}`, requireIfMatch: true, wantStatusCode: http.StatusPreconditionRequired, wantCode: "precondition_required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newSeededRepo(t, []Movie{{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8}})
+			var opts []handlerOption
+			if tt.requireIfMatch {
+				opts = append(opts, withRequiredIfMatch())
+			}
+			router := registerRoutes(NewMovieHandler(Newservice(repo), opts...))
+
+			req := httptest.NewRequest(tt.method, "/api/movies/1", strings.NewReader(tt.body))
+			if tt.method == "PATCH" {
+				req.Header.Set("Content-Type", mergePatchContentType)
+			}
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatusCode {
+				t.Fatalf("want statuscode %d but got %d: %s", tt.wantStatusCode, res.Code, res.Body.String())
+			}
+			if got := res.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("want etag %q but got %q", tt.wantETag, got)
+			}
+			if tt.wantCode != "" {
+				var p problem
+				if err := json.Unmarshal(res.Body.Bytes(), &p); err != nil {
+					t.Fatalf("invalid problem body %q: %q", res.Body.String(), err)
+				}
+				if p.Code != tt.wantCode {
+					t.Errorf("want code %q but got %q", tt.wantCode, p.Code)
+				}
+			}
+		})
+	}
+}
+
+func Test_movieHandler_trash(t *testing.T) {
+	repo := newSeededRepo(t, []Movie{
+		{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8},
+		{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9},
+	})
+	router := registerRoutes(NewMovieHandler(Newservice(repo)))
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		ifMatch        string
+		wantStatusCode int
+		wantCode       string
+		wantBody       string
+	}{
+		{name: "delete", method: "DELETE", target: "/api/movies/1", wantStatusCode: http.StatusOK},
+		{name: "delete again", method: "DELETE", target: "/api/movies/1", wantStatusCode: http.StatusNotFound, wantCode: "movie_not_found"},
+		{name: "trash lists it", method: "GET", target: "/api/movies/trash", wantStatusCode: http.StatusOK, wantBody: `"total":1`},
+		{name: "live list does not", method: "GET", target: "/api/movies", wantStatusCode: http.StatusOK, wantBody: `"total":1`},
+		{name: "restore stale", method: "POST", target: "/api/movies/1/restore", ifMatch: `"1"`, wantStatusCode: http.StatusPreconditionFailed, wantCode: "version_conflict"},
+		{name: "restore", method: "POST", target: "/api/movies/1/restore", ifMatch: `"2"`, wantStatusCode: http.StatusOK, wantBody: `"version":3`},
+		{name: "restore live movie", method: "POST", target: "/api/movies/1/restore", wantStatusCode: http.StatusNotFound, wantCode: "movie_not_found"},
+		{name: "purge live movie", method: "DELETE", target: "/api/movies/trash/1", wantStatusCode: http.StatusNotFound, wantCode: "movie_not_found"},
+		{name: "delete other", method: "DELETE", target: "/api/movies/2", wantStatusCode: http.StatusOK},
+		{name: "purge one", method: "DELETE", target: "/api/movies/trash/2", wantStatusCode: http.StatusOK, wantBody: `"id":2`},
+		{name: "empty trash", method: "DELETE", target: "/api/movies/trash", wantStatusCode: http.StatusOK, wantBody: `{"purged":0}`},
+		{name: "bad cutoff", method: "DELETE", target: "/api/movies/trash?before=yesterday", wantStatusCode: http.StatusBadRequest, wantCode: "invalid_query"},
+	}
+	// The steps share one repo, so they run in order and stop at the first failure.
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.ifMatch != "" {
+			req.Header.Set("If-Match", tt.ifMatch)
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		if res.Code != tt.wantStatusCode {
+			t.Fatalf("%s: want statuscode %d but got %d: %s", tt.name, tt.wantStatusCode, res.Code, res.Body.String())
+		}
+		if tt.wantBody != "" && !strings.Contains(res.Body.String(), tt.wantBody) {
+			t.Fatalf("%s: want body containing %s but got %s", tt.name, tt.wantBody, res.Body.String())
+		}
+		if tt.wantCode != "" {
+			var p problem
+			if err := json.Unmarshal(res.Body.Bytes(), &p); err != nil {
+				t.Fatalf("%s: invalid problem body %q: %q", tt.name, res.Body.String(), err)
+			}
+			if p.Code != tt.wantCode {
+				t.Fatalf("%s: want code %q but got %q", tt.name, tt.wantCode, p.Code)
+			}
+		}
+	}
+}
+
+func Test_movieHandler_moveMovie(t *testing.T) {
+	repo := newSeededRepo(t, []Movie{
+		{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8},
+		{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9},
+	})
+	router := registerRoutes(NewMovieHandler(Newservice(repo)))
+
+	tests := []struct {
+		name           string
+		target         string
+		body           string
+		ifMatch        string
+		wantStatusCode int
+		wantCode       string
+		wantLocation   string
+	}{
+		{name: "to a taken id", target: "/api/movies/1/move", body: `{"id": 2}`, wantStatusCode: http.StatusConflict, wantCode: "movie_conflict"},
+		{name: "to the same id", target: "/api/movies/1/move", body: `{"id": 1}`, wantStatusCode: http.StatusBadRequest, wantCode: "invalid_move"},
+		{name: "without an id", target: "/api/movies/1/move", body: `{}`, wantStatusCode: http.StatusBadRequest, wantCode: "invalid_move"},
+		{name: "bad body", target: "/api/movies/1/move", body: `{"id": "five"}`, wantStatusCode: http.StatusBadRequest, wantCode: "invalid_body"},
+		{name: "stale version", target: "/api/movies/1/move", body: `{"id": 5}`, ifMatch: `"2"`, wantStatusCode: http.StatusPreconditionFailed, wantCode: "version_conflict"},
+		{name: "move", target: "/api/movies/1/move", body: `{"id": 5}`, ifMatch: `"1"`, wantStatusCode: http.StatusOK, wantLocation: "/api/movies/5"},
+		{name: "old id is gone", target: "/api/movies/1/move", body: `{"id": 6}`, wantStatusCode: http.StatusNotFound, wantCode: "movie_not_found"},
+	}
+	// The steps share one repo, so they run in order and stop at the first failure.
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+		if tt.ifMatch != "" {
+			req.Header.Set("If-Match", tt.ifMatch)
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		if res.Code != tt.wantStatusCode {
+			t.Fatalf("%s: want statuscode %d but got %d: %s", tt.name, tt.wantStatusCode, res.Code, res.Body.String())
+		}
+		if tt.wantCode != "" {
+			var p problem
+			if err := json.Unmarshal(res.Body.Bytes(), &p); err != nil {
+				t.Fatalf("%s: invalid problem body %q: %q", tt.name, res.Body.String(), err)
+			}
+			if p.Code != tt.wantCode {
+				t.Fatalf("%s: want code %q but got %q", tt.name, tt.wantCode, p.Code)
+			}
+		}
+		if got := res.Header().Get("Location"); got != tt.wantLocation {
+			t.Fatalf("%s: want location %q but got %q", tt.name, tt.wantLocation, got)
+		}
+	}
+
+	if got, want := storedMovies(t, repo), []Movie{
+		{ID: 5, Title: "bhamsa", Director: "paramveer", IMDb: 8, Version: 2},
+		{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9, Version: 1},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v but want %+v", got, want)
+	}
+}
+
+func Test_movieHandler_revisions(t *testing.T) {
+	repo := newSeededRepo(t, []Movie{{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8}})
+	router := registerRoutes(NewMovieHandler(Newservice(repo)))
+
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		actor          string
+		ifMatch        string
+		wantStatusCode int
+		wantCode       string
+		wantBody       string
+	}{
+		{name: "rate", method: "PUT", target: "/api/movies/1", body: `{"id": 1, "title": "bhamsa", "director": "paramveer", "imdb": 9}`, actor: "asha", wantStatusCode: http.StatusOK},
+		{name: "list", method: "GET", target: "/api/movies/1/revisions", wantStatusCode: http.StatusOK, wantBody: `"revision":2,"change":"updated"`},
+		{name: "actor is recorded", method: "GET", target: "/api/movies/1/revisions/2", wantStatusCode: http.StatusOK, wantBody: `"actor":"asha"`},
+		{name: "anonymous otherwise", method: "GET", target: "/api/movies/1/revisions/1", wantStatusCode: http.StatusOK, wantBody: `"actor":"anonymous"`},
+		{name: "unknown revision", method: "GET", target: "/api/movies/1/revisions/5", wantStatusCode: http.StatusNotFound, wantCode: "revision_not_found"},
+		{name: "revision is not a number", method: "GET", target: "/api/movies/1/revisions/two", wantStatusCode: http.StatusBadRequest, wantCode: "invalid_path_id"},
+		{name: "unknown movie", method: "GET", target: "/api/movies/2/revisions", wantStatusCode: http.StatusNotFound, wantCode: "movie_not_found"},
+		{name: "diff", method: "GET", target: "/api/movies/1/revisions/diff", wantStatusCode: http.StatusOK, wantBody: `{"from":1,"to":2,"changes":[{"field":"imdb","from":8,"to":9}]}`},
+		{name: "diff bad query", method: "GET", target: "/api/movies/1/revisions/diff?from=first", wantStatusCode: http.StatusBadRequest, wantCode: "invalid_query"},
+		{name: "revert stale", method: "POST", target: "/api/movies/1/revisions/1/revert", ifMatch: `"1"`, wantStatusCode: http.StatusPreconditionFailed, wantCode: "version_conflict"},
+		{name: "revert", method: "POST", target: "/api/movies/1/revisions/1/revert", ifMatch: `"2"`, actor: "ravi", wantStatusCode: http.StatusOK, wantBody: `"imdb":8,"industry":"","genres":[],"version":3`},
+		{name: "revert is recorded", method: "GET", target: "/api/movies/1/revisions/3", wantStatusCode: http.StatusOK, wantBody: `"actor":"ravi"`},
+	}
+	// The steps share one repo, so they run in order and stop at the first failure.
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		if tt.actor != "" {
+			req.Header.Set(actorHeader, tt.actor)
+		}
+		if tt.ifMatch != "" {
+			req.Header.Set("If-Match", tt.ifMatch)
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		if res.Code != tt.wantStatusCode {
+			t.Fatalf("%s: want statuscode %d but got %d: %s", tt.name, tt.wantStatusCode, res.Code, res.Body.String())
+		}
+		if tt.wantBody != "" && !strings.Contains(res.Body.String(), tt.wantBody) {
+			t.Fatalf("%s: want body containing %s but got %s", tt.name, tt.wantBody, res.Body.String())
+		}
+		if tt.wantCode != "" {
+			var p problem
+			if err := json.Unmarshal(res.Body.Bytes(), &p); err != nil {
+				t.Fatalf("%s: invalid problem body %q: %q", tt.name, res.Body.String(), err)
+			}
+			if p.Code != tt.wantCode {
+				t.Fatalf("%s: want code %q but got %q", tt.name, tt.wantCode, p.Code)
+			}
+		}
+	}
+}
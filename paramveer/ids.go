@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// idStrategy labels a movie that is about to be created. Every strategy
+// takes the numeric ID from the repo's sequence, since routes address movies
+// by it; string strategies also fill in Movie.UID unless an imported movie
+// already has one.
+type idStrategy interface {
+	assign(ctx context.Context, repo Repo, movie *Movie) error
+}
+
+// parseIDStrategy maps a strategy name as given on the command line to its
+// implementation.
+func parseIDStrategy(name string) (idStrategy, error) {
+	switch name {
+	case "", "sequence":
+		return sequenceIDs{}, nil
+	case "ulid":
+		return ulidIDs{}, nil
+	case "uuid":
+		return uuidIDs{}, nil
+	default:
+		return nil, fmt.Errorf("unknown id strategy %q", name)
+	}
+}
+
+type sequenceIDs struct{}
+
+func (sequenceIDs) assign(ctx context.Context, repo Repo, movie *Movie) error {
+	id, err := repo.nextMovieID(ctx)
+	if err != nil {
+		return err
+	}
+	movie.ID = id
+	return nil
+}
+
+type ulidIDs struct{}
+
+func (ulidIDs) assign(ctx context.Context, repo Repo, movie *Movie) error {
+	if err := (sequenceIDs{}).assign(ctx, repo, movie); err != nil {
+		return err
+	}
+	if movie.UID != "" {
+		return nil
+	}
+
+	uid, err := newULID(time.Now())
+	if err != nil {
+		return err
+	}
+	movie.UID = uid
+	return nil
+}
+
+type uuidIDs struct{}
+
+func (uuidIDs) assign(ctx context.Context, repo Repo, movie *Movie) error {
+	if err := (sequenceIDs{}).assign(ctx, repo, movie); err != nil {
+		return err
+	}
+	if movie.UID != "" {
+		return nil
+	}
+
+	uid, err := newUUID()
+	if err != nil {
+		return err
+	}
+	movie.UID = uid
+	return nil
+}
+
+// crockford is the base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a 26 character ULID: 48 bits of millisecond timestamp
+// followed by 80 random bits, so IDs sort by creation time.
+func newULID(now time.Time) (string, error) {
+	var raw [16]byte
+	binary.BigEndian.PutUint64(raw[:8], uint64(now.UnixMilli())<<16)
+	if _, err := rand.Read(raw[6:]); err != nil {
+		return "", err
+	}
+
+	// 128 bits are written as 26 five-bit groups, the first holding the
+	// two leading zero bits of padding.
+	var out [26]byte
+	var acc uint32
+	bits := 2
+	pos := 0
+	for _, b := range raw {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[pos] = crockford[(acc>>bits)&0x1f]
+			pos++
+		}
+	}
+	return string(out[:]), nil
+}
+
+// newUUID returns a random (version 4) UUID in its canonical form.
+func newUUID() (string, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", err
+	}
+	raw[6] = raw[6]&0x0f | 0x40
+	raw[8] = raw[8]&0x3f | 0x80
+
+	var out [36]byte
+	hex.Encode(out[0:8], raw[0:4])
+	out[8] = '-'
+	hex.Encode(out[9:13], raw[4:6])
+	out[13] = '-'
+	hex.Encode(out[14:18], raw[6:8])
+	out[18] = '-'
+	hex.Encode(out[19:23], raw[8:10])
+	out[23] = '-'
+	hex.Encode(out[24:], raw[10:])
+	return string(out[:]), nil
+}
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	journalFile  = "movies.journal"
+	snapshotFile = "movies.snapshot"
+
+	// defaultCompactEvery is how many journal entries are written before the
+	// journal is folded into a fresh snapshot.
+	defaultCompactEvery = 1000
+)
+
+var (
+	errJournalCorrupt = errors.New("journal is corrupt")
+	errJournalFailed  = errors.New("journal could not be repaired after a failed write")
+)
+
+type journalOp string
+
+const (
+	opCreate     journalOp = "create"
+	opUpdate     journalOp = "update"
+	opTrash      journalOp = "trash"
+	opRestore    journalOp = "restore"
+	opPurge      journalOp = "purge"
+	opPurgeTrash journalOp = "purge_trash"
+
+	// opNextID was written for every ID the sequence handed out. The
+	// sequence is now rebuilt from the IDs in the create and update
+	// records, and from LastID in the snapshot.
+	opNextID journalOp = "next_id"
+
+	// opDelete was written before deleted movies went to the trash; it
+	// removes the movie for good.
+	opDelete journalOp = "delete"
+)
+
+// journalRecord is one mutation. For the ops that make a revision, Time and
+// Actor are when and by whom it was made; records written before revisions
+// were kept only have a Time for opTrash. For opPurgeTrash Time is the
+// cutoff.
+type journalRecord struct {
+	Seq   uint64     `json:"seq"`
+	Op    journalOp  `json:"op"`
+	ID    int        `json:"id"`
+	Movie *Movie     `json:"movie,omitempty"`
+	Time  *time.Time `json:"time,omitempty"`
+	Actor string     `json:"actor,omitempty"`
+}
+
+// stamp is the revision stamp rec was written with.
+func (rec journalRecord) stamp() revisionStamp {
+	var stamp revisionStamp
+	if rec.Time != nil {
+		stamp.Time = *rec.Time
+	}
+	stamp.Actor = rec.Actor
+	return stamp
+}
+
+type journalSnapshot struct {
+	Seq       uint64             `json:"seq"`
+	LastID    int                `json:"last_id"`
+	Movies    []Movie            `json:"movies"`
+	Revisions map[int][]revision `json:"revisions,omitempty"`
+}
+
+// JournalRepo is an InMemoryRepo whose mutations are written to an fsync'd
+// append-only journal before they are applied. Cancellation is honoured up
+// to the journal write; once a record is on disk it is always applied. On
+// start the latest snapshot is loaded and the journal replayed on top of
+// it; every compactEvery entries the state is snapshotted again and the
+// journal truncated.
+//
+// Each journal line is "<crc32> <json record>\n". A torn final line left by
+// a crash is dropped on open; damage anywhere else is reported as
+// errJournalCorrupt. A write that fails is cut back off the journal; if that
+// fails too, every later mutation is refused with errJournalFailed.
+type JournalRepo struct {
+	*InMemoryRepo
+
+	mu           sync.Mutex
+	dir          string
+	journal      *os.File
+	seq          uint64
+	entries      int
+	compactEvery int
+	recovered    int
+	failed       error
+}
+
+// NewJournalRepo opens the journal kept in dir, creating the directory if
+// needed, and rebuilds the in-memory state from it. compactEvery <= 0 uses
+// defaultCompactEvery.
+func NewJournalRepo(dir string, compactEvery int) (*JournalRepo, error) {
+	if compactEvery <= 0 {
+		compactEvery = defaultCompactEvery
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create journal dir: %w", err)
+	}
+
+	j := &JournalRepo{
+		InMemoryRepo: NewInMemoryRepo(),
+		dir:          dir,
+		compactEvery: compactEvery,
+	}
+
+	if err := j.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	if err := j.replay(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	j.journal = f
+
+	return j, nil
+}
+
+// Recovered reports how many entries were replayed when the repo was
+// opened: movies loaded from the snapshot plus journal records applied on
+// top of it.
+func (j *JournalRepo) Recovered() int {
+	return j.recovered
+}
+
+// ping checks that the journal is still open for appending.
+func (j *JournalRepo) ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.failed != nil {
+		return j.failed
+	}
+	if _, err := j.journal.Stat(); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	return nil
+}
+
+func (j *JournalRepo) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.journal.Close()
+}
+
+func (j *JournalRepo) createMovie(ctx context.Context, newmovie Movie) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, ok := j.InMemoryRepo.stored(newmovie.ID); ok {
+		return errConflict
+	}
+
+	stamp := j.InMemoryRepo.stamp(ctx)
+	if err := j.append(journalRecord{Op: opCreate, ID: newmovie.ID, Movie: &newmovie, Time: &stamp.Time, Actor: stamp.Actor}); err != nil {
+		return err
+	}
+	if err := j.InMemoryRepo.create(newmovie, stamp); err != nil {
+		return err
+	}
+
+	j.compactIfDue()
+	return nil
+}
+
+func (j *JournalRepo) updateMovie(ctx context.Context, id int, newmovie Movie) (Movie, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return Movie{}, err
+	}
+	existingmovie, err := j.InMemoryRepo.getMovieById(ctx, id)
+	if err != nil {
+		return Movie{}, err
+	}
+	if newmovie.Version != 0 && newmovie.Version != existingmovie.Version {
+		return Movie{}, errVersionConflict
+	}
+	if newmovie.ID != id {
+		if _, ok := j.InMemoryRepo.stored(newmovie.ID); ok {
+			return Movie{}, errConflict
+		}
+	}
+	newmovie.UID = existingmovie.UID
+
+	stamp := j.InMemoryRepo.stamp(ctx)
+	if err := j.append(journalRecord{Op: opUpdate, ID: id, Movie: &newmovie, Time: &stamp.Time, Actor: stamp.Actor}); err != nil {
+		return Movie{}, err
+	}
+	movie, err := j.InMemoryRepo.update(id, newmovie, stamp)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	j.compactIfDue()
+	return movie, nil
+}
+
+func (j *JournalRepo) deleteMovie(ctx context.Context, id int, version int) (Movie, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return Movie{}, err
+	}
+	existingmovie, err := j.InMemoryRepo.getMovieById(ctx, id)
+	if err != nil {
+		return Movie{}, err
+	}
+	if version != 0 && version != existingmovie.Version {
+		return Movie{}, errVersionConflict
+	}
+
+	stamp := j.InMemoryRepo.stamp(ctx)
+	if err := j.append(journalRecord{Op: opTrash, ID: id, Time: &stamp.Time, Actor: stamp.Actor}); err != nil {
+		return Movie{}, err
+	}
+	movie, err := j.InMemoryRepo.trash(id, 0, stamp)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	j.compactIfDue()
+	return movie, nil
+}
+
+func (j *JournalRepo) restoreMovie(ctx context.Context, id int, version int) (Movie, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return Movie{}, err
+	}
+	existingmovie, ok := j.InMemoryRepo.stored(id)
+	if !ok || existingmovie.DeletedAt == nil {
+		return Movie{}, errNotFound
+	}
+	if version != 0 && version != existingmovie.Version {
+		return Movie{}, errVersionConflict
+	}
+
+	stamp := j.InMemoryRepo.stamp(ctx)
+	if err := j.append(journalRecord{Op: opRestore, ID: id, Time: &stamp.Time, Actor: stamp.Actor}); err != nil {
+		return Movie{}, err
+	}
+	movie, err := j.InMemoryRepo.restore(id, 0, stamp)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	j.compactIfDue()
+	return movie, nil
+}
+
+func (j *JournalRepo) purgeMovie(ctx context.Context, id int) (Movie, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return Movie{}, err
+	}
+	if existingmovie, ok := j.InMemoryRepo.stored(id); !ok || existingmovie.DeletedAt == nil {
+		return Movie{}, errNotFound
+	}
+
+	if err := j.append(journalRecord{Op: opPurge, ID: id}); err != nil {
+		return Movie{}, err
+	}
+	movie, err := j.InMemoryRepo.purgeMovie(context.Background(), id)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	j.compactIfDue()
+	return movie, nil
+}
+
+func (j *JournalRepo) purgeTrash(ctx context.Context, before time.Time) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	trash, err := j.InMemoryRepo.listTrash(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// Nothing to purge is the common case for the retention job; it is not
+	// worth a journal entry.
+	due := false
+	for _, movie := range trash {
+		due = due || movie.DeletedAt.Before(before)
+	}
+	if !due {
+		return 0, nil
+	}
+
+	before = before.UTC()
+	if err := j.append(journalRecord{Op: opPurgeTrash, Time: &before}); err != nil {
+		return 0, err
+	}
+	purged, err := j.InMemoryRepo.purgeTrash(context.Background(), before)
+	if err != nil {
+		return 0, err
+	}
+
+	j.compactIfDue()
+	return purged, nil
+}
+
+// Snapshot writes the current state to disk and truncates the journal.
+func (j *JournalRepo) Snapshot() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.snapshot()
+}
+
+// append writes rec to the journal and syncs it. The caller must hold j.mu
+// and have checked that rec will apply cleanly. If the record cannot be
+// written in full and synced, whatever part of it was written is cut off
+// again, so that neither a broken line nor a record that was never applied
+// is left for the next replay.
+func (j *JournalRepo) append(rec journalRecord) error {
+	if j.failed != nil {
+		return j.failed
+	}
+	rec.Seq = j.seq + 1
+
+	line, err := encodeJournalRecord(rec)
+	if err != nil {
+		return err
+	}
+	offset, err := j.journal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if _, err := j.journal.Write(line); err != nil {
+		j.rollback(offset)
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := j.journal.Sync(); err != nil {
+		j.rollback(offset)
+		return fmt.Errorf("sync journal: %w", err)
+	}
+
+	j.seq = rec.Seq
+	j.entries++
+	return nil
+}
+
+// rollback cuts the journal back to offset after a failed append. If it
+// cannot, the journal may end in a broken line or in a record that was never
+// applied, so the repo refuses any further writes.
+func (j *JournalRepo) rollback(offset int64) {
+	err := j.journal.Truncate(offset)
+	if err == nil {
+		_, err = j.journal.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		err = j.journal.Sync()
+	}
+	if err != nil {
+		log.Println("journal rollback failed:", err)
+		j.failed = fmt.Errorf("%w: %v", errJournalFailed, err)
+	}
+}
+
+// compactIfDue snapshots the state once enough entries have accumulated. A
+// failed compaction only means a longer replay, so it is logged rather than
+// failing the mutation that triggered it, and retried after another
+// compactEvery entries.
+func (j *JournalRepo) compactIfDue() {
+	if j.entries < j.compactEvery {
+		return
+	}
+	if err := j.snapshot(); err != nil {
+		log.Println("journal compaction failed:", err)
+		j.entries = 0
+	}
+}
+
+func (j *JournalRepo) snapshot() error {
+	j.InMemoryRepo.mu.RLock()
+	lastID := j.InMemoryRepo.lastID
+	movies := make([]Movie, 0, len(j.InMemoryRepo.order))
+	revisions := make(map[int][]revision, len(j.InMemoryRepo.history))
+	for _, id := range j.InMemoryRepo.order {
+		movies = append(movies, j.InMemoryRepo.movies[id])
+		if history := j.InMemoryRepo.history[id]; len(history) > 0 {
+			revisions[id] = history
+		}
+	}
+	j.InMemoryRepo.mu.RUnlock()
+
+	data, err := json.Marshal(journalSnapshot{Seq: j.seq, LastID: lastID, Movies: movies, Revisions: revisions})
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(filepath.Join(j.dir, snapshotFile), data); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	if err := j.journal.Truncate(0); err != nil {
+		return fmt.Errorf("truncate journal: %w", err)
+	}
+	if _, err := j.journal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind journal: %w", err)
+	}
+	if err := j.journal.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %w", err)
+	}
+
+	j.entries = 0
+	return nil
+}
+
+func (j *JournalRepo) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(j.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var snap journalSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("%w: snapshot: %v", errJournalCorrupt, err)
+	}
+
+	for _, movie := range snap.Movies {
+		if err := j.InMemoryRepo.loadMovie(movie, snap.Revisions[movie.ID]); err != nil {
+			return fmt.Errorf("%w: snapshot movie %d: %v", errJournalCorrupt, movie.ID, err)
+		}
+	}
+	j.InMemoryRepo.lastID = max(j.InMemoryRepo.lastID, snap.LastID)
+	j.seq = snap.Seq
+	j.recovered = len(snap.Movies)
+	return nil
+}
+
+// replay applies every journal record newer than the snapshot, truncates a
+// torn tail and leaves f positioned for appending.
+func (j *JournalRepo) replay(f *os.File) error {
+	r := bufio.NewReader(f)
+
+	var good int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				// The last write never finished; drop it.
+				if err := f.Truncate(good); err != nil {
+					return fmt.Errorf("truncate torn journal record: %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read journal: %w", err)
+		}
+
+		rec, err := decodeJournalRecord(line)
+		if err != nil {
+			if _, peekErr := r.Peek(1); errors.Is(peekErr, io.EOF) {
+				if err := f.Truncate(good); err != nil {
+					return fmt.Errorf("truncate torn journal record: %w", err)
+				}
+				break
+			}
+			return fmt.Errorf("%w: offset %d: %v", errJournalCorrupt, good, err)
+		}
+		good += int64(len(line))
+
+		if rec.Seq <= j.seq {
+			// Already part of the snapshot.
+			continue
+		}
+		if err := j.apply(rec); err != nil {
+			return fmt.Errorf("%w: record %d: %v", errJournalCorrupt, rec.Seq, err)
+		}
+		j.seq = rec.Seq
+		j.entries++
+		j.recovered++
+	}
+
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		return fmt.Errorf("seek journal: %w", err)
+	}
+	return nil
+}
+
+func (j *JournalRepo) apply(rec journalRecord) error {
+	switch rec.Op {
+	case opCreate:
+		if rec.Movie == nil {
+			return errors.New("create without movie")
+		}
+		return j.InMemoryRepo.create(*rec.Movie, rec.stamp())
+	case opUpdate:
+		if rec.Movie == nil {
+			return errors.New("update without movie")
+		}
+		// The version check passed when the record was written; replaying
+		// it unconditionally yields the same version again.
+		movie := *rec.Movie
+		movie.Version = 0
+		_, err := j.InMemoryRepo.update(rec.ID, movie, rec.stamp())
+		return err
+	case opTrash:
+		if rec.Time == nil {
+			return errors.New("trash without time")
+		}
+		_, err := j.InMemoryRepo.trash(rec.ID, 0, rec.stamp())
+		return err
+	case opRestore:
+		_, err := j.InMemoryRepo.restore(rec.ID, 0, rec.stamp())
+		return err
+	case opPurge:
+		_, err := j.InMemoryRepo.purgeMovie(context.Background(), rec.ID)
+		return err
+	case opPurgeTrash:
+		if rec.Time == nil {
+			return errors.New("purge without cutoff")
+		}
+		_, err := j.InMemoryRepo.purgeTrash(context.Background(), *rec.Time)
+		return err
+	case opNextID:
+		j.InMemoryRepo.reserveID(rec.ID)
+		return nil
+	case opDelete:
+		if _, err := j.InMemoryRepo.trash(rec.ID, 0, rec.stamp()); err != nil {
+			return err
+		}
+		_, err := j.InMemoryRepo.purgeMovie(context.Background(), rec.ID)
+		return err
+	default:
+		return fmt.Errorf("unknown op %q", rec.Op)
+	}
+}
+
+func encodeJournalRecord(rec journalRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	line := make([]byte, 0, len(payload)+10)
+	line = strconv.AppendUint(line, uint64(crc32.ChecksumIEEE(payload)), 16)
+	line = append(line, ' ')
+	line = append(line, payload...)
+	return append(line, '\n'), nil
+}
+
+func decodeJournalRecord(line []byte) (journalRecord, error) {
+	var rec journalRecord
+
+	sum, payload, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !ok {
+		return rec, errors.New("missing checksum")
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil {
+		return rec, fmt.Errorf("bad checksum: %v", err)
+	}
+	if uint64(crc32.ChecksumIEEE(payload)) != want {
+		return rec, errors.New("checksum mismatch")
+	}
+
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// writeFileSync replaces path with data atomically: the bytes go to a
+// temporary file that is synced and renamed over path, then the directory
+// is synced so the rename survives a crash.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openTestJournal(t *testing.T, dir string, compactEvery int) *JournalRepo {
+	t.Helper()
+
+	repo, err := NewJournalRepo(dir, compactEvery)
+	if err != nil {
+		t.Fatalf("failed to open journal: %q", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestJournalRepo_replay(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestJournal(t, dir, 0)
+	if err := repo.createMovie(context.Background(), Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := repo.createMovie(context.Background(), Movie{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := repo.updateMovie(context.Background(), 1, Movie{ID: 1, Title: "paramveer", Director: "bhamsa", IMDb: 7}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := repo.deleteMovie(context.Background(), 2, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := repo.createMovie(context.Background(), Movie{ID: 1}); !errors.Is(err, errConflict) {
+		t.Errorf("want error %q but got %q", errConflict, err)
+	}
+	repo.Close()
+
+	reopened := openTestJournal(t, dir, 0)
+	if got := reopened.Recovered(); got != 4 {
+		t.Errorf("recovered %d entries but want 4", got)
+	}
+
+	got, _ := reopened.getAllMovie(context.Background())
+	want := []Movie{{ID: 1, Title: "paramveer", Director: "bhamsa", IMDb: 7, Version: 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v but want %+v", got, want)
+	}
+}
+
+func TestJournalRepo_replayTrash(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestJournal(t, dir, 0)
+	for _, movie := range []Movie{
+		{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8},
+		{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9},
+		{ID: 3, Title: "singh", Director: "paramveer", IMDb: 7},
+	} {
+		if err := repo.createMovie(context.Background(), movie); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	for _, id := range []int{1, 2, 3} {
+		if _, err := repo.deleteMovie(context.Background(), id, 0); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	if _, err := repo.restoreMovie(context.Background(), 1, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := repo.purgeMovie(context.Background(), 2); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	trash, _ := repo.listTrash(context.Background())
+	repo.Close()
+
+	reopened := openTestJournal(t, dir, 0)
+	got, _ := reopened.getAllMovie(context.Background())
+	want := []Movie{{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Version: 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v but want %+v", got, want)
+	}
+	// The trash survives with the time each movie was deleted.
+	if got, _ := reopened.listTrash(context.Background()); !reflect.DeepEqual(got, trash) {
+		t.Errorf("got trash %+v but want %+v", got, trash)
+	}
+
+	if n, err := reopened.purgeTrash(context.Background(), time.Now()); err != nil || n != 1 {
+		t.Fatalf("got %d, %v but want 1 movie purged", n, err)
+	}
+	reopened.Close()
+
+	again := openTestJournal(t, dir, 0)
+	if got, _ := again.listTrash(context.Background()); len(got) != 0 {
+		t.Errorf("got trash %+v but want it empty", got)
+	}
+}
+
+// TestJournalRepo_replayLegacyMovies checks that journals written before
+// movies had an industry still replay, with the industry taken from the
+// hollywood and bollywood flags.
+func TestJournalRepo_replayLegacyMovies(t *testing.T) {
+	dir := t.TempDir()
+
+	var journal []byte
+	for i, payload := range []string{
+		`{"seq":1,"op":"create","id":1,"movie":{"id":1,"title":"bhamsa","director":"paramveer","imdb":8,"hollywood":"no","bollywood":"yes"}}`,
+		`{"seq":2,"op":"update","id":1,"movie":{"id":1,"title":"bhamsa","director":"paramveer","imdb":8,"hollywood":"yes","bollywood":"no","version":1}}`,
+	} {
+		var rec journalRecord
+		if err := json.Unmarshal([]byte(payload), &rec); err != nil {
+			t.Fatalf("record %d: %q", i, err)
+		}
+		line, err := encodeJournalRecord(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		journal = append(journal, line...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, journalFile), journal, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := openTestJournal(t, dir, 0)
+	got, _ := repo.getAllMovie(context.Background())
+	want := []Movie{{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryHollywood, Version: 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v but want %+v", got, want)
+	}
+
+	revisions, _ := repo.listRevisions(context.Background(), 1)
+	if len(revisions) != 2 || revisions[0].Movie.Industry != IndustryBollywood {
+		t.Errorf("got revisions %+v but want the first one from bollywood", revisions)
+	}
+}
+
+func TestJournalRepo_idSequence(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	repo := openTestJournal(t, dir, 0)
+	id, err := repo.nextMovieID(ctx)
+	if err != nil || id != 1 {
+		t.Fatalf("got id %d, %v but want 1", id, err)
+	}
+	if err := repo.createMovie(ctx, Movie{ID: id, Title: "bhamsa", Director: "paramveer", IMDb: 8}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := repo.createMovie(ctx, Movie{ID: 10, Title: "hardik", Director: "sharma", IMDb: 9}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := repo.deleteMovie(ctx, 10, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := repo.purgeMovie(ctx, 10); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	repo.Close()
+
+	// Handing out an ID is not journaled; the creates carry it.
+	data, err := os.ReadFile(filepath.Join(dir, journalFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(opNextID)) {
+		t.Errorf("got a %s record in\n%s", opNextID, data)
+	}
+
+	reopened := openTestJournal(t, dir, 0)
+	if id, err := reopened.nextMovieID(ctx); err != nil || id != 11 {
+		t.Errorf("got id %d, %v but want 11", id, err)
+	}
+	if err := reopened.Snapshot(); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	reopened.Close()
+
+	again := openTestJournal(t, dir, 0)
+	if id, err := again.nextMovieID(ctx); err != nil || id != 12 {
+		t.Errorf("got id %d after a snapshot, %v but want 12", id, err)
+	}
+}
+
+func TestJournalRepo_replayNextID(t *testing.T) {
+	dir := t.TempDir()
+
+	line, err := encodeJournalRecord(journalRecord{Seq: 1, Op: opNextID, ID: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, journalFile), line, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := openTestJournal(t, dir, 0)
+	if id, err := repo.nextMovieID(context.Background()); err != nil || id != 6 {
+		t.Errorf("got id %d, %v but want 6", id, err)
+	}
+}
+
+func TestJournalRepo_tornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestJournal(t, dir, 0)
+	if err := repo.createMovie(context.Background(), Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	repo.Close()
+
+	path := filepath.Join(dir, journalFile)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`1234abcd {"seq":2,"op":"create","id":2,"movie":{"id":2,"ti`)
+	f.Close()
+
+	reopened := openTestJournal(t, dir, 0)
+	if got := reopened.Recovered(); got != 1 {
+		t.Errorf("recovered %d entries but want 1", got)
+	}
+
+	// The torn record is gone, so new writes land on a clean line.
+	if err := reopened.createMovie(context.Background(), Movie{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	reopened.Close()
+
+	again := openTestJournal(t, dir, 0)
+	if got := again.Recovered(); got != 2 {
+		t.Errorf("recovered %d entries but want 2", got)
+	}
+}
+
+func TestJournalRepo_corruptMiddle(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestJournal(t, dir, 0)
+	for id := 1; id <= 3; id++ {
+		if err := repo.createMovie(context.Background(), Movie{ID: id, Title: "bhamsa", Director: "paramveer", IMDb: 8}); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	repo.Close()
+
+	path := filepath.Join(dir, journalFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewJournalRepo(dir, 0); !errors.Is(err, errJournalCorrupt) {
+		t.Errorf("want error %q but got %q", errJournalCorrupt, err)
+	}
+}
+
+func TestJournalRepo_failedWrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		breakFile func(t *testing.T, repo *JournalRepo)
+		// wantFailed is whether the journal could not be cut back, so
+		// that later writes are refused too.
+		wantFailed bool
+	}{
+		{
+			name: "closed file",
+			breakFile: func(t *testing.T, repo *JournalRepo) {
+				repo.journal.Close()
+			},
+		},
+		{
+			name: "read-only file",
+			breakFile: func(t *testing.T, repo *JournalRepo) {
+				f, err := os.Open(filepath.Join(repo.dir, journalFile))
+				if err != nil {
+					t.Fatal(err)
+				}
+				repo.journal.Close()
+				repo.journal = f
+			},
+			wantFailed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			bhamsa := Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8}
+
+			repo := openTestJournal(t, dir, 0)
+			if err := repo.createMovie(context.Background(), bhamsa); err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+			tt.breakFile(t, repo)
+
+			if err := repo.createMovie(context.Background(), Movie{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9}); err == nil {
+				t.Fatal("creating a movie succeeded without a journal")
+			}
+			if _, err := repo.getMovieById(context.Background(), 2); !errors.Is(err, errNotFound) {
+				t.Errorf("got %q but want the failed create not applied", err)
+			}
+			if tt.wantFailed {
+				if _, err := repo.deleteMovie(context.Background(), 1, 0); !errors.Is(err, errJournalFailed) {
+					t.Errorf("want error %q but got %q", errJournalFailed, err)
+				}
+				if err := repo.ping(context.Background()); !errors.Is(err, errJournalFailed) {
+					t.Errorf("want ping error %q but got %q", errJournalFailed, err)
+				}
+			}
+
+			reopened := openTestJournal(t, dir, 0)
+			bhamsa.Version = 1
+			if got, err := reopened.getAllMovie(context.Background()); err != nil || !reflect.DeepEqual(got, []Movie{bhamsa}) {
+				t.Errorf("got %+v, %v but want only %+v", got, err, bhamsa)
+			}
+		})
+	}
+}
+
+func TestJournalRepo_compaction(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := openTestJournal(t, dir, 2)
+	for id := 1; id <= 5; id++ {
+		if err := repo.createMovie(context.Background(), Movie{ID: id, Title: "bhamsa", Director: "paramveer", IMDb: 8}); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+	}
+	if _, err := repo.updateMovie(context.Background(), 1, Movie{ID: 1, Title: "singh", Director: "paramveer", IMDb: 8}); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	repo.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+		t.Fatalf("snapshot was not written: %q", err)
+	}
+
+	reopened := openTestJournal(t, dir, 2)
+	movies, _ := reopened.getAllMovie(context.Background())
+	if len(movies) != 5 {
+		t.Errorf("got %d movies but want 5", len(movies))
+	}
+	// Snapshots keep versions rather than restarting them at 1.
+	if got, _ := reopened.getMovieById(context.Background(), 1); got.Version != 2 {
+		t.Errorf("got version %d but want 2", got.Version)
+	}
+	if got := reopened.Recovered(); got != 5 {
+		t.Errorf("recovered %d entries but want 5", got)
+	}
+}
+
+func TestJournalRepo_revisions(t *testing.T) {
+	for _, compactEvery := range []int{100, 2} {
+		dir := t.TempDir()
+		ctx := context.WithValue(context.Background(), actorKey{}, "asha")
+
+		repo := openTestJournal(t, dir, compactEvery)
+		if err := repo.createMovie(ctx, Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8}); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if _, err := repo.updateMovie(ctx, 1, Movie{ID: 2, Title: "bhamsa", Director: "paramveer", IMDb: 9}); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if _, err := repo.deleteMovie(context.Background(), 2, 0); err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		want, _ := repo.listRevisions(context.Background(), 2)
+		repo.Close()
+
+		// Replayed or loaded from a snapshot, the history keeps the times
+		// and actors it was written with.
+		reopened := openTestJournal(t, dir, compactEvery)
+		got, err := reopened.listRevisions(context.Background(), 2)
+		if err != nil || len(got) != 3 || !reflect.DeepEqual(got, want) {
+			t.Errorf("compactEvery %d: got %+v, %v but want %+v", compactEvery, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// logLevel orders log messages by severity. Failures are always logged
+// through the log package directly; logf is for messages an operator may
+// want to silence.
+type logLevel int32
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func parseLogLevel(name string) (logLevel, error) {
+	for i, known := range logLevelNames {
+		if known == name {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// minLogLevel is the least severe level logf writes.
+var minLogLevel atomic.Int32
+
+func init() {
+	minLogLevel.Store(int32(levelInfo))
+}
+
+func setLogLevel(level logLevel) {
+	minLogLevel.Store(int32(level))
+}
+
+// logf logs a message at level, if that level is enabled.
+func logf(level logLevel, format string, args ...any) {
+	if int32(level) < minLogLevel.Load() {
+		return
+	}
+	log.Printf(logLevelNames[level]+": "+format, args...)
+}
+
+// withRequestLog logs every request at debug level as it comes in.
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logf(levelDebug, "request %s: %s %s from %s", requestIDFrom(r.Context()), r.Method, r.URL.RequestURI(), r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
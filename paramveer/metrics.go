@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// metricsContentType is version 0.0.4 of the Prometheus text exposition
+// format, which is what /metrics writes.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram buckets.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// unmatchedRoute labels requests no route matched, so that scanners trying
+// random paths cannot blow up the number of series.
+const unmatchedRoute = "unmatched"
+
+// counterVec is a family of counters told apart by label values.
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]uint64{}}
+}
+
+// inc adds one to the counter with the given label values, in the order
+// of c.labels.
+func (c *counterVec) inc(values ...string) {
+	key := labelString(c.labels, values)
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, key, c.values[key])
+	}
+}
+
+// histogramVec is a family of histograms told apart by label values.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// histogram counts observations per bucket; counts[i] holds those up to
+// buckets[i] that are above buckets[i-1], and the last entry the rest.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := labelString(h.labels, values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	i, _ := slices.BinarySearch(h.buckets, v)
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		// The le label goes after the others, inside the same braces.
+		prefix := strings.TrimSuffix(key, "}")
+		if prefix == "" {
+			prefix = "{"
+		} else {
+			prefix += ","
+		}
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%sle=%q} %d\n", h.name, prefix, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%sle=\"+Inf\"} %d\n", h.name, prefix, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+// gaugeFunc is a gauge whose value is read when metrics are scraped.
+type gaugeFunc struct {
+	name, help string
+	value      func(ctx context.Context) (float64, error)
+}
+
+func (g gaugeFunc) write(ctx context.Context, w io.Writer) {
+	v, err := g.value(ctx)
+	if err != nil {
+		// A missing sample is how Prometheus expects an unknown value.
+		log.Printf("metric %s: %v", g.name, err)
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(v))
+}
+
+// labelString renders label pairs as they appear in a sample, e.g.
+// {method="GET",status="200"}. It doubles as the key of a series.
+func labelString(names, values []string) string {
+	if len(names) != len(values) {
+		panic(fmt.Sprintf("got %d label values for %d labels", len(values), len(names)))
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// metrics holds what /metrics reports: HTTP traffic per route, repo
+// operations, and gauges read from the repo on every scrape.
+type metrics struct {
+	requests        *counterVec
+	requestDuration *histogramVec
+	inFlight        atomic.Int64
+
+	repoOperations *counterVec
+
+	gauges []gaugeFunc
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: newCounterVec("paramveer_http_requests_total",
+			"HTTP requests answered, by route, method and status code.",
+			"route", "method", "status"),
+		requestDuration: newHistogramVec("paramveer_http_request_duration_seconds",
+			"Time taken to answer HTTP requests, by route and method.",
+			latencyBuckets, "route", "method"),
+		repoOperations: newCounterVec("paramveer_repo_operations_total",
+			"Repository operations, by operation and whether they succeeded.",
+			"operation", "result"),
+	}
+}
+
+// watchRepo adds gauges for the number of live and trashed movies in repo.
+func (m *metrics) watchRepo(repo Repo) {
+	m.gauges = append(m.gauges,
+		gaugeFunc{
+			name: "paramveer_movies",
+			help: "Movies in the catalog, not counting the trash.",
+			value: func(ctx context.Context) (float64, error) {
+				page, err := repo.listMovies(ctx, movieQuery{Limit: 1})
+				return float64(page.Total), err
+			},
+		},
+		gaugeFunc{
+			name: "paramveer_movies_trashed",
+			help: "Movies in the trash.",
+			value: func(ctx context.Context) (float64, error) {
+				n, err := repo.countTrash(ctx)
+				return float64(n), err
+			},
+		},
+	)
+}
+
+func (m *metrics) write(ctx context.Context, w io.Writer) {
+	m.requests.write(w)
+	m.requestDuration.write(w)
+	fmt.Fprintf(w, "# HELP %[1]s HTTP requests being answered.\n# TYPE %[1]s gauge\n%[1]s %d\n",
+		"paramveer_http_requests_in_flight", m.inFlight.Load())
+	m.repoOperations.write(w)
+	for _, g := range m.gauges {
+		g.write(ctx, w)
+	}
+}
+
+// routeLabelKey carries the route label instrument reads back once the
+// router has served the request.
+type routeLabelKey struct{}
+
+// labelRoute fills in the route label with the template of the route mux
+// matched. As router middleware it only runs once a route has matched, so
+// the router does not have to match every request a second time.
+func labelRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if label, ok := r.Context().Value(routeLabelKey{}).(*string); ok {
+			if tmpl, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+				*label = tmpl
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// instrument counts and times every request router serves, labelled with
+// the template of the route it matched rather than the raw path.
+func (m *metrics) instrument(router *mux.Router) http.Handler {
+	router.Use(labelRoute)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := unmatchedRoute
+		r = r.WithContext(context.WithValue(r.Context(), routeLabelKey{}, &route))
+
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		router.ServeHTTP(rec, r)
+
+		m.requestDuration.observe(time.Since(start).Seconds(), route, r.Method)
+		m.requests.inc(route, r.Method, strconv.Itoa(rec.statusCode()))
+	})
+}
+
+// statusRecorder remembers the status code written through it. It passes
+// Flush and Hijack through, so streaming and WebSocket handlers keep
+// working behind it, and unwraps for http.ResponseController.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *statusRecorder) Flush() {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer cannot be hijacked")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && rec.status == 0 {
+		// The handler answers on the raw connection, with an upgrade.
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *statusRecorder) statusCode() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// metricsRepo counts the operations done on the Repo it wraps. ping and
+// Close are not counted.
+type metricsRepo struct {
+	Repo
+	ops *counterVec
+}
+
+func newMetricsRepo(repo Repo, m *metrics) *metricsRepo {
+	return &metricsRepo{Repo: repo, ops: m.repoOperations}
+}
+
+func (r *metricsRepo) count(op string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	r.ops.inc(op, result)
+}
+
+func (r *metricsRepo) createMovie(ctx context.Context, newmovie Movie) error {
+	err := r.Repo.createMovie(ctx, newmovie)
+	r.count("create", err)
+	return err
+}
+
+func (r *metricsRepo) getAllMovie(ctx context.Context) ([]Movie, error) {
+	movies, err := r.Repo.getAllMovie(ctx)
+	r.count("get_all", err)
+	return movies, err
+}
+
+func (r *metricsRepo) listMovies(ctx context.Context, q movieQuery) (moviePage, error) {
+	page, err := r.Repo.listMovies(ctx, q)
+	r.count("list", err)
+	return page, err
+}
+
+func (r *metricsRepo) movieStats(ctx context.Context, q statsQuery) ([]statsGroup, error) {
+	groups, err := r.Repo.movieStats(ctx, q)
+	r.count("stats", err)
+	return groups, err
+}
+
+func (r *metricsRepo) getMovieById(ctx context.Context, id int) (Movie, error) {
+	movie, err := r.Repo.getMovieById(ctx, id)
+	r.count("get", err)
+	return movie, err
+}
+
+func (r *metricsRepo) updateMovie(ctx context.Context, id int, newmovie Movie) (Movie, error) {
+	movie, err := r.Repo.updateMovie(ctx, id, newmovie)
+	r.count("update", err)
+	return movie, err
+}
+
+func (r *metricsRepo) deleteMovie(ctx context.Context, id int, version int) (Movie, error) {
+	movie, err := r.Repo.deleteMovie(ctx, id, version)
+	r.count("delete", err)
+	return movie, err
+}
+
+func (r *metricsRepo) listTrash(ctx context.Context) ([]Movie, error) {
+	movies, err := r.Repo.listTrash(ctx)
+	r.count("list_trash", err)
+	return movies, err
+}
+
+func (r *metricsRepo) countTrash(ctx context.Context) (int, error) {
+	n, err := r.Repo.countTrash(ctx)
+	r.count("count_trash", err)
+	return n, err
+}
+
+func (r *metricsRepo) restoreMovie(ctx context.Context, id int, version int) (Movie, error) {
+	movie, err := r.Repo.restoreMovie(ctx, id, version)
+	r.count("restore", err)
+	return movie, err
+}
+
+func (r *metricsRepo) purgeMovie(ctx context.Context, id int) (Movie, error) {
+	movie, err := r.Repo.purgeMovie(ctx, id)
+	r.count("purge", err)
+	return movie, err
+}
+
+func (r *metricsRepo) purgeTrash(ctx context.Context, before time.Time) (int, error) {
+	purged, err := r.Repo.purgeTrash(ctx, before)
+	r.count("purge_trash", err)
+	return purged, err
+}
+
+func (r *metricsRepo) listRevisions(ctx context.Context, id int) ([]revision, error) {
+	revisions, err := r.Repo.listRevisions(ctx, id)
+	r.count("list_revisions", err)
+	return revisions, err
+}
+
+func (r *metricsRepo) nextMovieID(ctx context.Context) (int, error) {
+	id, err := r.Repo.nextMovieID(ctx)
+	r.count("next_id", err)
+	return id, err
+}
+
+// withMetrics serves m on /metrics.
+func withMetrics(m *metrics) handlerOption {
+	return func(h *movieHandler) {
+		h.metrics = m
+	}
+}
+
+func (h *movieHandler) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.metrics == nil {
+		resolveError(w, r, errNoRoute)
+		return
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	h.metrics.write(r.Context(), w)
+}
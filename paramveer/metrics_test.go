@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func Test_histogramVec_write(t *testing.T) {
+	h := newHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.observe(v, `/a"b`)
+	}
+
+	var out bytes.Buffer
+	h.write(&out)
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a\"b",le="0.1"} 2
+latency_seconds_bucket{route="/a\"b",le="1"} 3
+latency_seconds_bucket{route="/a\"b",le="+Inf"} 4
+latency_seconds_sum{route="/a\"b"} 3.65
+latency_seconds_count{route="/a\"b"} 4
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nbut want\n%s", out.String(), want)
+	}
+}
+
+func Test_metrics(t *testing.T) {
+	m := newMetrics()
+	repo := NewInMemoryRepo()
+	m.watchRepo(repo)
+	events := newEventLog(0)
+	serv := Newservice(newMetricsRepo(repo, m), withEventLog(events))
+	router := registerRoutes(NewMovieHandler(serv, withChangeFeed(events), withMetrics(m)))
+	server := httptest.NewServer(m.instrument(router))
+	defer server.Close()
+
+	do := func(method, path, body string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %q", method, path, err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+	do("POST", "/api/movies", `{"title":"bhamsa","director":"paramveer","imdb":8}`)
+	do("POST", "/api/movies", `{"title":"hardik","director":"sharma","imdb":9}`)
+	do("DELETE", "/api/movies/2", "")
+	do("GET", "/api/movies/7", "")
+	do("GET", "/wp-login.php", "")
+
+	// The recorder has to pass hijacking through for WebSockets ...
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/movies/stream", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %q", err)
+	}
+	conn.Close()
+
+	// ... and flushing for Server-Sent Events.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/movies/events", nil)
+	req.Header.Set("Last-Event-ID", events.token(0))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	first := make([]byte, 4)
+	if _, err := io.ReadFull(res.Body, first); err != nil || string(first) != "id: " {
+		t.Errorf("got %q, %v but want the first event flushed", first, err)
+	}
+	res.Body.Close()
+
+	scrape := func() string {
+		t.Helper()
+		res, err := http.Get(server.URL + "/metrics")
+		if err != nil {
+			t.Fatalf("unexpected error %q", err)
+		}
+		defer res.Body.Close()
+		if got := res.Header.Get("Content-Type"); got != metricsContentType {
+			t.Errorf("got content type %q", got)
+		}
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+	// The WebSocket request is only counted once its handler has returned.
+	var body string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if body = scrape(); strings.Contains(body, `status="101"`) {
+			break
+		}
+	}
+
+	for _, want := range []string{
+		`paramveer_http_requests_total{route="/api/movies",method="POST",status="201"} 2`,
+		`paramveer_http_requests_total{route="/api/movies/{id}",method="DELETE",status="200"} 1`,
+		`paramveer_http_requests_total{route="/api/movies/{id}",method="GET",status="404"} 1`,
+		`paramveer_http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`paramveer_http_requests_total{route="/api/movies/stream",method="GET",status="101"} 1`,
+		`paramveer_http_request_duration_seconds_count{route="/api/movies",method="POST"} 2`,
+		`paramveer_http_request_duration_seconds_bucket{route="/api/movies",method="POST",le="+Inf"} 2`,
+		"# TYPE paramveer_http_requests_in_flight gauge",
+		`paramveer_repo_operations_total{operation="create",result="ok"} 2`,
+		`paramveer_repo_operations_total{operation="get",result="error"} 1`,
+		"paramveer_movies 1",
+		"paramveer_movies_trashed 1",
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing %s in\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// withRequestID tags every request with a correlation ID, reusing the one
+// sent by the client or a proxy when present, and echoes it in the response.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	var raw [8]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(raw[:])
+}
+
+// actorHeader names who is making a request. The server does not
+// authenticate anyone itself; an authenticating proxy in front of it is
+// expected to set the header.
+const actorHeader = "X-Actor"
+
+// anonymousActor is recorded for changes made without an actor.
+const anonymousActor = "anonymous"
+
+type actorKey struct{}
+
+// withActor makes the actor named by the request available to
+// actorFrom.
+func withActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := r.Header.Get(actorHeader)
+		if actor == "" || len(actor) > 128 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), actorKey{}, actor)))
+	})
+}
+
+// withBodyLimit rejects request bodies larger than limit bytes. A body
+// that announces its length is refused up front with errBodyTooLarge; one
+// that does not is cut off at the limit, which bodyError reports the same
+// way.
+func withBodyLimit(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				resolveError(w, r, fmt.Errorf("%w: %d bytes is more than the %d allowed", errBodyTooLarge, r.ContentLength, limit))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bodyError describes err, met while reading or decoding a request body, as
+// kind, or as errBodyTooLarge when withBodyLimit cut the body off.
+func bodyError(kind error, err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: more than the %d bytes allowed", errBodyTooLarge, tooLarge.Limit)
+	}
+	return fmt.Errorf("%w: %v", kind, err)
+}
+
+func actorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return anonymousActor
+}
@@ -1,10 +1,223 @@
 package main
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
 type Movie struct {
-	ID        int     `json:"id"`
-	Title     string  `json:"title"`
-	Director  string  `json:"director"`
-	IMDb      float64 `json:"imdb"`
-	Hollywood string  `json:"hollywood"`
-	Bollywood string  `json:"bollywood"`
+	ID        int        `json:"id"`
+	UID       string     `json:"uid,omitempty"`
+	Title     string     `json:"title"`
+	Director  string     `json:"director"`
+	IMDb      float64    `json:"imdb"`
+	Industry  Industry   `json:"industry"`
+	Genres    Genres     `json:"genres"`
+	Version   int        `json:"version,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Industry is the film industry a movie was made in. The zero value means
+// it is not known.
+type Industry string
+
+const (
+	IndustryHollywood Industry = "hollywood"
+	IndustryBollywood Industry = "bollywood"
+	IndustryTollywood Industry = "tollywood"
+	IndustryKollywood Industry = "kollywood"
+	IndustryKorean    Industry = "korean"
+	IndustryOther     Industry = "other"
+)
+
+var industries = []Industry{
+	IndustryHollywood,
+	IndustryBollywood,
+	IndustryTollywood,
+	IndustryKollywood,
+	IndustryKorean,
+	IndustryOther,
+}
+
+// legacyIndustry maps the hollywood and bollywood flags movies carried
+// before they had an Industry. Flags that claim both industries, or
+// neither, leave the industry unknown.
+func legacyIndustry(hollywood, bollywood string) Industry {
+	h := strings.EqualFold(strings.TrimSpace(hollywood), "yes")
+	b := strings.EqualFold(strings.TrimSpace(bollywood), "yes")
+	switch {
+	case h && !b:
+		return IndustryHollywood
+	case b && !h:
+		return IndustryBollywood
+	default:
+		return ""
+	}
+}
+
+// Genres is a set of genres, one bit per entry of genreNames. It is
+// written as a JSON array of genre names, in the order of genreNames.
+type Genres uint32
+
+// The genres, in the order of genreNames. New genres must only ever be
+// added at the end: the bits are what SQLite stores.
+const (
+	GenreAction Genres = 1 << iota
+	GenreAdventure
+	GenreAnimation
+	GenreBiography
+	GenreComedy
+	GenreCrime
+	GenreDocumentary
+	GenreDrama
+	GenreFamily
+	GenreFantasy
+	GenreHistory
+	GenreHorror
+	GenreMusical
+	GenreMystery
+	GenreRomance
+	GenreSciFi
+	GenreSport
+	GenreThriller
+	GenreWar
+	GenreWestern
+)
+
+// genreNames names each genre by its bit position.
+var genreNames = []string{
+	"action",
+	"adventure",
+	"animation",
+	"biography",
+	"comedy",
+	"crime",
+	"documentary",
+	"drama",
+	"family",
+	"fantasy",
+	"history",
+	"horror",
+	"musical",
+	"mystery",
+	"romance",
+	"sci-fi",
+	"sport",
+	"thriller",
+	"war",
+	"western",
+}
+
+// parseGenre looks up a genre by name, ignoring case and surrounding
+// whitespace.
+func parseGenre(name string) (Genres, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, known := range genreNames {
+		if known == name {
+			return 1 << i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown genre %q", name)
+}
+
+// parseGenres reads a list of genre names separated by sep. Empty names
+// are skipped.
+func parseGenres(s string, sep string) (Genres, error) {
+	var genres Genres
+	for _, name := range strings.Split(s, sep) {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		genre, err := parseGenre(name)
+		if err != nil {
+			return 0, err
+		}
+		genres |= genre
+	}
+	return genres, nil
+}
+
+// Has reports whether g holds every genre of other.
+func (g Genres) Has(other Genres) bool {
+	return g&other == other
+}
+
+func (g Genres) names() []string {
+	names := []string{}
+	for i, name := range genreNames {
+		if g&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (g Genres) String() string {
+	return strings.Join(g.names(), ";")
+}
+
+func (g Genres) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.names())
+}
+
+func (g *Genres) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*g = 0
+	for _, name := range names {
+		genre, err := parseGenre(name)
+		if err != nil {
+			return err
+		}
+		*g |= genre
+	}
+	return nil
+}
+
+// movieJSON is what a Movie is read from: its own fields plus the
+// hollywood and bollywood flags older clients, journals and revisions
+// still carry. The flags only count when industry is not given.
+type movieJSON struct {
+	plainMovie
+	Hollywood string `json:"hollywood"`
+	Bollywood string `json:"bollywood"`
+}
+
+// plainMovie is Movie without its methods, so that decoding it does not
+// recurse.
+type plainMovie Movie
+
+func (m movieJSON) movie() Movie {
+	movie := Movie(m.plainMovie)
+	if movie.Industry == "" {
+		movie.Industry = legacyIndustry(m.Hollywood, m.Bollywood)
+	}
+	return movie
+}
+
+func (m *Movie) UnmarshalJSON(data []byte) error {
+	var raw movieJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = raw.movie()
+	return nil
+}
+
+// decodeStrictMovie reads a movie like Movie.UnmarshalJSON but rejects
+// fields it does not know, which json.Decoder.DisallowUnknownFields cannot
+// do through an UnmarshalJSON method.
+func decodeStrictMovie(data []byte) (Movie, error) {
+	var raw movieJSON
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&raw); err != nil {
+		return Movie{}, err
+	}
+	return raw.movie(), nil
 }
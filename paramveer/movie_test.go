@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMovie_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Movie
+		wantErr bool
+	}{
+		{
+			name: "industry and genres",
+			data: `{"id": 1, "title": "bhamsa", "industry": "tollywood", "genres": ["Drama", "action", "drama"]}`,
+			want: Movie{ID: 1, Title: "bhamsa", Industry: IndustryTollywood, Genres: GenreAction | GenreDrama},
+		},
+		{
+			name: "legacy hollywood",
+			data: `{"id": 1, "hollywood": "yes", "bollywood": "no"}`,
+			want: Movie{ID: 1, Industry: IndustryHollywood},
+		},
+		{
+			name: "legacy bollywood",
+			data: `{"id": 1, "bollywood": "YES"}`,
+			want: Movie{ID: 1, Industry: IndustryBollywood},
+		},
+		{
+			name: "contradicting legacy flags",
+			data: `{"id": 1, "hollywood": "yes", "bollywood": "yes"}`,
+			want: Movie{ID: 1},
+		},
+		{
+			name: "industry wins over legacy flags",
+			data: `{"id": 1, "industry": "korean", "hollywood": "yes"}`,
+			want: Movie{ID: 1, Industry: IndustryKorean},
+		},
+		{
+			name:    "unknown genre",
+			data:    `{"id": 1, "genres": ["noir"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "genres must be a list",
+			data:    `{"id": 1, "genres": "drama"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Movie
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v but want error %t", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("got %+v but want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMovie_MarshalJSON(t *testing.T) {
+	movie := Movie{ID: 1, Title: "bhamsa", Industry: IndustryBollywood, Genres: GenreWestern | GenreComedy}
+	data, err := json.Marshal(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":1,"title":"bhamsa","director":"","imdb":0,"industry":"bollywood","genres":["comedy","western"]}`
+	if string(data) != want {
+		t.Errorf("got %s but want %s", data, want)
+	}
+}
+
+func Test_decodeStrictMovie(t *testing.T) {
+	if got, err := decodeStrictMovie([]byte(`{"id": 1, "hollywood": "yes"}`)); err != nil || got.Industry != IndustryHollywood {
+		t.Errorf("got %+v, %v but want the legacy flags accepted", got, err)
+	}
+	if _, err := decodeStrictMovie([]byte(`{"id": 1, "budget": 5}`)); err == nil {
+		t.Error("want an error for an unknown field")
+	}
+}
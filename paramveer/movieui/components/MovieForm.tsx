@@ -7,8 +7,8 @@ interface MovieInput {
     title: string,
     director: string
     imdb: string,
-    hollywood: string
-    bollywood: string
+    industry: string
+    genres: string
 }
 
 export interface MovieFormProps {
@@ -22,8 +22,8 @@ export default function MovieForm({ saveMovie, movie }: MovieFormProps): JSX.Ele
         title: movie.title,
         director: movie.director,
         imdb: movie.imdb.toString(),
-        hollywood: movie.hollywood,
-        bollywood: movie.bollywood,
+        industry: movie.industry,
+        genres: movie.genres.join(", "),
     }
 
     const [movieInput, setMovieInput] = useState<MovieInput>(initialMovie)
@@ -41,8 +41,8 @@ export default function MovieForm({ saveMovie, movie }: MovieFormProps): JSX.Ele
             title: movieInput.title,
             director: movieInput.director,
             imdb: parseInt(movieInput.imdb),
-            hollywood: movieInput.hollywood,
-            bollywood: movieInput.bollywood
+            industry: movieInput.industry,
+            genres: movieInput.genres.split(",").map((genre) => genre.trim()).filter((genre) => genre !== "")
         }
 
         saveMovie(movie)
@@ -51,7 +51,7 @@ export default function MovieForm({ saveMovie, movie }: MovieFormProps): JSX.Ele
                 setMovieInput(initialMovie)
             })
             .catch((error) => {
-                setErrorMsg(error?.response?.data)
+                setErrorMsg(error?.response?.data?.detail ?? error?.response?.data?.title ?? error?.message)
                 console.log(error)
             })
     }
@@ -82,11 +82,11 @@ export default function MovieForm({ saveMovie, movie }: MovieFormProps): JSX.Ele
                 <FormLabel>IMDb:</FormLabel>
                 <Input type="number" placeholder='Enter Rating' name="imdb" value={movieInput.imdb} onChange={handleChange} />
 
-                <FormLabel>Hollywood:</FormLabel>
-                <Input type="text" placeholder="yes/no" name="hollywood" value={movieInput.hollywood} onChange={handleChange} />
+                <FormLabel>Industry:</FormLabel>
+                <Input type="text" placeholder="hollywood, bollywood, tollywood, kollywood, korean or other" name="industry" value={movieInput.industry} onChange={handleChange} />
 
-                <FormLabel>Bollywood:</FormLabel>
-                <Input type="text" placeholder="yes/no" name="bollywood" value={movieInput.bollywood} onChange={handleChange} />
+                <FormLabel>Genres:</FormLabel>
+                <Input type="text" placeholder="drama, comedy, ..." name="genres" value={movieInput.genres} onChange={handleChange} />
 
                 <div>
                     <Button type='submit' colorScheme="blue">Submit</Button>
@@ -1,6 +1,6 @@
 import { Alert, AlertIcon, AlertTitle, Box, Center } from '@chakra-ui/react'
 import { Movie } from "../movies";
-import getMovies, { createMovie, deleteMovie } from "@/api";
+import getMovies, { createMovie, deleteMovie, watchMovies } from "@/api";
 import { useEffect, useState } from "react";
 import MovieTable from "./MovieTable";
 import PopoverForm from "./PopoverForm";
@@ -10,8 +10,8 @@ const initialMovie: Movie = {
     title: "",
     director: "",
     imdb: 0,
-    hollywood: "",
-    bollywood: "",
+    industry: "",
+    genres: [],
 }
 
 export default function MovieList() {
@@ -38,6 +38,8 @@ export default function MovieList() {
 
     useEffect(() => {
         loadMovies()
+        // Reload whenever anyone changes a movie, so the table stays current.
+        return watchMovies(() => loadMovies())
     }, [])
 
     return (
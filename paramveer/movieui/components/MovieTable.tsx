@@ -19,8 +19,8 @@ export default function MovieTable({ movies, loadMovies, DeleteMovie }: MovieTab
                         <Th>Title</Th>
                         <Th>Director</Th>
                         <Th>IMDb</Th>
-                        <Th>Hollywood</Th>
-                        <Th>Bollywood</Th>
+                        <Th>Industry</Th>
+                        <Th>Genres</Th>
                     </Tr>
                 </Thead>
                 <Tbody>
@@ -16,8 +16,8 @@ export default function TableRow({ movie, loadMovies, DeleteMovie }: TableRowPro
             <Td>{movie.title}</Td>
             <Td>{movie.director}</Td>
             <Td>{movie.imdb}</Td>
-            <Td>{movie.hollywood}</Td>
-            <Td>{movie.bollywood}</Td>
+            <Td>{movie.industry}</Td>
+            <Td>{movie.genres.join(", ")}</Td>
             <Td display="flex">
                 <Button margin="10px" onClick={(() => DeleteMovie(id))} colorScheme="red">Delete</Button>
                 <UpdateMovie loadMovies={loadMovies} movie={movie} />
@@ -1,4 +1,4 @@
-import { updateMovie } from "@/api";
+import { moveMovie, patchMovie } from "@/api";
 import { Center } from "@chakra-ui/react";
 import { Movie } from "../movies";
 import PopoverForm from "./PopoverForm";
@@ -9,8 +9,25 @@ export interface updateMovieProps {
 }
 
 export default function UpdateMovie({ loadMovies, movie }: updateMovieProps) {
-    function update(movie: Movie) {
-        return updateMovie(movie).then(() => loadMovies())
+    // Only the fields the user changed are sent, and only if nobody saved
+    // the movie since it was loaded; otherwise the server answers 412.
+    // A new id is a move, which has to happen before the patch.
+    async function update(changed: Movie) {
+        let { id, version } = movie
+        if (changed.id !== movie.id) {
+            const moved = await moveMovie(movie.id, changed.id, movie.version)
+            id = moved.id
+            version = moved.version
+        }
+
+        const patch: Partial<Movie> = {}
+        for (const key of Object.keys(changed) as (keyof Movie)[]) {
+            if (key !== "id" && changed[key] !== movie[key]) {
+                (patch as Record<string, unknown>)[key] = changed[key]
+            }
+        }
+        await patchMovie(id, patch, version)
+        loadMovies()
     }
 
     return (
@@ -1,8 +1,11 @@
 export interface Movie {
     id: number,
+    uid?: string,
     title: string,
     director: string
     imdb: number,
-    hollywood: string
-    bollywood: string
+    industry: string
+    genres: string[]
+    version?: number
+    deleted_at?: string
 }
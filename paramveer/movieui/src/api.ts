@@ -2,17 +2,84 @@ import axios from "axios";
 import { Movie } from "../movies";
 
 export function createMovie(movie: Movie): Promise<void> {
-  return axios.post("/api/movies", movie)
+  // The server assigns ids to new movies.
+  const { id, ...newMovie } = movie
+  return axios.post("/api/movies", newMovie)
 }
 
 export default function getMovies():Promise<Movie[]> {
-  return axios.get("/api/movies").then((res)=>{return res.data})
+  return axios.get("/api/movies", { params: { limit: 1000 } }).then((res)=>{return res.data.movies})
 }
 
+// deleteMovie moves the movie to the trash, from where restoreMovie can
+// bring it back until it is purged.
 export function deleteMovie(id: string): Promise<void> {
   return axios.delete(`/api/movies/${id}`)
 }
 
+export function restoreMovie(id: number): Promise<Movie> {
+  return axios.post(`/api/movies/${id}/restore`).then((res) => res.data)
+}
+
 export function updateMovie(movie: Movie,): Promise<Movie> {
   return axios.put(`/api/movies/${movie.id}`, movie)
-}
\ No newline at end of file
+}
+
+// patchMovie fails with 412 if the movie is no longer at the given version,
+// i.e. someone else saved it after it was loaded.
+export function patchMovie(id: number, patch: Partial<Movie>, version?: number): Promise<Movie> {
+  const headers: Record<string, string> = { "Content-Type": "application/merge-patch+json" }
+  if (version) {
+    headers["If-Match"] = `"${version}"`
+  }
+  return axios.patch(`/api/movies/${id}`, patch, { headers })
+}
+
+// moveMovie gives a movie a new id. It fails with 409 if another movie,
+// trashed or not, already has that id.
+export function moveMovie(id: number, to: number, version?: number): Promise<Movie> {
+  const headers: Record<string, string> = {}
+  if (version) {
+    headers["If-Match"] = `"${version}"`
+  }
+  return axios.post(`/api/movies/${id}/move`, { id: to }, { headers }).then((res) => res.data)
+}
+
+export interface MovieEvent {
+  token: string
+  type: "created" | "updated" | "deleted" | "restored" | "moved" | "reset"
+  // movie_id is the old id of a moved movie.
+  movie_id?: number
+  movie?: Movie
+}
+
+// watchMovies calls onEvent for every change pushed on the movie stream,
+// reconnecting after a dropped connection and resuming where it stopped.
+// It returns a function that stops watching.
+export function watchMovies(onEvent: (event: MovieEvent) => void): () => void {
+  let socket: WebSocket | undefined
+  let token = ""
+  let stopped = false
+
+  function connect() {
+    const scheme = window.location.protocol === "https:" ? "wss" : "ws"
+    const resume = token ? `?resume=${encodeURIComponent(token)}` : ""
+    socket = new WebSocket(`${scheme}://${window.location.host}/api/movies/stream${resume}`)
+    socket.onmessage = (message) => {
+      const event: MovieEvent = JSON.parse(message.data)
+      token = event.token
+      onEvent(event)
+    }
+    socket.onclose = () => {
+      if (!stopped) {
+        setTimeout(connect, 1000)
+      }
+    }
+  }
+
+  connect()
+  return () => {
+    stopped = true
+    socket?.close()
+  }
+}
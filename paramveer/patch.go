@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+var (
+	errInvalidPatch     = errors.New("invalid patch")
+	errPatchTestFailed  = errors.New("patch test failed")
+	errUnsupportedPatch = errors.New("unsupported patch media type")
+)
+
+// moviePatch is a partial change to a stored movie.
+type moviePatch interface {
+	apply(movie Movie) (Movie, error)
+}
+
+// parseMoviePatch decodes body according to its media type. Plain JSON is
+// treated as a merge patch.
+func parseMoviePatch(contentType string, body []byte) (moviePatch, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+
+	switch mediaType {
+	case mergePatchContentType, "application/json":
+		if !json.Valid(body) {
+			return nil, fmt.Errorf("%w: body is not valid JSON", errInvalidPatch)
+		}
+		return mergePatch(body), nil
+	case jsonPatchContentType:
+		var ops jsonPatch
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidPatch, err)
+		}
+		return ops, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnsupportedPatch, contentType)
+	}
+}
+
+// mergePatch is an RFC 7396 JSON Merge Patch document.
+type mergePatch []byte
+
+func (p mergePatch) apply(movie Movie) (Movie, error) {
+	var patch any
+	if err := json.Unmarshal(p, &patch); err != nil {
+		return Movie{}, fmt.Errorf("%w: %v", errInvalidPatch, err)
+	}
+	if _, ok := patch.(map[string]any); !ok {
+		return Movie{}, fmt.Errorf("%w: merge patch must be a JSON object", errInvalidPatch)
+	}
+
+	doc, err := movieDocument(movie)
+	if err != nil {
+		return Movie{}, err
+	}
+	return documentMovie(mergeValue(doc, patch), movie)
+}
+
+// mergeValue implements the MergePatch function of RFC 7396 section 2.
+func mergeValue(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for name, value := range patchObj {
+		if value == nil {
+			delete(targetObj, name)
+			continue
+		}
+		targetObj[name] = mergeValue(targetObj[name], value)
+	}
+	return targetObj
+}
+
+// jsonPatch is an RFC 6902 JSON Patch document.
+type jsonPatch []jsonPatchOp
+
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (p jsonPatch) apply(movie Movie) (Movie, error) {
+	doc, err := movieDocument(movie)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	for i, op := range p {
+		doc, err = op.apply(doc)
+		if err != nil {
+			return Movie{}, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return documentMovie(doc, movie)
+}
+
+func (op jsonPatchOp) apply(doc any) (any, error) {
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", errInvalidPatch)
+		}
+		var v any
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidPatch, err)
+		}
+		return v, nil
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, op.Path, v)
+	case "remove":
+		doc, _, err := pointerRemove(doc, op.Path)
+		return doc, err
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		doc, _, err = pointerRemove(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, op.Path, v)
+	case "move":
+		if op.Path == op.From {
+			// Moving a value onto itself changes nothing, but the value
+			// still has to be there.
+			if _, err := pointerGet(doc, op.From); err != nil {
+				return nil, err
+			}
+			return doc, nil
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("%w: cannot move a value into one of its children", errInvalidPatch)
+		}
+		doc, v, err := pointerRemove(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, op.Path, v)
+	case "copy":
+		v, err := pointerGet(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, op.Path, deepCopy(v))
+	case "test":
+		want, err := value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := pointerGet(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(got, want) {
+			return nil, errPatchTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", errInvalidPatch, op.Op)
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: pointer %q must start with /", errInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func pointerGet(doc any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	return walk(doc, tokens)
+}
+
+// walk follows tokens from doc and returns the value they lead to.
+func walk(doc any, tokens []string) (any, error) {
+	for n, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]any:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: /%s does not exist", errInvalidPatch, strings.Join(tokens[:n+1], "/"))
+			}
+			doc = v
+		case []any:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%w: /%s does not exist", errInvalidPatch, strings.Join(tokens[:n+1], "/"))
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd sets the value at pointer, inserting into arrays, and returns
+// the updated document.
+func pointerAdd(doc any, pointer string, value any) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parent, err := walk(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+		return doc, nil
+	case []any:
+		i := len(node)
+		if last != "-" {
+			if i, err = arrayIndex(last, len(node)); err != nil {
+				return nil, err
+			}
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return replaceAt(doc, tokens[:len(tokens)-1], node)
+	default:
+		return nil, fmt.Errorf("%w: cannot add at %q", errInvalidPatch, pointer)
+	}
+}
+
+// pointerRemove deletes the value at pointer and returns the updated
+// document along with the removed value.
+func pointerRemove(doc any, pointer string) (any, any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("%w: cannot remove the whole document", errInvalidPatch)
+	}
+
+	parent, err := walk(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		v, ok := node[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %q does not exist", errInvalidPatch, pointer)
+		}
+		delete(node, last)
+		return doc, v, nil
+	case []any:
+		i, err := arrayIndex(last, len(node)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		doc, err = replaceAt(doc, tokens[:len(tokens)-1], node)
+		return doc, v, err
+	default:
+		return nil, nil, fmt.Errorf("%w: %q does not exist", errInvalidPatch, pointer)
+	}
+}
+
+// replaceAt stores value at the location named by tokens. Arrays change
+// identity when they grow or shrink, so their parent has to be updated.
+func replaceAt(doc any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parent, err := walk(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+	case []any:
+		i, err := arrayIndex(last, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+	}
+	return doc, nil
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: bad array index %q", errInvalidPatch, token)
+	}
+	return i, nil
+}
+
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for k, e := range v {
+			c[k] = deepCopy(e)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, e := range v {
+			c[i] = deepCopy(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+func jsonEqual(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// movieDocument and documentMovie convert between a Movie and its generic
+// JSON form, which is what patches operate on. documentMovie takes the movie
+// the patch was applied to, for patchLegacyIndustry.
+func movieDocument(movie Movie) (any, error) {
+	data, err := json.Marshal(movie)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func documentMovie(doc any, current Movie) (Movie, error) {
+	if obj, ok := doc.(map[string]any); ok {
+		if err := patchLegacyIndustry(obj, current); err != nil {
+			return Movie{}, err
+		}
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	movie, err := decodeStrictMovie(data)
+	if err != nil {
+		return Movie{}, fmt.Errorf("%w: %v", errInvalidPatch, err)
+	}
+	return movie, nil
+}
+
+// patchLegacyIndustry applies the hollywood and bollywood flags a patch
+// added to doc to its industry, and takes them out again. A flag the patch
+// leaves out keeps what current's industry implies, so {"hollywood": "yes"}
+// makes a movie hollywood and {"hollywood": "no"} makes a hollywood movie's
+// industry unknown but leaves a korean one alone. If the patch changed the
+// industry itself, that wins over the flags, as it does in a movie body.
+func patchLegacyIndustry(doc map[string]any, current Movie) error {
+	flags := map[Industry]string{}
+	for _, industry := range []Industry{IndustryHollywood, IndustryBollywood} {
+		if current.Industry == industry {
+			flags[industry] = "yes"
+		}
+	}
+	given := false
+	for _, industry := range []Industry{IndustryHollywood, IndustryBollywood} {
+		value, ok := doc[string(industry)]
+		if !ok {
+			continue
+		}
+		flag, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%w: %s must be a string", errInvalidPatch, industry)
+		}
+		flags[industry] = flag
+		given = true
+		delete(doc, string(industry))
+	}
+	if !given {
+		return nil
+	}
+
+	if industry, _ := doc["industry"].(string); industry != string(current.Industry) {
+		return nil
+	}
+	next := legacyIndustry(flags[IndustryHollywood], flags[IndustryBollywood])
+	if next != "" || current.Industry == IndustryHollywood || current.Industry == IndustryBollywood {
+		doc["industry"] = string(next)
+	}
+	return nil
+}
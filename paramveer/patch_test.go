@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_moviePatch_apply(t *testing.T) {
+	current := Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood}
+
+	tests := []struct {
+		name        string
+		contentType string
+		patch       string
+		want        Movie
+		wantErr     error
+	}{
+		{
+			name:        "merge patch changes one field",
+			contentType: mergePatchContentType,
+			patch:       `{"imdb": 9.1}`,
+			want:        Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 9.1, Industry: IndustryBollywood},
+		},
+		{
+			name:        "merge patch null clears a field",
+			contentType: mergePatchContentType,
+			patch:       `{"industry": null, "title": "singh"}`,
+			want:        Movie{ID: 1, Title: "singh", Director: "paramveer", IMDb: 8},
+		},
+		{
+			name:        "plain json is a merge patch",
+			contentType: "application/json; charset=utf-8",
+			patch:       `{"director": "hardik"}`,
+			want:        Movie{ID: 1, Title: "bhamsa", Director: "hardik", IMDb: 8, Industry: IndustryBollywood},
+		},
+		{
+			name:        "merge patch with legacy flags",
+			contentType: mergePatchContentType,
+			patch:       `{"hollywood": "yes", "bollywood": "no"}`,
+			want:        Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryHollywood},
+		},
+		{
+			name:        "merge patch turning the industry's flag off",
+			contentType: mergePatchContentType,
+			patch:       `{"bollywood": "no"}`,
+			want:        Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8},
+		},
+		{
+			name:        "merge patch with an unrelated flag off",
+			contentType: mergePatchContentType,
+			patch:       `{"hollywood": "no"}`,
+			want:        Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood},
+		},
+		{
+			name:        "legacy flags claiming both industries",
+			contentType: mergePatchContentType,
+			patch:       `{"hollywood": "yes"}`,
+			want:        Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8},
+		},
+		{
+			name:        "industry wins over legacy flags",
+			contentType: mergePatchContentType,
+			patch:       `{"industry": "korean", "hollywood": "yes"}`,
+			want:        Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryKorean},
+		},
+		{
+			name:        "legacy flag that is not a string",
+			contentType: mergePatchContentType,
+			patch:       `{"hollywood": true}`,
+			wantErr:     errInvalidPatch,
+		},
+		{
+			name:        "json patch adding legacy flags",
+			contentType: jsonPatchContentType,
+			patch:       `[{"op": "add", "path": "/hollywood", "value": "yes"}, {"op": "add", "path": "/bollywood", "value": "no"}]`,
+			want:        Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryHollywood},
+		},
+		{
+			name:        "merge patch must be an object",
+			contentType: mergePatchContentType,
+			patch:       `["imdb"]`,
+			wantErr:     errInvalidPatch,
+		},
+		{
+			name:        "merge patch with unknown field",
+			contentType: mergePatchContentType,
+			patch:       `{"budget": 100}`,
+			wantErr:     errInvalidPatch,
+		},
+		{
+			name:        "merge patch with wrong type",
+			contentType: mergePatchContentType,
+			patch:       `{"imdb": "high"}`,
+			wantErr:     errInvalidPatch,
+		},
+		{
+			name:        "json patch replace and test",
+			contentType: jsonPatchContentType,
+			patch: `[
+				{"op": "test", "path": "/title", "value": "bhamsa"},
+				{"op": "replace", "path": "/imdb", "value": 7.5},
+				{"op": "copy", "from": "/director", "path": "/title"}
+			]`,
+			want: Movie{ID: 1, Title: "paramveer", Director: "paramveer", IMDb: 7.5, Industry: IndustryBollywood},
+		},
+		{
+			name:        "json patch move and remove",
+			contentType: jsonPatchContentType,
+			patch: `[
+				{"op": "move", "from": "/director", "path": "/title"},
+				{"op": "add", "path": "/genres/-", "value": "drama"},
+				{"op": "remove", "path": "/industry"}
+			]`,
+			want: Movie{ID: 1, Title: "paramveer", IMDb: 8, Genres: GenreDrama},
+		},
+		{
+			name:        "json patch test fails",
+			contentType: jsonPatchContentType,
+			patch:       `[{"op": "test", "path": "/imdb", "value": 9}, {"op": "replace", "path": "/imdb", "value": 1}]`,
+			wantErr:     errPatchTestFailed,
+		},
+		{
+			name:        "json patch missing path",
+			contentType: jsonPatchContentType,
+			patch:       `[{"op": "remove", "path": "/budget"}]`,
+			wantErr:     errInvalidPatch,
+		},
+		{
+			name:        "json patch unknown op",
+			contentType: jsonPatchContentType,
+			patch:       `[{"op": "increment", "path": "/imdb"}]`,
+			wantErr:     errInvalidPatch,
+		},
+		{
+			name:        "unsupported media type",
+			contentType: "text/plain",
+			patch:       `imdb=9`,
+			wantErr:     errUnsupportedPatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := parseMoviePatch(tt.contentType, []byte(tt.patch))
+			if err == nil {
+				var got Movie
+				got, err = patch.apply(current)
+				if err == nil && got != tt.want {
+					t.Errorf("got %+v but want %+v", got, tt.want)
+				}
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("want error %q but got %q", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_jsonPatchArrays(t *testing.T) {
+	doc := map[string]any{"tags": []any{"a", "c"}}
+
+	var err error
+	var got any = doc
+	for _, op := range []jsonPatchOp{
+		{Op: "add", Path: "/tags/1", Value: []byte(`"b"`)},
+		{Op: "add", Path: "/tags/-", Value: []byte(`"d"`)},
+		{Op: "remove", Path: "/tags/0"},
+		{Op: "replace", Path: "/tags/2", Value: []byte(`"e"`)},
+		{Op: "move", From: "/tags/1", Path: "/tags/1"},
+	} {
+		if got, err = op.apply(got); err != nil {
+			t.Fatalf("%s %s: unexpected error %q", op.Op, op.Path, err)
+		}
+	}
+
+	if want := map[string]any{"tags": []any{"b", "c", "e"}}; !jsonEqual(got, want) {
+		t.Errorf("got %v but want %v", got, want)
+	}
+
+	if _, err := (jsonPatchOp{Op: "add", Path: "/tags/9", Value: []byte(`"x"`)}).apply(got); !errors.Is(err, errInvalidPatch) {
+		t.Errorf("want error %q but got %q", errInvalidPatch, err)
+	}
+	if _, err := (jsonPatchOp{Op: "move", From: "/tags/9", Path: "/tags/9"}).apply(got); !errors.Is(err, errInvalidPatch) {
+		t.Errorf("want error %q but got %q", errInvalidPatch, err)
+	}
+	if _, err := (jsonPatchOp{Op: "move", From: "/tags", Path: "/tags/0"}).apply(got); !errors.Is(err, errInvalidPatch) {
+		t.Errorf("want error %q but got %q", errInvalidPatch, err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+const problemContentType = "application/problem+json"
+
+// statusClientClosedRequest is the non-standard status nginx popularised for
+// requests abandoned by the client before a response was written.
+const statusClientClosedRequest = 499
+
+var (
+	errInvalidBody      = errors.New("invalid body")
+	errBodyTooLarge     = errors.New("request body too large")
+	errInvalidPathId    = errors.New("cannot access id")
+	errNoRoute          = errors.New("no such route")
+	errMethodNotAllowed = errors.New("method not allowed")
+
+	errPreconditionRequired = errors.New("If-Match header is required")
+)
+
+// problem is an RFC 7807 problem details document. Code is a stable,
+// machine readable name for the kind of error; Type is derived from it.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+// errorKind is how one sentinel error is presented to clients.
+type errorKind struct {
+	err    error
+	status int
+	code   string
+	title  string
+	field  string
+}
+
+// errorKinds is checked in order with errors.Is; anything unmatched is an
+// internal server error.
+var errorKinds = []errorKind{
+	{err: errBodyTooLarge, status: http.StatusRequestEntityTooLarge, code: "body_too_large", title: "request body too large"},
+	{err: errInvalidBody, status: http.StatusBadRequest, code: "invalid_body", title: "invalid body"},
+	{err: errInvalidPathId, status: http.StatusBadRequest, code: "invalid_path_id", title: "cannot access id"},
+	{err: errInvalidQuery, status: http.StatusBadRequest, code: "invalid_query", title: "invalid query"},
+	{err: errInvalidMovie, status: http.StatusBadRequest, code: "invalid_movie", title: "invalid movie"},
+	{err: errIdMismatch, status: http.StatusBadRequest, code: "id_mismatch", title: "id in the body does not match the path", field: "id"},
+	{err: errInvalidMove, status: http.StatusBadRequest, code: "invalid_move", title: "invalid move", field: "id"},
+	{err: errClientId, status: http.StatusBadRequest, code: "client_id", title: "id is assigned by the server", field: "id"},
+	{err: errClientUid, status: http.StatusBadRequest, code: "client_uid", title: "uid is assigned by the server", field: "uid"},
+	{err: errInvalidId, status: http.StatusBadRequest, code: "invalid_id", title: "invalid id", field: "id"},
+	{err: errInvalidPatch, status: http.StatusBadRequest, code: "invalid_patch", title: "invalid patch"},
+	{err: errPatchTestFailed, status: http.StatusConflict, code: "patch_test_failed", title: "patch test failed"},
+	{err: errUnsupportedPatch, status: http.StatusUnsupportedMediaType, code: "unsupported_patch", title: "unsupported patch media type"},
+	{err: errInvalidBatch, status: http.StatusBadRequest, code: "invalid_batch", title: "invalid batch"},
+	{err: errBatchAborted, status: http.StatusFailedDependency, code: "batch_aborted", title: "batch aborted"},
+	{err: errRollbackFailed, status: http.StatusInternalServerError, code: "rollback_failed", title: "batch rollback failed"},
+	{err: errInvalidImport, status: http.StatusBadRequest, code: "invalid_import", title: "invalid import"},
+	{err: errInvalidRow, status: http.StatusBadRequest, code: "invalid_row", title: "invalid row"},
+	{err: errUnsupportedFormat, status: http.StatusUnsupportedMediaType, code: "unsupported_format", title: "unsupported catalog format"},
+	{err: errNotFound, status: http.StatusNotFound, code: "movie_not_found", title: "movie not found"},
+	{err: errRevisionNotFound, status: http.StatusNotFound, code: "revision_not_found", title: "revision not found"},
+	{err: errConflict, status: http.StatusConflict, code: "movie_conflict", title: "movie already exist"},
+	{err: errVersionConflict, status: http.StatusPreconditionFailed, code: "version_conflict", title: "movie was changed by someone else"},
+	{err: errPreconditionRequired, status: http.StatusPreconditionRequired, code: "precondition_required", title: "precondition required"},
+	{err: errNoRoute, status: http.StatusNotFound, code: "route_not_found", title: "route not found"},
+	{err: errMethodNotAllowed, status: http.StatusMethodNotAllowed, code: "method_not_allowed", title: "method not allowed"},
+	{err: context.Canceled, status: statusClientClosedRequest, code: "request_canceled", title: "request canceled"},
+	{err: context.DeadlineExceeded, status: http.StatusGatewayTimeout, code: "request_timeout", title: "request timed out"},
+}
+
+var internalErrorKind = errorKind{
+	status: http.StatusInternalServerError,
+	code:   "internal_error",
+	title:  "internal server error",
+}
+
+func classifyError(err error) errorKind {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind
+		}
+	}
+	return internalErrorKind
+}
+
+// newProblem describes err for clients. Details of internal errors are kept
+// out of the response; they only go to the server log.
+func newProblem(err error) problem {
+	kind := classifyError(err)
+
+	p := problem{
+		Type:   "/problems/" + kind.code,
+		Title:  kind.title,
+		Status: kind.status,
+		Code:   kind.code,
+	}
+	if kind.err != nil {
+		p.Detail = err.Error()
+	}
+
+	var fields validationErrors
+	switch {
+	case errors.As(err, &fields):
+		p.Errors = fields
+	case kind.field != "":
+		p.Errors = []fieldError{{Field: kind.field, Code: kind.code, Message: err.Error()}}
+	}
+	return p
+}
+
+// resolveError answers r with the problem document for err and logs the
+// underlying error together with the request ID. Server errors are always
+// logged, client errors at info level.
+func resolveError(w http.ResponseWriter, r *http.Request, err error) {
+	p := newProblem(err)
+	p.RequestID = requestIDFrom(r.Context())
+
+	if p.Status >= http.StatusInternalServerError {
+		log.Printf("request %s: %s %s: %d %s: %v", p.RequestID, r.Method, r.URL.Path, p.Status, p.Code, err)
+	} else {
+		logf(levelInfo, "request %s: %s %s: %d %s: %v", p.RequestID, r.Method, r.URL.Path, p.Status, p.Code, err)
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Printf("failed to send response for :%q", err)
+	}
+}
+
+// problemHandler answers every request with the problem document for err;
+// the router uses it for unmatched routes and methods.
+func problemHandler(err error) http.Handler {
+	return withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resolveError(w, r, err)
+	}))
+}
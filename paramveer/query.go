@@ -0,0 +1,215 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+var errInvalidQuery = errors.New("invalid query")
+
+// movieQuery selects a page of movies. Zero values mean "no constraint",
+// except Limit, which the service fills in with defaultPageSize.
+type movieQuery struct {
+	Director      string
+	TitleContains string
+	MinIMDb       *float64
+	MaxIMDb       *float64
+	Industries    []Industry // any of them, "" for movies without one
+	Genres        Genres     // all of them
+	Sort          []sortKey
+	Limit         int
+	Cursor        string
+}
+
+// sortKey orders by one movie field; ties fall through to the next key and
+// finally to insertion order.
+type sortKey struct {
+	Field string
+	Desc  bool
+}
+
+var sortableFields = map[string]bool{
+	"id":       true,
+	"title":    true,
+	"director": true,
+	"imdb":     true,
+}
+
+type moviePage struct {
+	Movies     []Movie `json:"movies"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	Total      int     `json:"total"`
+}
+
+// parseSort reads a comma separated list of fields, each optionally
+// prefixed with "-" for descending order, e.g. "imdb,-title".
+func parseSort(s string) ([]sortKey, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var keys []sortKey
+	for _, field := range strings.Split(s, ",") {
+		key := sortKey{Field: strings.TrimSpace(field)}
+		if strings.HasPrefix(key.Field, "-") {
+			key.Field = key.Field[1:]
+			key.Desc = true
+		}
+		if !sortableFields[key.Field] {
+			return nil, fmt.Errorf("%w: cannot sort by %q", errInvalidQuery, key.Field)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// validate checks q and fills in the default page size.
+func (q *movieQuery) validate() error {
+	switch {
+	case q.Limit < 0 || q.Limit > maxPageSize:
+		return fmt.Errorf("%w: limit must be between 1 and %d", errInvalidQuery, maxPageSize)
+	case q.Limit == 0:
+		q.Limit = defaultPageSize
+	}
+
+	if q.MinIMDb != nil && q.MaxIMDb != nil && *q.MinIMDb > *q.MaxIMDb {
+		return fmt.Errorf("%w: min_imdb is greater than max_imdb", errInvalidQuery)
+	}
+
+	for _, industry := range q.Industries {
+		if industry != "" && !slices.Contains(industries, industry) {
+			return fmt.Errorf("%w: unknown industry %q", errInvalidQuery, industry)
+		}
+	}
+
+	for _, key := range q.Sort {
+		if !sortableFields[key.Field] {
+			return fmt.Errorf("%w: cannot sort by %q", errInvalidQuery, key.Field)
+		}
+	}
+
+	if _, err := decodeCursor(q.Cursor); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Cursors are opaque to clients; they currently carry the offset of the
+// next page.
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("o:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), "o:") {
+		return 0, fmt.Errorf("%w: malformed cursor", errInvalidQuery)
+	}
+	offset, err := strconv.Atoi(string(raw[2:]))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("%w: malformed cursor", errInvalidQuery)
+	}
+	return offset, nil
+}
+
+// foldCase is how every repo compares titles and directors regardless of
+// case, and how the search index folds terms, so that "Éclair", "ÉCLAIR" and
+// "éclair" are the same. It folds all Unicode letters, not just ASCII ones;
+// SQLiteRepo registers it with SQLite for that reason.
+func foldCase(s string) string {
+	return strings.ToLower(s)
+}
+
+// matches reports whether movie passes every filter in q.
+func (q movieQuery) matches(movie Movie) bool {
+	if q.Director != "" && foldCase(movie.Director) != foldCase(q.Director) {
+		return false
+	}
+	if q.TitleContains != "" && !strings.Contains(foldCase(movie.Title), foldCase(q.TitleContains)) {
+		return false
+	}
+	if q.MinIMDb != nil && movie.IMDb < *q.MinIMDb {
+		return false
+	}
+	if q.MaxIMDb != nil && movie.IMDb > *q.MaxIMDb {
+		return false
+	}
+	if len(q.Industries) > 0 && !slices.Contains(q.Industries, movie.Industry) {
+		return false
+	}
+	return movie.Genres.Has(q.Genres)
+}
+
+// applyMovieQuery filters, sorts and pages movies, which must be in
+// insertion order. It is the reference implementation used by the in-memory
+// backends; other backends must return the same pages.
+func applyMovieQuery(movies []Movie, q movieQuery) (moviePage, error) {
+	offset, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return moviePage{}, err
+	}
+
+	matched := []Movie{}
+	for _, movie := range movies {
+		if q.matches(movie) {
+			matched = append(matched, movie)
+		}
+	}
+
+	if len(q.Sort) > 0 {
+		sort.SliceStable(matched, func(i, j int) bool {
+			return compareMovies(matched[i], matched[j], q.Sort) < 0
+		})
+	}
+
+	page := moviePage{Movies: []Movie{}, Total: len(matched)}
+	if offset >= len(matched) {
+		return page, nil
+	}
+
+	end := len(matched)
+	if q.Limit > 0 && offset+q.Limit < end {
+		end = offset + q.Limit
+		page.NextCursor = encodeCursor(end)
+	}
+	page.Movies = append(page.Movies, matched[offset:end]...)
+	return page, nil
+}
+
+func compareMovies(a, b Movie, keys []sortKey) int {
+	for _, key := range keys {
+		var c int
+		switch key.Field {
+		case "id":
+			c = cmp.Compare(a.ID, b.ID)
+		case "title":
+			c = cmp.Compare(foldCase(a.Title), foldCase(b.Title))
+		case "director":
+			c = cmp.Compare(foldCase(a.Director), foldCase(b.Director))
+		case "imdb":
+			c = cmp.Compare(a.IMDb, b.IMDb)
+		}
+		if key.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
@@ -1,67 +1,427 @@
 package main
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
 
 var errConflict = errors.New("movie already exist")
 var errNotFound = errors.New("movie doesn't found")
+var errVersionConflict = errors.New("movie was changed by someone else")
 
+// Repo is the storage contract for movies. Implementations must give up and
+// return ctx.Err() once ctx is done, without applying a mutation they have
+// not yet committed.
+//
+// Repos own Movie.Version: createMovie stores version 1 and every update
+// bumps it. A movie keeps the UID it was created with; updateMovie ignores
+// newmovie.UID. updateMovie treats newmovie.Version, and deleteMovie its version
+// argument, as the version the caller expects to find; a mismatch fails with
+// errVersionConflict, and 0 skips the check.
+//
+// Every write that bumps the version also records the movie as a revision,
+// stamped with the time and the actor named by ctx. A movie's revisions
+// follow it when it is re-keyed and are dropped when it is purged.
+//
+// Deleting a movie moves it to the trash. A trashed movie keeps its ID, so
+// no other movie can take it, but every method other than the trash ones
+// treats it as missing until it is restored or purged.
 type Repo interface {
-	createMovie(newmovie Movie) error
-	getAllMovie() ([]Movie, error)
-	getMovieById(id int) (Movie, error)
-	updateMovie(id int, newmovie Movie) (Movie, error)
-	deleteMovie(id int) (Movie, error)
+	createMovie(ctx context.Context, newmovie Movie) error
+	getAllMovie(ctx context.Context) ([]Movie, error)
+	listMovies(ctx context.Context, q movieQuery) (moviePage, error)
+	// movieStats groups the live movies by q.GroupBy, ordered by key, and
+	// reports the count and rating range and average of each group, plus
+	// percentiles and a histogram when q asks for them. q is validated.
+	movieStats(ctx context.Context, q statsQuery) ([]statsGroup, error)
+	getMovieById(ctx context.Context, id int) (Movie, error)
+	updateMovie(ctx context.Context, id int, newmovie Movie) (Movie, error)
+	deleteMovie(ctx context.Context, id int, version int) (Movie, error)
+
+	listTrash(ctx context.Context) ([]Movie, error)
+	countTrash(ctx context.Context) (int, error)
+	restoreMovie(ctx context.Context, id int, version int) (Movie, error)
+	purgeMovie(ctx context.Context, id int) (Movie, error)
+	// purgeTrash permanently removes every movie trashed before the given
+	// time and reports how many there were.
+	purgeTrash(ctx context.Context, before time.Time) (int, error)
+
+	// listRevisions returns the revisions of the movie stored under id,
+	// trashed or not, oldest first.
+	listRevisions(ctx context.Context, id int) ([]revision, error)
+
+	// nextMovieID hands out a fresh ID from a per-repo sequence. The
+	// sequence skips past any ID a movie was created or moved with, so an
+	// ID is never handed out again once a movie has had it, even after the
+	// movie is purged.
+	nextMovieID(ctx context.Context) (int, error)
+
+	// ping reports whether the storage can serve requests: it is reachable
+	// and its schema is the one this server expects.
+	ping(ctx context.Context) error
+
+	// Close releases the storage once the server no longer uses the repo.
+	// Every write has reached the storage by the time it returns.
+	Close() error
 }
 
+// InMemoryRepo keeps movies in a map keyed by ID and remembers insertion
+// order separately, so lookups are O(1) and listings stay stable. Trashed
+// movies stay in both, marked by DeletedAt. Revisions are kept per movie ID.
+// All methods are safe for concurrent use.
 type InMemoryRepo struct {
-	movies []Movie
+	mu      sync.RWMutex
+	movies  map[int]Movie
+	order   []int
+	history map[int][]revision
+	lastID  int
+
+	// now tells when a change is made.
+	now func() time.Time
 }
 
 func NewInMemoryRepo() *InMemoryRepo {
 	return &InMemoryRepo{
-		movies: []Movie{},
+		movies:  map[int]Movie{},
+		order:   []int{},
+		history: map[int][]revision{},
+		now:     time.Now,
 	}
 }
 
-func (m *InMemoryRepo) createMovie(newmovie Movie) error {
-	for _, existingmovie := range m.movies {
-		if existingmovie.ID == newmovie.ID {
-			return errConflict
-		}
+func (m *InMemoryRepo) ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Close does nothing; the movies go with the process.
+func (m *InMemoryRepo) Close() error {
+	return nil
+}
+
+func (m *InMemoryRepo) createMovie(ctx context.Context, newmovie Movie) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.create(newmovie, m.stamp(ctx))
+}
+
+func (m *InMemoryRepo) create(newmovie Movie, stamp revisionStamp) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.movies[newmovie.ID]; ok {
+		return errConflict
 	}
-	m.movies = append(m.movies, newmovie)
+	newmovie.Version = 1
+	newmovie.DeletedAt = nil
+	m.movies[newmovie.ID] = newmovie
+	m.order = append(m.order, newmovie.ID)
+	m.lastID = max(m.lastID, newmovie.ID)
+	m.record(movieCreated, newmovie, stamp)
 	return nil
 }
 
-func (m *InMemoryRepo) getAllMovie() ([]Movie, error) {
-	return m.movies, nil
+// getAllMovie returns a copy of the stored movies in insertion order; the
+// caller may modify it freely.
+func (m *InMemoryRepo) getAllMovie(ctx context.Context) ([]Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.collect(false), nil
+}
+
+func (m *InMemoryRepo) listMovies(ctx context.Context, q movieQuery) (moviePage, error) {
+	movies, err := m.getAllMovie(ctx)
+	if err != nil {
+		return moviePage{}, err
+	}
+	return applyMovieQuery(movies, q)
+}
+
+func (m *InMemoryRepo) movieStats(ctx context.Context, q statsQuery) ([]statsGroup, error) {
+	movies, err := m.getAllMovie(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateMovies(movies, q), nil
+}
+
+func (m *InMemoryRepo) getMovieById(ctx context.Context, id int) (Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return Movie{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	existingmovie, ok := m.movies[id]
+	if !ok || existingmovie.DeletedAt != nil {
+		return Movie{}, errNotFound
+	}
+	return existingmovie, nil
+}
+
+func (m *InMemoryRepo) updateMovie(ctx context.Context, id int, newmovie Movie) (Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return Movie{}, err
+	}
+	return m.update(id, newmovie, m.stamp(ctx))
+}
+
+func (m *InMemoryRepo) update(id int, newmovie Movie, stamp revisionStamp) (Movie, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existingmovie, ok := m.movies[id]
+	if !ok || existingmovie.DeletedAt != nil {
+		return Movie{}, errNotFound
+	}
+	if newmovie.Version != 0 && newmovie.Version != existingmovie.Version {
+		return Movie{}, errVersionConflict
+	}
+	newmovie.UID = existingmovie.UID
+	newmovie.Version = existingmovie.Version + 1
+	newmovie.DeletedAt = nil
+
+	if newmovie.ID != id {
+		if _, ok := m.movies[newmovie.ID]; ok {
+			return Movie{}, errConflict
+		}
+		delete(m.movies, id)
+		m.order[m.position(id)] = newmovie.ID
+		m.history[newmovie.ID] = m.history[id]
+		delete(m.history, id)
+	}
+	m.movies[newmovie.ID] = newmovie
+	m.lastID = max(m.lastID, newmovie.ID)
+	m.record(movieUpdated, newmovie, stamp)
+	return newmovie, nil
+}
+
+func (m *InMemoryRepo) deleteMovie(ctx context.Context, id int, version int) (Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return Movie{}, err
+	}
+	return m.trash(id, version, m.stamp(ctx))
+}
+
+// trash marks a live movie as deleted at the time of stamp.
+func (m *InMemoryRepo) trash(id int, version int, stamp revisionStamp) (Movie, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deletedmovie, ok := m.movies[id]
+	if !ok || deletedmovie.DeletedAt != nil {
+		return Movie{}, errNotFound
+	}
+	if version != 0 && version != deletedmovie.Version {
+		return Movie{}, errVersionConflict
+	}
+
+	at := stamp.Time
+	deletedmovie.Version++
+	deletedmovie.DeletedAt = &at
+	m.movies[id] = deletedmovie
+	m.record(movieDeleted, deletedmovie, stamp)
+	return deletedmovie, nil
+}
+
+// listTrash returns the trashed movies in insertion order.
+func (m *InMemoryRepo) listTrash(ctx context.Context) ([]Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.collect(true), nil
+}
+
+// countTrash reports how many movies are in the trash.
+func (m *InMemoryRepo) countTrash(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := 0
+	for _, movie := range m.movies {
+		if movie.DeletedAt != nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *InMemoryRepo) restoreMovie(ctx context.Context, id int, version int) (Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return Movie{}, err
+	}
+	return m.restore(id, version, m.stamp(ctx))
+}
+
+func (m *InMemoryRepo) restore(id int, version int, stamp revisionStamp) (Movie, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	restoredmovie, ok := m.movies[id]
+	if !ok || restoredmovie.DeletedAt == nil {
+		return Movie{}, errNotFound
+	}
+	if version != 0 && version != restoredmovie.Version {
+		return Movie{}, errVersionConflict
+	}
+
+	restoredmovie.Version++
+	restoredmovie.DeletedAt = nil
+	m.movies[id] = restoredmovie
+	m.record(movieRestored, restoredmovie, stamp)
+	return restoredmovie, nil
+}
+
+func (m *InMemoryRepo) purgeMovie(ctx context.Context, id int) (Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return Movie{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purgedmovie, ok := m.movies[id]
+	if !ok || purgedmovie.DeletedAt == nil {
+		return Movie{}, errNotFound
+	}
+	m.remove(id)
+	return purgedmovie, nil
 }
 
-func (m *InMemoryRepo) getMovieById(id int) (Movie, error) {
-	for _, existingmovie := range m.movies {
-		if id == existingmovie.ID {
-			return existingmovie, nil
+func (m *InMemoryRepo) purgeTrash(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int
+	for _, movie := range m.collect(true) {
+		if movie.DeletedAt.Before(before) {
+			m.remove(movie.ID)
+			purged++
 		}
 	}
-	return Movie{}, errNotFound
+	return purged, nil
 }
 
-func (m *InMemoryRepo) updateMovie(id int, newmovie Movie) (Movie, error) {
-	for i, movietoupdate := range m.movies {
-		if id == movietoupdate.ID {
-			m.movies[i] = newmovie
-			return newmovie, nil
+func (m *InMemoryRepo) listRevisions(ctx context.Context, id int) ([]revision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.movies[id]; !ok {
+		return nil, errNotFound
+	}
+	return append([]revision{}, m.history[id]...), nil
+}
+
+func (m *InMemoryRepo) nextMovieID(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID++
+	return m.lastID, nil
+}
+
+// reserveID makes the sequence skip past id.
+func (m *InMemoryRepo) reserveID(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastID = max(m.lastID, id)
+}
+
+// loadMovie appends movie exactly as given, version and trash state
+// included, together with its revisions. It is used to reload state that was
+// saved earlier, never for new movies.
+func (m *InMemoryRepo) loadMovie(movie Movie, revisions []revision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.movies[movie.ID]; ok {
+		return errConflict
+	}
+	m.movies[movie.ID] = movie
+	m.order = append(m.order, movie.ID)
+	if len(revisions) > 0 {
+		m.history[movie.ID] = revisions
+	}
+	m.lastID = max(m.lastID, movie.ID)
+	return nil
+}
+
+// stamp describes a change made now on behalf of ctx.
+func (m *InMemoryRepo) stamp(ctx context.Context) revisionStamp {
+	return revisionStamp{Time: m.now().UTC(), Actor: actorFrom(ctx)}
+}
+
+// record appends movie to its history. The caller must hold m.mu.
+func (m *InMemoryRepo) record(change string, movie Movie, stamp revisionStamp) {
+	m.history[movie.ID] = append(m.history[movie.ID], stamp.revision(change, movie))
+}
+
+// stored returns the movie held under id, whether it is trashed or not.
+func (m *InMemoryRepo) stored(id int) (Movie, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movie, ok := m.movies[id]
+	return movie, ok
+}
+
+// collect returns either the live or the trashed movies in insertion order.
+// The caller must hold m.mu.
+func (m *InMemoryRepo) collect(trashed bool) []Movie {
+	movies := make([]Movie, 0, len(m.order))
+	for _, id := range m.order {
+		if movie := m.movies[id]; (movie.DeletedAt != nil) == trashed {
+			movies = append(movies, movie)
 		}
 	}
-	return Movie{}, errNotFound
+	return movies
+}
+
+// remove forgets id entirely. The caller must hold m.mu and have checked
+// that id is stored.
+func (m *InMemoryRepo) remove(id int) {
+	i := m.position(id)
+	m.order = append(m.order[:i], m.order[i+1:]...)
+	delete(m.movies, id)
+	delete(m.history, id)
 }
 
-func (m *InMemoryRepo) deleteMovie(id int) (Movie, error) {
-	for i, deletedmovie := range m.movies {
-		if id == deletedmovie.ID {
-			m.movies = append(m.movies[:i], m.movies[i+1:]...)
-			return deletedmovie, nil
+// position returns the index of id in m.order. The caller must hold m.mu
+// and have checked that id is stored.
+func (m *InMemoryRepo) position(id int) int {
+	for i, existing := range m.order {
+		if existing == id {
+			return i
 		}
 	}
-	return Movie{}, errNotFound
+	return -1
 }
@@ -1,11 +1,41 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
+// testDeletedAt is when movies are trashed in tests that fix the clock.
+var testDeletedAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// newSeededRepo returns an InMemoryRepo holding movies in the given order.
+func newSeededRepo(t *testing.T, movies []Movie) *InMemoryRepo {
+	t.Helper()
+
+	repo := NewInMemoryRepo()
+	for _, movie := range movies {
+		if err := repo.createMovie(context.Background(), movie); err != nil {
+			t.Fatalf("failed to seed movie %+v: %q", movie, err)
+		}
+	}
+	return repo
+}
+
+// storedMovies returns everything currently held by repo.
+func storedMovies(t *testing.T, repo *InMemoryRepo) []Movie {
+	t.Helper()
+
+	movies, err := repo.getAllMovie(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list movies: %q", err)
+	}
+	return movies
+}
+
 func TestInMemory_createMovie(t *testing.T) {
 	type args struct {
 		newmovie Movie
@@ -21,22 +51,20 @@ func TestInMemory_createMovie(t *testing.T) {
 			name: "when movie already exist",
 			existingmovie: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
 				},
 			},
 			args: args{
 				newmovie: Movie{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
 				},
 			},
 			wantErr: errConflict,
@@ -46,12 +74,11 @@ func TestInMemory_createMovie(t *testing.T) {
 			existingmovie: []Movie{},
 			args: args{
 				newmovie: Movie{
-					ID:        1,
-					Title:     "paramveer",
-					Director:  "bhamsa",
-					IMDb:      9,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "paramveer",
+					Director: "bhamsa",
+					IMDb:     9,
+					Industry: IndustryHollywood,
 				},
 			},
 			wantErr: nil,
@@ -60,10 +87,9 @@ func TestInMemory_createMovie(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			repo.movies = tt.existingmovie
+			repo := newSeededRepo(t, tt.existingmovie)
 
-			gotErr := repo.createMovie(tt.args.newmovie)
+			gotErr := repo.createMovie(context.Background(), tt.args.newmovie)
 
 			if !errors.Is(gotErr, tt.wantErr) {
 				t.Errorf("want error %q but got error %q", tt.wantErr, gotErr)
@@ -87,32 +113,30 @@ func TestInMemory_getMovieById(t *testing.T) {
 			name: "movie exist",
 			existingmovie: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      9,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     9,
+					Industry: IndustryHollywood,
 				},
 				{
-					ID:        2,
-					Title:     "Hardik Sharma",
-					Director:  "Paramveer singh sarangdevot",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Hardik Sharma",
+					Director: "Paramveer singh sarangdevot",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 			},
 			args: args{
 				id: 1,
 			},
 			wantRes: Movie{
-				ID:        1,
-				Title:     "bhamsa",
-				Director:  "paramveer",
-				IMDb:      9,
-				Hollywood: "yes",
-				Bollywood: "no",
+				ID:       1,
+				Title:    "bhamsa",
+				Director: "paramveer",
+				IMDb:     9,
+				Industry: IndustryHollywood,
+				Version:  1,
 			},
 			wantErr: nil,
 		},
@@ -120,12 +144,11 @@ func TestInMemory_getMovieById(t *testing.T) {
 			name: "when movie doesn't found",
 			existingmovie: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      9,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     9,
+					Industry: IndustryHollywood,
 				},
 			},
 			args: args{
@@ -137,10 +160,9 @@ func TestInMemory_getMovieById(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			repo.movies = tt.existingmovie
+			repo := newSeededRepo(t, tt.existingmovie)
 
-			getRes, getErr := repo.getMovieById(tt.args.id)
+			getRes, getErr := repo.getMovieById(context.Background(), tt.args.id)
 
 			if !errors.Is(getErr, tt.wantErr) {
 				t.Errorf("want error %q but got %q", tt.wantErr, getErr)
@@ -164,38 +186,36 @@ func TestInMemory_getAllMovies(t *testing.T) {
 			name: "movies exists",
 			existingmovie: []Movie{
 				{
-					ID:        1,
-					Title:     "Bhamsa",
-					Director:  "Paramveer",
-					IMDb:      7.7,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Bhamsa",
+					Director: "Paramveer",
+					IMDb:     7.7,
+					Industry: IndustryHollywood,
 				},
 				{
-					ID:        2,
-					Title:     "Paramveer",
-					Director:  "Bhamsa",
-					IMDb:      8.9,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Paramveer",
+					Director: "Bhamsa",
+					IMDb:     8.9,
+					Industry: IndustryBollywood,
 				},
 			},
 			wantRes: []Movie{
 				{
-					ID:        1,
-					Title:     "Bhamsa",
-					Director:  "Paramveer",
-					IMDb:      7.7,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Bhamsa",
+					Director: "Paramveer",
+					IMDb:     7.7,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 				{
-					ID:        2,
-					Title:     "Paramveer",
-					Director:  "Bhamsa",
-					IMDb:      8.9,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Paramveer",
+					Director: "Bhamsa",
+					IMDb:     8.9,
+					Industry: IndustryBollywood,
+					Version:  1,
 				},
 			},
 			wantErr: nil,
@@ -210,10 +230,9 @@ func TestInMemory_getAllMovies(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			repo.movies = tt.existingmovie
+			repo := newSeededRepo(t, tt.existingmovie)
 
-			gotRes, gotErr := repo.getAllMovie()
+			gotRes, gotErr := repo.getAllMovie(context.Background())
 
 			if !errors.Is(gotErr, tt.wantErr) {
 				t.Errorf("want error %q but got %q", tt.wantErr, gotErr)
@@ -242,31 +261,29 @@ func TestInMemory_deleteMovie(t *testing.T) {
 			name: "when movie exist",
 			existingmovie: []Movie{
 				{
-					ID:        1,
-					Title:     "Paramveer",
-					Director:  "Bhamsa",
-					IMDb:      9,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Paramveer",
+					Director: "Bhamsa",
+					IMDb:     9,
+					Industry: IndustryHollywood,
 				},
 				{
-					ID:        2,
-					Title:     "Paramveer singh",
-					Director:  "Bhamsa sarangdevot",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Paramveer singh",
+					Director: "Bhamsa sarangdevot",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 			},
 
 			wantMovies: []Movie{
 				{
-					ID:        2,
-					Title:     "Paramveer singh",
-					Director:  "Bhamsa sarangdevot",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Paramveer singh",
+					Director: "Bhamsa sarangdevot",
+					IMDb:     10,
+					Industry: IndustryBollywood,
+					Version:  1,
 				},
 			},
 			args: args{
@@ -277,8 +294,9 @@ func TestInMemory_deleteMovie(t *testing.T) {
 				Title:     "Paramveer",
 				Director:  "Bhamsa",
 				IMDb:      9,
-				Hollywood: "yes",
-				Bollywood: "no",
+				Industry:  IndustryHollywood,
+				Version:   2,
+				DeletedAt: &testDeletedAt,
 			},
 			wantErr: nil,
 		},
@@ -286,23 +304,22 @@ func TestInMemory_deleteMovie(t *testing.T) {
 			name: "when movie doesn't found",
 			existingmovie: []Movie{
 				{
-					ID:        1,
-					Title:     "Paramveer",
-					Director:  "Bhamsa",
-					IMDb:      9,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Paramveer",
+					Director: "Bhamsa",
+					IMDb:     9,
+					Industry: IndustryHollywood,
 				},
 			},
 
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "Paramveer",
-					Director:  "Bhamsa",
-					IMDb:      9,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Paramveer",
+					Director: "Bhamsa",
+					IMDb:     9,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 			},
 			args: args{
@@ -315,21 +332,21 @@ func TestInMemory_deleteMovie(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			repo.movies = tt.existingmovie
+			repo := newSeededRepo(t, tt.existingmovie)
+			repo.now = func() time.Time { return testDeletedAt }
 
-			getRes, getErr := repo.deleteMovie(tt.args.id)
+			getRes, getErr := repo.deleteMovie(context.Background(), tt.args.id, 0)
 
 			if !errors.Is(getErr, tt.wantErr) {
 				t.Errorf("got error %q but want %q", getErr, tt.wantErr)
 			}
 
-			if getRes != tt.wantRes {
+			if !reflect.DeepEqual(getRes, tt.wantRes) {
 				t.Errorf("got %+v but want %+v", getRes, tt.wantRes)
 			}
 
-			if !reflect.DeepEqual(repo.movies, tt.wantMovies) {
-				t.Errorf("got %+v\n but want %+v", repo.movies, tt.wantMovies)
+			if !reflect.DeepEqual(storedMovies(t, repo), tt.wantMovies) {
+				t.Errorf("got %+v\n but want %+v", storedMovies(t, repo), tt.wantMovies)
 			}
 		})
 	}
@@ -352,58 +369,55 @@ func TestInMemoryRepo_updateMovie(t *testing.T) {
 			name: "movie exist",
 			existingmovie: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 				{
-					ID:        2,
-					Title:     "Paramveer singh ",
-					Director:  "hardik sharma",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       2,
+					Title:    "Paramveer singh ",
+					Director: "hardik sharma",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 			},
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "Paramveer",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Paramveer",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
+					Version:  2,
 				},
 				{
-					ID:        2,
-					Title:     "Paramveer singh ",
-					Director:  "hardik sharma",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       2,
+					Title:    "Paramveer singh ",
+					Director: "hardik sharma",
+					IMDb:     8,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 			},
 			args: args{
 				id: 1,
 				newmovie: Movie{
-					ID:        1,
-					Title:     "Paramveer",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Paramveer",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 			},
 			wantRes: Movie{
-				ID:        1,
-				Title:     "Paramveer",
-				Director:  "hardik",
-				IMDb:      8,
-				Hollywood: "yes",
-				Bollywood: "no",
+				ID:       1,
+				Title:    "Paramveer",
+				Director: "hardik",
+				IMDb:     8,
+				Industry: IndustryHollywood,
+				Version:  2,
 			},
 			wantErr: nil,
 		},
@@ -411,49 +425,46 @@ func TestInMemoryRepo_updateMovie(t *testing.T) {
 			name: "movie doesn't exist",
 			existingmovie: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 				{
-					ID:        2,
-					Title:     "Paramveer singh ",
-					Director:  "hardik sharma",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       2,
+					Title:    "Paramveer singh ",
+					Director: "hardik sharma",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 			},
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 				{
-					ID:        2,
-					Title:     "Paramveer singh ",
-					Director:  "hardik sharma",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       2,
+					Title:    "Paramveer singh ",
+					Director: "hardik sharma",
+					IMDb:     8,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 			},
 			args: args{
 				id: 3,
 				newmovie: Movie{
-					ID:        1,
-					Title:     "Paramveer",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Paramveer",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 			},
 			wantRes: Movie{},
@@ -462,10 +473,9 @@ func TestInMemoryRepo_updateMovie(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			repo.movies = tt.existingmovie
+			repo := newSeededRepo(t, tt.existingmovie)
 
-			getRes, getErr := repo.updateMovie(tt.args.id, tt.args.newmovie)
+			getRes, getErr := repo.updateMovie(context.Background(), tt.args.id, tt.args.newmovie)
 
 			if !errors.Is(getErr, tt.wantErr) {
 				t.Errorf("want error %q but got %q", tt.wantErr, getErr)
@@ -475,10 +485,147 @@ func TestInMemoryRepo_updateMovie(t *testing.T) {
 				t.Errorf("got %+v want %+v", getRes, tt.wantRes)
 			}
 
-			if !reflect.DeepEqual(repo.movies, tt.wantMovies) {
-				t.Errorf("got %+v \n but want %+v", repo.movies, tt.wantMovies)
+			if !reflect.DeepEqual(storedMovies(t, repo), tt.wantMovies) {
+				t.Errorf("got %+v \n but want %+v", storedMovies(t, repo), tt.wantMovies)
 			}
 
 		})
 	}
 }
+
+func TestInMemoryRepo_getAllMovieReturnsCopy(t *testing.T) {
+	repo := newSeededRepo(t, []Movie{
+		{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 1},
+		{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9, Industry: IndustryHollywood, Version: 1},
+		{ID: 3, Title: "singh", Director: "paramveer", IMDb: 7, Industry: IndustryBollywood, Version: 1},
+	})
+
+	got := storedMovies(t, repo)
+	got[0].Title = "changed"
+
+	if _, err := repo.deleteMovie(context.Background(), 2, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	want := []Movie{
+		{ID: 1, Title: "changed", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 1},
+		{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9, Industry: IndustryHollywood, Version: 1},
+		{ID: 3, Title: "singh", Director: "paramveer", IMDb: 7, Industry: IndustryBollywood, Version: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("earlier listing changed to %+v, want %+v", got, want)
+	}
+
+	want = []Movie{
+		{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 1},
+		{ID: 3, Title: "singh", Director: "paramveer", IMDb: 7, Industry: IndustryBollywood, Version: 1},
+	}
+	if got := storedMovies(t, repo); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v but want %+v", got, want)
+	}
+}
+
+func TestInMemoryRepo_concurrentAccess(t *testing.T) {
+	const (
+		workers = 16
+		perWork = 200
+	)
+
+	repo := NewInMemoryRepo()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWork; i++ {
+				id := w*perWork + i + 1
+				movie := Movie{ID: id, Title: "bhamsa", Director: "paramveer", IMDb: 8}
+
+				if err := repo.createMovie(context.Background(), movie); err != nil {
+					t.Errorf("create %d: %q", id, err)
+					return
+				}
+
+				movie.IMDb = 9
+				if _, err := repo.updateMovie(context.Background(), id, movie); err != nil {
+					t.Errorf("update %d: %q", id, err)
+					return
+				}
+				movie.Version = 2
+
+				if got, err := repo.getMovieById(context.Background(), id); err != nil || got != movie {
+					t.Errorf("get %d: got %+v, %v", id, got, err)
+					return
+				}
+
+				if _, err := repo.getAllMovie(context.Background()); err != nil {
+					t.Errorf("list: %q", err)
+					return
+				}
+
+				if i%2 == 0 {
+					if _, err := repo.deleteMovie(context.Background(), id, 0); err != nil {
+						t.Errorf("delete %d: %q", id, err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	movies := storedMovies(t, repo)
+	if len(movies) != workers*perWork/2 {
+		t.Fatalf("got %d movies but want %d", len(movies), workers*perWork/2)
+	}
+
+	seen := map[int]bool{}
+	for _, movie := range movies {
+		if seen[movie.ID] {
+			t.Errorf("movie %d listed twice", movie.ID)
+		}
+		seen[movie.ID] = true
+
+		if movie.ID%2 != 0 {
+			t.Errorf("movie %d should have been deleted", movie.ID)
+		}
+		if movie.IMDb != 9 {
+			t.Errorf("movie %d was not updated", movie.ID)
+		}
+	}
+}
+
+func TestInMemoryRepo_concurrentCreateConflict(t *testing.T) {
+	repo := NewInMemoryRepo()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		created   int
+		conflicts int
+	)
+	for w := 0; w < 32; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.createMovie(context.Background(), Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8})
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				created++
+			case errors.Is(err, errConflict):
+				conflicts++
+			default:
+				t.Errorf("unexpected error %q", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 || conflicts != 31 {
+		t.Errorf("got %d created and %d conflicts, want 1 and 31", created, conflicts)
+	}
+}
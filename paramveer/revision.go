@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var errRevisionNotFound = errors.New("revision doesn't found")
+
+// revision is a movie as one write left it. Number is the movie's version
+// after that write and Change says what the write was, using the event
+// types of the change feed. Time and Actor tell when and by whom it was
+// made.
+type revision struct {
+	Number int       `json:"revision"`
+	Change string    `json:"change"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Movie  Movie     `json:"movie"`
+}
+
+// revisionStamp is what a repo records about a write besides the movie.
+type revisionStamp struct {
+	Time  time.Time
+	Actor string
+}
+
+// newRevisionStamp stamps a change made now on behalf of ctx.
+func newRevisionStamp(ctx context.Context) revisionStamp {
+	return revisionStamp{Time: time.Now().UTC(), Actor: actorFrom(ctx)}
+}
+
+func (stamp revisionStamp) revision(change string, movie Movie) revision {
+	return revision{
+		Number: movie.Version,
+		Change: change,
+		Time:   stamp.Time,
+		Actor:  stamp.Actor,
+		Movie:  movie,
+	}
+}
+
+type revisionList struct {
+	Revisions []revision `json:"revisions"`
+}
+
+// revisionDiff lists the fields that differ between two revisions of a
+// movie, by their JSON names. The version is left out, since it differs
+// between any two revisions.
+type revisionDiff struct {
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Changes []fieldChange `json:"changes"`
+}
+
+// fieldChange is one field of a revisionDiff. A field that is absent on one
+// side, such as deleted_at on a live movie, is null there.
+type fieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+func findRevision(revisions []revision, number int) (revision, error) {
+	for _, rev := range revisions {
+		if rev.Number == number {
+			return rev, nil
+		}
+	}
+	return revision{}, fmt.Errorf("%w: %d", errRevisionNotFound, number)
+}
+
+func diffMovies(from, to Movie) ([]fieldChange, error) {
+	a, err := movieFields(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := movieFields(to)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0, len(a)+len(b))
+	for field := range a {
+		fields = append(fields, field)
+	}
+	for field := range b {
+		if _, ok := a[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	slices.Sort(fields)
+
+	changes := []fieldChange{}
+	for _, field := range fields {
+		if field == "version" || reflect.DeepEqual(a[field], b[field]) {
+			continue
+		}
+		changes = append(changes, fieldChange{Field: field, From: a[field], To: b[field]})
+	}
+	return changes, nil
+}
+
+// movieFields is movie as its JSON object.
+func movieFields(movie Movie) (map[string]any, error) {
+	data, err := json.Marshal(movie)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// pathRevision reads the {rev} route variable.
+func pathRevision(r *http.Request) (int, error) {
+	rev, err := strconv.Atoi(mux.Vars(r)["rev"])
+	if err != nil {
+		return 0, fmt.Errorf("%w: revision: %v", errInvalidPathId, err)
+	}
+	return rev, nil
+}
+
+func (h *movieHandler) listRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	revisions, err := h.serv.ListRevisions(r.Context(), id)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(revisionList{Revisions: revisions}); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
+
+func (h *movieHandler) getRevision(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+	number, err := pathRevision(r)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	revisions, err := h.serv.ListRevisions(r.Context(), id)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+	rev, err := findRevision(revisions, number)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(rev); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
+
+// diffRevisions compares revision ?from= with revision ?to=. to defaults to
+// the latest revision and from to the one before to.
+func (h *movieHandler) diffRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	var numbers [2]int
+	for i, name := range []string{"from", "to"} {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			resolveError(w, r, fmt.Errorf("%w: %s must be a revision number", errInvalidQuery, name))
+			return
+		}
+		numbers[i] = n
+	}
+
+	diff, err := h.serv.DiffRevisions(r.Context(), id, numbers[0], numbers[1])
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
+
+func (h *movieHandler) revertMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+	number, err := pathRevision(r)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	version, err := ifMatchVersion(r, h.requireIfMatch)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	movie, err := h.serv.RevertMovie(r.Context(), id, number, version)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", movieETag(movie))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(movie); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
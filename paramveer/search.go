@@ -0,0 +1,515 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+
+	// snippetRadius is how many runes of context a highlight snippet keeps
+	// on either side of the first match.
+	snippetRadius = 40
+)
+
+// searchField is one indexed movie field. A match in the title counts for
+// more than one in the director.
+type searchField struct {
+	name   string
+	weight float64
+	value  func(Movie) string
+}
+
+var searchFields = []searchField{
+	{name: "title", weight: 2, value: func(m Movie) string { return m.Title }},
+	{name: "director", weight: 1, value: func(m Movie) string { return m.Director }},
+}
+
+// How well a term of a movie matches a token of the query.
+const (
+	matchExact  = 3
+	matchPrefix = 2
+	matchFuzzy  = 1
+)
+
+// searchHit is one movie found by a search. Highlights holds, for every
+// field that matched, an HTML snippet of it with the matching words wrapped
+// in <mark>.
+type searchHit struct {
+	Movie      Movie             `json:"movie"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+type searchResults struct {
+	Query string      `json:"query"`
+	Hits  []searchHit `json:"hits"`
+	Total int         `json:"total"`
+}
+
+// searchToken is a word of a text, case folded, with the byte offsets of
+// the word in the text it was read from.
+type searchToken struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits s into words: runs of letters and digits, including the
+// combining marks that scripts like Devanagari write vowels with.
+func tokenize(s string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	for i, r := range s {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			tokens = append(tokens, searchToken{term: foldCase(s[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, searchToken{term: foldCase(s[start:]), start: start, end: len(s)})
+	}
+	return tokens
+}
+
+// maxEdits is how many typos a query token of the given length may have and
+// still match. Short words must be spelled right, or everything would match.
+func maxEdits(token string) int {
+	switch n := utf8.RuneCountInString(token); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions and swaps of neighbouring runes each
+// count as one edit. It gives up and returns limit+1 once the distance is
+// known to exceed limit.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			best = min(best, cur[j])
+		}
+		if best > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+// searchIndex is an inverted index from the terms of the indexed fields to
+// the movies that contain them. It holds live movies only. All methods are
+// safe for concurrent use.
+type searchIndex struct {
+	mu sync.RWMutex
+	// postings maps a term to the movies containing it, and for each movie
+	// to the summed weight of the fields it appears in.
+	postings map[string]map[int]float64
+	movies   map[int]Movie
+	// terms is every term in postings in sorted order, for prefix lookups;
+	// nil when it has to be rebuilt.
+	terms []string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: map[string]map[int]float64{},
+		movies:   map[int]Movie{},
+	}
+}
+
+// put indexes movie, replacing whatever was indexed under its ID.
+func (x *searchIndex) put(movie Movie) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(movie.ID)
+
+	x.movies[movie.ID] = movie
+	for _, field := range searchFields {
+		seen := map[string]bool{}
+		for _, token := range tokenize(field.value(movie)) {
+			if seen[token.term] {
+				continue
+			}
+			seen[token.term] = true
+
+			ids, ok := x.postings[token.term]
+			if !ok {
+				ids = map[int]float64{}
+				x.postings[token.term] = ids
+				x.terms = nil
+			}
+			ids[movie.ID] += field.weight
+		}
+	}
+}
+
+// drop removes the movie indexed under id, if any.
+func (x *searchIndex) drop(id int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(id)
+}
+
+// remove is drop without locking.
+func (x *searchIndex) remove(id int) {
+	movie, ok := x.movies[id]
+	if !ok {
+		return
+	}
+	delete(x.movies, id)
+	for _, field := range searchFields {
+		for _, token := range tokenize(field.value(movie)) {
+			ids := x.postings[token.term]
+			delete(ids, id)
+			if len(ids) == 0 {
+				delete(x.postings, token.term)
+				x.terms = nil
+			}
+		}
+	}
+}
+
+// sortedTerms returns x.terms, rebuilding it if needed. The caller must
+// hold the write lock.
+func (x *searchIndex) sortedTerms() []string {
+	if x.terms == nil {
+		x.terms = make([]string, 0, len(x.postings))
+		for term := range x.postings {
+			x.terms = append(x.terms, term)
+		}
+		slices.Sort(x.terms)
+	}
+	return x.terms
+}
+
+// matches finds the terms of the index that a query token matches, with
+// how well each matches: the token itself, the terms it is a prefix of, and
+// the terms within maxEdits typos of it.
+func (x *searchIndex) matches(token string) map[string]int {
+	terms := x.sortedTerms()
+	found := map[string]int{}
+
+	i, _ := slices.BinarySearch(terms, token)
+	for ; i < len(terms) && strings.HasPrefix(terms[i], token); i++ {
+		found[terms[i]] = matchPrefix
+	}
+	if _, ok := x.postings[token]; ok {
+		found[token] = matchExact
+	}
+
+	if limit := maxEdits(token); limit > 0 {
+		for _, term := range terms {
+			if _, ok := found[term]; ok {
+				continue
+			}
+			if editDistance(token, term, limit) <= limit {
+				found[term] = matchFuzzy
+			}
+		}
+	}
+	return found
+}
+
+// search returns the movies matching every word of query, best first, and
+// how many there are in total. A movie scores, for each query word, the
+// best of its terms that word matches, weighted by the fields the term is
+// in; ties go to the lower ID.
+func (x *searchIndex) search(query string, limit int) ([]searchHit, int) {
+	// Looking up prefixes may rebuild the sorted terms.
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var scores map[int]float64
+	matched := map[string]bool{}
+	for _, token := range tokenize(query) {
+		best := map[int]float64{}
+		for term, quality := range x.matches(token.term) {
+			matched[term] = true
+			for id, weight := range x.postings[term] {
+				best[id] = max(best[id], float64(quality)*weight)
+			}
+		}
+
+		if scores == nil {
+			scores = best
+			continue
+		}
+		for id := range scores {
+			if s, ok := best[id]; ok {
+				scores[id] += s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]searchHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, searchHit{Movie: x.movies[id], Score: score})
+	}
+	slices.SortFunc(hits, func(a, b searchHit) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Movie.ID, b.Movie.ID)
+	})
+
+	total := len(hits)
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	for i := range hits {
+		hits[i].Highlights = highlight(hits[i].Movie, matched)
+	}
+	return hits, total
+}
+
+// highlight marks the words of each field of movie whose terms are in
+// matched. Fields without a match are left out.
+func highlight(movie Movie, matched map[string]bool) map[string]string {
+	highlights := map[string]string{}
+	for _, field := range searchFields {
+		text := field.value(movie)
+		var marks []searchToken
+		for _, token := range tokenize(text) {
+			if matched[token.term] {
+				marks = append(marks, token)
+			}
+		}
+		if len(marks) > 0 {
+			highlights[field.name] = snippet(text, marks)
+		}
+	}
+	return highlights
+}
+
+// snippet cuts text down to the first mark with snippetRadius runes around
+// it and wraps the marks that fall inside in <mark>. Everything else is
+// HTML escaped, so the snippet is safe to render as is.
+func snippet(text string, marks []searchToken) string {
+	from := marks[0].start
+	for n := 0; from > 0 && n < snippetRadius; n++ {
+		_, size := utf8.DecodeLastRuneInString(text[:from])
+		from -= size
+	}
+	to := marks[0].end
+	for n := 0; to < len(text) && n < snippetRadius; n++ {
+		_, size := utf8.DecodeRuneInString(text[to:])
+		to += size
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, mark := range marks {
+		if mark.end > to {
+			break
+		}
+		b.WriteString(html.EscapeString(text[pos:mark.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[mark.start:mark.end]))
+		b.WriteString("</mark>")
+		pos = mark.end
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// indexedRepo keeps a searchIndex in step with the Repo it wraps. The index
+// is built from the repo on the first search; after that every write that
+// goes through indexedRepo updates it. Writes themselves are not serialised:
+// after one succeeds, the movies it touched are read back from the repo and
+// indexed as stored. Whichever refresh runs last sees the latest state, so
+// the index ends up matching the repo even when writes race.
+type indexedRepo struct {
+	Repo
+
+	// mu guards index and orders refreshes; it is never held during a
+	// write.
+	mu sync.Mutex
+	// index is nil until the first search.
+	index *searchIndex
+}
+
+func newIndexedRepo(r Repo) *indexedRepo {
+	return &indexedRepo{Repo: r}
+}
+
+// searchIndex returns the index, building it first if needed.
+func (r *indexedRepo) searchIndex(ctx context.Context) (*searchIndex, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.index != nil {
+		return r.index, nil
+	}
+
+	movies, err := r.Repo.getAllMovie(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index := newSearchIndex()
+	for _, movie := range movies {
+		index.put(movie)
+	}
+	r.index = index
+	return index, nil
+}
+
+// refresh indexes the movies stored under ids as they are now, dropping
+// the ones that are gone or trashed, if there is an index yet. If the repo
+// cannot tell, the index is thrown away and rebuilt on the next search.
+func (r *indexedRepo) refresh(ctx context.Context, ids ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.index == nil {
+		return
+	}
+
+	// The write has happened; the index has to follow it even if the
+	// client is gone.
+	ctx = context.WithoutCancel(ctx)
+	for _, id := range ids {
+		movie, err := r.Repo.getMovieById(ctx, id)
+		switch {
+		case err == nil:
+			r.index.put(movie)
+		case errors.Is(err, errNotFound):
+			r.index.drop(id)
+		default:
+			log.Printf("search index dropped: reading movie %d: %v", id, err)
+			r.index = nil
+			return
+		}
+	}
+}
+
+func (r *indexedRepo) createMovie(ctx context.Context, newmovie Movie) error {
+	if err := r.Repo.createMovie(ctx, newmovie); err != nil {
+		return err
+	}
+	r.refresh(ctx, newmovie.ID)
+	return nil
+}
+
+func (r *indexedRepo) updateMovie(ctx context.Context, id int, newmovie Movie) (Movie, error) {
+	updated, err := r.Repo.updateMovie(ctx, id, newmovie)
+	if err != nil {
+		return updated, err
+	}
+	// The update may have moved the movie to a new ID.
+	r.refresh(ctx, id, updated.ID)
+	return updated, nil
+}
+
+func (r *indexedRepo) deleteMovie(ctx context.Context, id int, version int) (Movie, error) {
+	deleted, err := r.Repo.deleteMovie(ctx, id, version)
+	if err != nil {
+		return deleted, err
+	}
+	r.refresh(ctx, id)
+	return deleted, nil
+}
+
+func (r *indexedRepo) restoreMovie(ctx context.Context, id int, version int) (Movie, error) {
+	restored, err := r.Repo.restoreMovie(ctx, id, version)
+	if err != nil {
+		return restored, err
+	}
+	r.refresh(ctx, id)
+	return restored, nil
+}
+
+// SearchMovies finds live movies by the words of their title and director.
+// limit defaults to defaultSearchLimit.
+func (s *service) SearchMovies(ctx context.Context, query string, limit int) (searchResults, error) {
+	if len(tokenize(query)) == 0 {
+		return searchResults{}, fmt.Errorf("%w: q must contain a word to search for", errInvalidQuery)
+	}
+	if limit < 0 || limit > maxSearchLimit {
+		return searchResults{}, fmt.Errorf("%w: limit must be between 1 and %d", errInvalidQuery, maxSearchLimit)
+	}
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+
+	index, err := s.search.searchIndex(ctx)
+	if err != nil {
+		return searchResults{}, err
+	}
+	hits, total := index.search(query, limit)
+	return searchResults{Query: query, Hits: hits, Total: total}, nil
+}
+
+func (h *movieHandler) searchMovies(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	limit := 0
+	if raw := values.Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 {
+			resolveError(w, r, fmt.Errorf("%w: limit must be a positive number", errInvalidQuery))
+			return
+		}
+	}
+
+	results, err := h.serv.SearchMovies(r.Context(), values.Get("q"), limit)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func Test_tokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []searchToken
+	}{
+		{
+			name: "words are case folded",
+			text: "The Dark-KNIGHT",
+			want: []searchToken{{"the", 0, 3}, {"dark", 4, 8}, {"knight", 9, 15}},
+		},
+		{
+			name: "offsets are bytes",
+			text: "Amélie, 2001",
+			want: []searchToken{{"amélie", 0, 7}, {"2001", 9, 13}},
+		},
+		{
+			name: "marks stay in the word",
+			text: "दिल से",
+			want: []searchToken{{"दिल", 0, 9}, {"से", 10, 16}},
+		},
+		{name: "no words", text: " -- ", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v but want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_editDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"knight", "knight", 2, 0},
+		{"knight", "knihgt", 2, 1},
+		{"knight", "night", 2, 1},
+		{"knight", "kniggt", 2, 1},
+		{"knight", "nkihgt", 2, 2},
+		{"knight", "bhamsa", 2, 3},
+		{"knight", "kn", 2, 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b, tt.limit); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d but want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func Test_searchIndex_search(t *testing.T) {
+	index := newSearchIndex()
+	for _, movie := range []Movie{
+		{ID: 1, Title: "The Dark Knight", Director: "Christopher Nolan"},
+		{ID: 2, Title: "Knight and Day", Director: "James Mangold"},
+		{ID: 3, Title: "Nolan's <Home> Movies", Director: "Paramveer Singh"},
+		{ID: 4, Title: "Dilwale", Director: "Rohit Shetty"},
+	} {
+		index.put(movie)
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		wantIDs        []int
+		wantHighlights map[string]string
+	}{
+		{
+			name:           "exact words rank title first",
+			query:          "nolan",
+			wantIDs:        []int{3, 1},
+			wantHighlights: map[string]string{"title": "<mark>Nolan</mark>&#39;s &lt;Home&gt; Movies"},
+		},
+		{
+			name:           "prefix",
+			query:          "KNI",
+			wantIDs:        []int{1, 2},
+			wantHighlights: map[string]string{"title": "The Dark <mark>Knight</mark>"},
+		},
+		{
+			name:           "typo",
+			query:          "knihgt",
+			wantIDs:        []int{1, 2},
+			wantHighlights: map[string]string{"title": "The Dark <mark>Knight</mark>"},
+		},
+		{
+			name:    "every word has to match",
+			query:   "dark nolan",
+			wantIDs: []int{1},
+			wantHighlights: map[string]string{
+				"title":    "The <mark>Dark</mark> Knight",
+				"director": "Christopher <mark>Nolan</mark>",
+			},
+		},
+		{name: "short words have no typos", query: "dal", wantIDs: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, total := index.search(tt.query, 10)
+
+			ids := []int{}
+			for _, hit := range hits {
+				ids = append(ids, hit.Movie.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) || total != len(tt.wantIDs) {
+				t.Fatalf("got ids %v of %d but want %v", ids, total, tt.wantIDs)
+			}
+			if tt.wantHighlights != nil && !reflect.DeepEqual(hits[0].Highlights, tt.wantHighlights) {
+				t.Errorf("got highlights %q but want %q", hits[0].Highlights, tt.wantHighlights)
+			}
+		})
+	}
+}
+
+func Test_snippet(t *testing.T) {
+	text := "A Very Long Title That Goes On And On Until The Word Knight Finally Turns Up Near The End Of It"
+	got := snippet(text, tokenize(text)[12:13])
+	want := "…itle That Goes On And On Until The Word <mark>Knight</mark> Finally Turns Up Near The End Of It"
+	if got != want {
+		t.Errorf("got %q but want %q", got, want)
+	}
+}
+
+// Test_service_SearchMovies checks that the index follows writes made after
+// it was built, including trashing, restoring and moving.
+func Test_service_SearchMovies(t *testing.T) {
+	ctx := context.Background()
+	serv := Newservice(newSeededRepo(t, []Movie{{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8}}))
+
+	found := func(query string) []int {
+		t.Helper()
+		res, err := serv.SearchMovies(ctx, query, 0)
+		if err != nil {
+			t.Fatalf("search %q: %q", query, err)
+		}
+		ids := []int{}
+		for _, hit := range res.Hits {
+			ids = append(ids, hit.Movie.ID)
+		}
+		return ids
+	}
+
+	if got := found("bhamsa"); !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("got %v before any write", got)
+	}
+
+	created, err := serv.CreateMovie(ctx, Movie{Title: "hardik", Director: "paramveer", IMDb: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := found("paramveer"); !reflect.DeepEqual(got, []int{1, created.ID}) {
+		t.Errorf("got %v after create", got)
+	}
+	// Hits carry the movie as stored, version included, so they match its
+	// ETag.
+	if res, _ := serv.SearchMovies(ctx, "hardik", 0); len(res.Hits) != 1 || res.Hits[0].Movie != created {
+		t.Errorf("got %+v but want a hit for %+v", res.Hits, created)
+	}
+
+	if _, err := serv.UpdateMovie(ctx, 1, Movie{ID: 1, Title: "singh", Director: "sharma", IMDb: 8}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := serv.MoveMovie(ctx, 1, 7, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := found("bhamsa"); len(got) != 0 {
+		t.Errorf("got %v for the old title after update", got)
+	}
+	if got := found("singh"); !reflect.DeepEqual(got, []int{7}) {
+		t.Errorf("got %v for the new title after update", got)
+	}
+
+	if _, err := serv.DeleteMovie(ctx, 7, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := found("singh"); len(got) != 0 {
+		t.Errorf("got %v after delete", got)
+	}
+	if _, err := serv.RestoreMovie(ctx, 7, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := found("singh"); !reflect.DeepEqual(got, []int{7}) {
+		t.Errorf("got %v after restore", got)
+	}
+}
+
+func Test_movieHandler_searchMovies(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		wantStatusCode int
+		wantCode       string
+		wantTotal      int
+	}{
+		{name: "found", query: "?q=bhamsa", wantStatusCode: http.StatusOK, wantTotal: 1},
+		{name: "nothing found", query: "?q=hardik", wantStatusCode: http.StatusOK},
+		{name: "no words", query: "?q=%20-", wantStatusCode: http.StatusBadRequest, wantCode: "invalid_query"},
+		{name: "missing q", wantStatusCode: http.StatusBadRequest, wantCode: "invalid_query"},
+		{name: "limit too big", query: "?q=bhamsa&limit=1000", wantStatusCode: http.StatusBadRequest, wantCode: "invalid_query"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newSeededRepo(t, []Movie{{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8}})
+			router := registerRoutes(NewMovieHandler(Newservice(repo)))
+
+			req := httptest.NewRequest("GET", "/api/movies/search"+tt.query, nil)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatusCode {
+				t.Fatalf("want statuscode %d but got %d: %s", tt.wantStatusCode, res.Code, res.Body.String())
+			}
+			if tt.wantCode != "" {
+				var p problem
+				if err := json.Unmarshal(res.Body.Bytes(), &p); err != nil {
+					t.Fatalf("invalid problem body %q: %q", res.Body.String(), err)
+				}
+				if p.Code != tt.wantCode {
+					t.Errorf("want code %q but got %q", tt.wantCode, p.Code)
+				}
+				return
+			}
+
+			var results searchResults
+			if err := json.Unmarshal(res.Body.Bytes(), &results); err != nil {
+				t.Fatalf("invalid body %q: %q", res.Body.String(), err)
+			}
+			if results.Total != tt.wantTotal || len(results.Hits) != tt.wantTotal {
+				t.Errorf("got %+v but want %d hits", results, tt.wantTotal)
+			}
+		})
+	}
+}
+
+// failingRepo fails every read, to check that search reports a failure to
+// build its index.
+type failingRepo struct{ Repo }
+
+var errRepoDown = errors.New("repo down")
+
+func (failingRepo) getAllMovie(context.Context) ([]Movie, error) { return nil, errRepoDown }
+
+func Test_service_SearchMoviesIndexFailure(t *testing.T) {
+	serv := Newservice(failingRepo{NewInMemoryRepo()})
+	if _, err := serv.SearchMovies(context.Background(), "bhamsa", 0); !errors.Is(err, errRepoDown) {
+		t.Errorf("got %q but want %q", err, errRepoDown)
+	}
+}
+
+func Test_service_SearchMoviesConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemoryRepo()
+	serv := Newservice(repo)
+	if _, err := serv.SearchMovies(ctx, "bhamsa", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			created, err := serv.CreateMovie(ctx, Movie{Title: "bhamsa", Director: "paramveer", IMDb: 8})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for rating := 1; rating <= 5; rating++ {
+				if _, err := serv.UpdateMovie(ctx, created.ID, Movie{Title: "bhamsa", Director: "paramveer", IMDb: float64(rating)}); err != nil {
+					t.Error(err)
+				}
+			}
+			if i%2 == 0 {
+				if _, err := serv.DeleteMovie(ctx, created.ID, 0); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	res, err := serv.SearchMovies(ctx, "bhamsa", maxSearchLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[int]Movie{}
+	for _, hit := range res.Hits {
+		got[hit.Movie.ID] = hit.Movie
+	}
+	want := map[int]Movie{}
+	for _, movie := range storedMovies(t, repo) {
+		want[movie.ID] = movie
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("index has %+v but the repo %+v", got, want)
+	}
+}
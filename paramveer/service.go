@@ -1,72 +1,247 @@
 package main
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
 
 var (
-	errInvalidId     = errors.New("invalid id")
-	errInvalidRating = errors.New("invalid imdb rating")
+	errClientId    = errors.New("id is assigned by the server")
+	errClientUid   = errors.New("uid is assigned by the server")
+	errIdMismatch  = errors.New("id in the body does not match the path")
+	errInvalidMove = errors.New("invalid move")
 )
 
-func validateId(id int) error {
-	if id < 0 {
-		return errInvalidId
-	}
-	return nil
+type movieService interface {
+	CreateMovie(ctx context.Context, newmovie Movie) (Movie, error)
+	ImportMovies(ctx context.Context, movies []Movie, dryRun bool) []error
+	GetAllMovie(ctx context.Context) ([]Movie, error)
+	ListMovies(ctx context.Context, q movieQuery) (moviePage, error)
+	MovieStats(ctx context.Context, q statsQuery) (movieStats, error)
+	GetMovieById(ctx context.Context, id int) (Movie, error)
+	UpdateMovie(ctx context.Context, id int, updatedmovie Movie) (Movie, error)
+	PatchMovie(ctx context.Context, id int, patch moviePatch, version int) (Movie, error)
+	MoveMovie(ctx context.Context, id int, to int, version int) (Movie, error)
+	DeleteMovie(ctx context.Context, id int, version int) (Movie, error)
+
+	ListTrash(ctx context.Context) ([]Movie, error)
+	RestoreMovie(ctx context.Context, id int, version int) (Movie, error)
+	PurgeMovie(ctx context.Context, id int) (Movie, error)
+	PurgeTrash(ctx context.Context, before time.Time) (int, error)
+
+	ListRevisions(ctx context.Context, id int) ([]revision, error)
+	DiffRevisions(ctx context.Context, id int, from, to int) (revisionDiff, error)
+	RevertMovie(ctx context.Context, id int, rev int, version int) (Movie, error)
+
+	SearchMovies(ctx context.Context, query string, limit int) (searchResults, error)
 }
 
-func validateMovie(movie Movie) error {
-	if movie.ID < 1 {
-		return errInvalidId
-	}
+type service struct {
+	repo      Repo
+	ids       idStrategy
+	clientIds bool
+	events    *eventLog
+	search    *indexedRepo
+}
 
-	if movie.IMDb < 1 || movie.IMDb > 10 {
-		return errInvalidRating
-	}
-	return nil
+type serviceOption func(*service)
+
+// withIDStrategy changes how IDs are assigned to new movies; the default is
+// sequenceIDs.
+func withIDStrategy(ids idStrategy) serviceOption {
+	return func(s *service) { s.ids = ids }
 }
 
-type movieService interface {
-	CreateMovie(newmovie Movie) error
-	GetAllMovie() ([]Movie, error)
-	GetMovieById(id int) (Movie, error)
-	UpdateMovie(id int, updatedmovie Movie) (Movie, error)
-	DeleteMovie(id int) (Movie, error)
+// withClientIds enables import mode: a new movie that already carries an ID
+// or UID keeps it instead of being rejected with errClientId or
+// errClientUid. Movies without one are still assigned one by the server.
+func withClientIds() serviceOption {
+	return func(s *service) { s.clientIds = true }
 }
 
-type service struct {
-	repo Repo
+// withEventLog publishes every change made through the service to events.
+func withEventLog(events *eventLog) serviceOption {
+	return func(s *service) { s.events = events }
+}
+
+func Newservice(r Repo, opts ...serviceOption) *service {
+	// Every write goes through the search index on its way to r.
+	indexed := newIndexedRepo(r)
+	s := &service{repo: indexed, ids: sequenceIDs{}, search: indexed}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
-func Newservice(r Repo) *service {
-	return &service{repo: r}
+func (s *service) CreateMovie(ctx context.Context, newmovie Movie) (Movie, error) {
+	if err := s.checkClientIds(newmovie); err != nil {
+		return Movie{}, err
+	}
+
+	// Validating first keeps a rejected movie from using up an ID.
+	newmovie = normalizeMovie(newmovie)
+	if err := validateNewMovie(newmovie); err != nil {
+		return Movie{}, err
+	}
+	if newmovie.ID == 0 {
+		if err := s.ids.assign(ctx, s.repo, &newmovie); err != nil {
+			return Movie{}, err
+		}
+	}
+
+	if err := s.repo.createMovie(ctx, newmovie); err != nil {
+		return Movie{}, err
+	}
+	// The repo stores every new movie live, whatever deleted_at said.
+	newmovie.Version = 1
+	newmovie.DeletedAt = nil
+
+	s.publish(movieCreated, newmovie.ID, newmovie)
+	return newmovie, nil
 }
 
-func (s *service) CreateMovie(newmovie Movie) error {
-	if err := validateMovie(newmovie); err != nil {
-		return err
+// ImportMovies creates each movie as CreateMovie would and returns the
+// error for each, nil for the ones that were created. Outside import mode
+// the IDs and UIDs the movies were exported with are dropped and new ones
+// assigned, so an export can always be imported again. A dry run writes
+// nothing; it reports what CreateMovie would reject without touching the
+// repo, plus IDs that a movie, live or trashed, already has or that are
+// given twice.
+func (s *service) ImportMovies(ctx context.Context, movies []Movie, dryRun bool) []error {
+	if !s.clientIds {
+		movies = slices.Clone(movies)
+		for i := range movies {
+			movies[i].ID = 0
+			movies[i].UID = ""
+		}
+	}
+
+	errs := make([]error, len(movies))
+	if !dryRun {
+		for i, movie := range movies {
+			_, errs[i] = s.CreateMovie(ctx, movie)
+		}
+		return errs
 	}
 
-	if err := s.repo.createMovie(newmovie); err != nil {
-		return err
+	// A trashed movie keeps its ID, so creating another one with it fails
+	// just as it would for a live movie.
+	seen := map[int]bool{}
+	if s.clientIds {
+		trash, err := s.repo.listTrash(ctx)
+		if err != nil {
+			for i := range errs {
+				errs[i] = err
+			}
+			return errs
+		}
+		for _, movie := range trash {
+			seen[movie.ID] = true
+		}
 	}
+	for i, movie := range movies {
+		if err := validateNewMovie(normalizeMovie(movie)); err != nil {
+			errs[i] = err
+			continue
+		}
 
+		if movie.ID == 0 {
+			continue
+		}
+		if seen[movie.ID] {
+			errs[i] = errConflict
+			continue
+		}
+		seen[movie.ID] = true
+		_, err := s.repo.getMovieById(ctx, movie.ID)
+		switch {
+		case err == nil:
+			errs[i] = errConflict
+		case !errors.Is(err, errNotFound):
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// checkClientIds rejects a new movie that carries an ID or UID of its own,
+// unless import mode lets it keep them.
+func (s *service) checkClientIds(newmovie Movie) error {
+	switch {
+	case s.clientIds:
+		return nil
+	case newmovie.ID != 0:
+		return errClientId
+	case newmovie.UID != "":
+		return errClientUid
+	}
 	return nil
 }
 
-func (s *service) GetAllMovie() ([]Movie, error) {
-	movies, err := s.repo.getAllMovie()
+func (s *service) GetAllMovie(ctx context.Context) ([]Movie, error) {
+	movies, err := s.repo.getAllMovie(ctx)
 	if err != nil {
 		return movies, err
 	}
 	return movies, nil
 }
 
-func (s *service) GetMovieById(id int) (Movie, error) {
+func (s *service) ListMovies(ctx context.Context, q movieQuery) (moviePage, error) {
+	if err := q.validate(); err != nil {
+		return moviePage{}, err
+	}
+
+	page, err := s.repo.listMovies(ctx, q)
+	if err != nil {
+		return moviePage{}, err
+	}
+
+	return page, nil
+}
+
+// MovieStats aggregates the live movies as q asks. Averages are rounded to
+// two decimals, so every repo reports the same figures.
+func (s *service) MovieStats(ctx context.Context, q statsQuery) (movieStats, error) {
+	if err := q.validate(); err != nil {
+		return movieStats{}, err
+	}
+
+	groups, err := s.repo.movieStats(ctx, q)
+	if err != nil {
+		return movieStats{}, err
+	}
+
+	stats := movieStats{GroupBy: q.GroupBy, Groups: groups}
+	for i := range groups {
+		group := &groups[i]
+		stats.Total += group.Count
+		if group.AvgIMDb != nil {
+			avg := roundRating(*group.AvgIMDb)
+			group.AvgIMDb = &avg
+		}
+		if !q.wants(metricAvg) {
+			group.AvgIMDb = nil
+		}
+		if !q.wants(metricMin) {
+			group.MinIMDb = nil
+		}
+		if !q.wants(metricMax) {
+			group.MaxIMDb = nil
+		}
+	}
+	return stats, nil
+}
+
+func (s *service) GetMovieById(ctx context.Context, id int) (Movie, error) {
 	if err := validateId(id); err != nil {
 		return Movie{}, err
 	}
 
-	movie, err := s.repo.getMovieById(id)
+	movie, err := s.repo.getMovieById(ctx, id)
 	if err != nil {
 		return movie, err
 	}
@@ -74,28 +249,212 @@ func (s *service) GetMovieById(id int) (Movie, error) {
 	return movie, nil
 }
 
-func (s *service) UpdateMovie(id int, updatedmovie Movie) (Movie, error) {
+// UpdateMovie replaces the movie stored under id. The movie keeps its ID:
+// a body without one gets id, a body with another one is rejected with
+// errIdMismatch, and MoveMovie is how a movie gets a new ID. The UID in the
+// body is ignored; the repo keeps the stored one.
+func (s *service) UpdateMovie(ctx context.Context, id int, updatedmovie Movie) (Movie, error) {
+	if err := validateId(id); err != nil {
+		return Movie{}, err
+	}
+	if updatedmovie.ID != 0 && updatedmovie.ID != id {
+		return Movie{}, fmt.Errorf("%w: got %d in the body for movie %d; use move to change it", errIdMismatch, updatedmovie.ID, id)
+	}
+	updatedmovie.ID = id
+
+	updatedmovie = normalizeMovie(updatedmovie)
 	if err := validateMovie(updatedmovie); err != nil {
 		return Movie{}, err
 	}
 
-	movie, err := s.repo.updateMovie(id, updatedmovie)
+	movie, err := s.repo.updateMovie(ctx, id, updatedmovie)
 	if err != nil {
 		return movie, err
 	}
 
+	s.publish(movieUpdated, id, movie)
 	return movie, nil
 }
 
-func (s *service) DeleteMovie(id int) (Movie, error) {
+// PatchMovie applies patch to the stored movie and saves the result through
+// the same validation as UpdateMovie. A nonzero version must match the
+// stored one. Either way the save only succeeds if nobody changed the movie
+// between reading and writing it, so concurrent patches are never lost.
+func (s *service) PatchMovie(ctx context.Context, id int, patch moviePatch, version int) (Movie, error) {
 	if err := validateId(id); err != nil {
 		return Movie{}, err
 	}
 
-	movie, err := s.repo.deleteMovie(id)
+	current, err := s.repo.getMovieById(ctx, id)
+	if err != nil {
+		return Movie{}, err
+	}
+	if version != 0 && version != current.Version {
+		return Movie{}, errVersionConflict
+	}
+
+	patched, err := patch.apply(current)
+	if err != nil {
+		return Movie{}, err
+	}
+	patched.Version = current.Version
+
+	return s.UpdateMovie(ctx, id, patched)
+}
+
+// MoveMovie re-keys the movie stored under id to the ID to, in one write
+// that fails with errConflict if any movie, trashed or not, already has
+// that ID. Its revisions move with it. A nonzero version must match the
+// stored one.
+func (s *service) MoveMovie(ctx context.Context, id int, to int, version int) (Movie, error) {
+	if err := validateId(id); err != nil {
+		return Movie{}, err
+	}
+	if to <= 0 {
+		return Movie{}, fmt.Errorf("%w: the new id must be a positive number", errInvalidMove)
+	}
+	if to == id {
+		return Movie{}, fmt.Errorf("%w: movie %d already has that id", errInvalidMove, id)
+	}
+
+	current, err := s.repo.getMovieById(ctx, id)
+	if err != nil {
+		return Movie{}, err
+	}
+	if version != 0 && version != current.Version {
+		return Movie{}, errVersionConflict
+	}
+
+	// Pinning the version read keeps the move from undoing a concurrent
+	// update.
+	moved := current
+	moved.ID = to
+	movie, err := s.repo.updateMovie(ctx, id, moved)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	s.publish(movieMoved, id, movie)
+	return movie, nil
+}
+
+func (s *service) DeleteMovie(ctx context.Context, id int, version int) (Movie, error) {
+	if err := validateId(id); err != nil {
+		return Movie{}, err
+	}
+
+	movie, err := s.repo.deleteMovie(ctx, id, version)
+	if err != nil {
+		return movie, err
+	}
+
+	s.publish(movieDeleted, id, movie)
+	return movie, nil
+}
+
+func (s *service) ListTrash(ctx context.Context) ([]Movie, error) {
+	return s.repo.listTrash(ctx)
+}
+
+func (s *service) RestoreMovie(ctx context.Context, id int, version int) (Movie, error) {
+	if err := validateId(id); err != nil {
+		return Movie{}, err
+	}
+
+	movie, err := s.repo.restoreMovie(ctx, id, version)
 	if err != nil {
 		return movie, err
 	}
 
+	s.publish(movieRestored, id, movie)
 	return movie, nil
 }
+
+// PurgeMovie permanently removes a movie from the trash. Live movies have
+// to be deleted first.
+func (s *service) PurgeMovie(ctx context.Context, id int) (Movie, error) {
+	if err := validateId(id); err != nil {
+		return Movie{}, err
+	}
+	return s.repo.purgeMovie(ctx, id)
+}
+
+// PurgeTrash permanently removes every movie trashed before the given time.
+func (s *service) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	return s.repo.purgeTrash(ctx, before)
+}
+
+func (s *service) ListRevisions(ctx context.Context, id int) ([]revision, error) {
+	if err := validateId(id); err != nil {
+		return nil, err
+	}
+	return s.repo.listRevisions(ctx, id)
+}
+
+// DiffRevisions compares two revisions of a movie. A to of 0 means the
+// latest revision, and a from of 0 the one before to.
+func (s *service) DiffRevisions(ctx context.Context, id int, from, to int) (revisionDiff, error) {
+	revisions, err := s.ListRevisions(ctx, id)
+	if err != nil {
+		return revisionDiff{}, err
+	}
+
+	if to == 0 && len(revisions) > 0 {
+		to = revisions[len(revisions)-1].Number
+	}
+	newer, err := findRevision(revisions, to)
+	if err != nil {
+		return revisionDiff{}, err
+	}
+	if from == 0 {
+		from = to - 1
+	}
+	older, err := findRevision(revisions, from)
+	if err != nil {
+		return revisionDiff{}, err
+	}
+
+	changes, err := diffMovies(older.Movie, newer.Movie)
+	if err != nil {
+		return revisionDiff{}, err
+	}
+	return revisionDiff{From: from, To: to, Changes: changes}, nil
+}
+
+// RevertMovie saves the fields of an earlier revision as a new revision,
+// through the same validation as UpdateMovie. The movie keeps its current
+// ID and UID, so reverting never re-keys it. A nonzero version must match
+// the stored one, and as with PatchMovie a concurrent change in between
+// fails the revert rather than being overwritten.
+func (s *service) RevertMovie(ctx context.Context, id int, rev int, version int) (Movie, error) {
+	revisions, err := s.ListRevisions(ctx, id)
+	if err != nil {
+		return Movie{}, err
+	}
+	target, err := findRevision(revisions, rev)
+	if err != nil {
+		return Movie{}, err
+	}
+
+	current, err := s.repo.getMovieById(ctx, id)
+	if err != nil {
+		return Movie{}, err
+	}
+	if version != 0 && version != current.Version {
+		return Movie{}, errVersionConflict
+	}
+
+	reverted := target.Movie
+	reverted.ID = current.ID
+	reverted.UID = current.UID
+	reverted.Version = current.Version
+	reverted.DeletedAt = nil
+
+	return s.UpdateMovie(ctx, id, reverted)
+}
+
+func (s *service) publish(eventType string, id int, movie Movie) {
+	if s.events != nil {
+		s.events.publish(eventType, id, movie)
+	}
+}
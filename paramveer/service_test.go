@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"regexp"
 	"testing"
+	"time"
 )
 
 func Test_service_createMovie(t *testing.T) {
@@ -22,12 +25,11 @@ func Test_service_createMovie(t *testing.T) {
 			existingMovies: []Movie{},
 			args: args{
 				newmovie: Movie{
-					ID:        0,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       -1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
 				},
 			},
 			wantMovies: []Movie{},
@@ -38,12 +40,11 @@ func Test_service_createMovie(t *testing.T) {
 			existingMovies: []Movie{},
 			args: args{
 				newmovie: Movie{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      11,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     11,
+					Industry: IndustryBollywood,
 				},
 			},
 			wantMovies: []Movie{},
@@ -53,48 +54,45 @@ func Test_service_createMovie(t *testing.T) {
 			name: "id conflict",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 				{
-					ID:        2,
-					Title:     "Hardik",
-					Director:  "Sharma",
-					IMDb:      9,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Hardik",
+					Director: "Sharma",
+					IMDb:     9,
+					Industry: IndustryBollywood,
 				},
 			},
 			args: args{
 				newmovie: Movie{
-					ID:        1,
-					Title:     "confliced movie",
-					Director:  "conflicted director",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "confliced movie",
+					Director: "conflicted director",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 			},
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
+					Version:  1,
 				},
 				{
-					ID:        2,
-					Title:     "Hardik",
-					Director:  "Sharma",
-					IMDb:      9,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Hardik",
+					Director: "Sharma",
+					IMDb:     9,
+					Industry: IndustryBollywood,
+					Version:  1,
 				},
 			},
 			wantErr: errConflict,
@@ -103,40 +101,38 @@ func Test_service_createMovie(t *testing.T) {
 			name: "test for new movie",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 			},
 			args: args{
 				newmovie: Movie{
-					ID:        2,
-					Title:     "Singh",
-					Director:  "Paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Singh",
+					Director: "Paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
 				},
 			},
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     10,
+					Industry: IndustryBollywood,
+					Version:  1,
 				},
 				{
-					ID:        2,
-					Title:     "Singh",
-					Director:  "Paramveer",
-					IMDb:      8,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Singh",
+					Director: "Paramveer",
+					IMDb:     8,
+					Industry: IndustryBollywood,
+					Version:  1,
 				},
 			},
 			wantErr: nil,
@@ -145,18 +141,117 @@ func Test_service_createMovie(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
-			serv := Newservice(repo)
+			repo := newSeededRepo(t, tt.existingMovies)
+			serv := Newservice(repo, withClientIds())
 
-			repo.movies = tt.existingMovies
-			getErr := serv.CreateMovie(tt.args.newmovie)
+			_, getErr := serv.CreateMovie(context.Background(), tt.args.newmovie)
 
 			if !errors.Is(getErr, tt.wantErr) {
 				t.Errorf("want error %q but got %q", tt.wantErr, getErr)
 			}
 
-			if !reflect.DeepEqual(repo.movies, tt.wantMovies) {
-				t.Errorf("got %+v \n but want %+v", repo.movies, tt.wantMovies)
+			if !reflect.DeepEqual(storedMovies(t, repo), tt.wantMovies) {
+				t.Errorf("got %+v \n but want %+v", storedMovies(t, repo), tt.wantMovies)
+			}
+		})
+	}
+}
+
+func Test_service_createMovieAssignsIds(t *testing.T) {
+	repo := newSeededRepo(t, []Movie{
+		{ID: 7, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood},
+	})
+	serv := Newservice(repo)
+
+	got, err := serv.CreateMovie(context.Background(), Movie{Title: "singh", Director: "paramveer", IMDb: 9, Industry: IndustryBollywood})
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got.ID != 8 {
+		t.Errorf("got id %d but want 8", got.ID)
+	}
+	if got.UID != "" {
+		t.Errorf("sequence strategy should not set uid, got %q", got.UID)
+	}
+
+	if _, err := serv.CreateMovie(context.Background(), Movie{ID: 9, Title: "singh", Director: "paramveer", IMDb: 9}); !errors.Is(err, errClientId) {
+		t.Errorf("want error %q but got %q", errClientId, err)
+	}
+	if _, err := serv.CreateMovie(context.Background(), Movie{UID: "mine", Title: "singh", Director: "paramveer", IMDb: 9}); !errors.Is(err, errClientUid) {
+		t.Errorf("want error %q but got %q", errClientUid, err)
+	}
+	// A rejected movie does not use up an ID.
+	if _, err := serv.CreateMovie(context.Background(), Movie{Title: "singh", Director: "paramveer", IMDb: 11}); !errors.Is(err, errInvalidRating) {
+		t.Errorf("want error %q but got %q", errInvalidRating, err)
+	}
+
+	// Deleting the newest movie must not make its ID available again.
+	if _, err := serv.DeleteMovie(context.Background(), 8, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	got, err = serv.CreateMovie(context.Background(), Movie{Title: "hardik", Director: "sharma", IMDb: 7})
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if got.ID != 9 {
+		t.Errorf("got id %d but want 9", got.ID)
+	}
+}
+
+func Test_service_createMovieStringIds(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		pattern  string
+	}{
+		{
+			name:     "ulid",
+			strategy: "ulid",
+			pattern:  `^[0-9A-HJKMNP-TV-Z]{26}$`,
+		},
+		{
+			name:     "uuid",
+			strategy: "uuid",
+			pattern:  `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := parseIDStrategy(tt.strategy)
+			if err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+			serv := Newservice(NewInMemoryRepo(), withIDStrategy(ids))
+
+			first, err := serv.CreateMovie(context.Background(), Movie{Title: "bhamsa", Director: "paramveer", IMDb: 8})
+			if err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+			second, err := serv.CreateMovie(context.Background(), Movie{Title: "singh", Director: "paramveer", IMDb: 8})
+			if err != nil {
+				t.Fatalf("unexpected error %q", err)
+			}
+
+			if first.ID != 1 || second.ID != 2 {
+				t.Errorf("got ids %d and %d but want 1 and 2", first.ID, second.ID)
+			}
+			if !regexp.MustCompile(tt.pattern).MatchString(first.UID) {
+				t.Errorf("uid %q does not match %s", first.UID, tt.pattern)
+			}
+			if first.UID == second.UID {
+				t.Errorf("uid %q handed out twice", first.UID)
+			}
+
+			// The UID is the server's: an update can neither drop nor
+			// replace it.
+			for _, uid := range []string{"", "mine"} {
+				updated, err := serv.UpdateMovie(context.Background(), first.ID, Movie{UID: uid, Title: "bhamsa", Director: "paramveer", IMDb: 9})
+				if err != nil {
+					t.Fatalf("unexpected error %q", err)
+				}
+				if updated.UID != first.UID {
+					t.Errorf("update with uid %q changed it to %q, want %q", uid, updated.UID, first.UID)
+				}
 			}
 		})
 	}
@@ -179,33 +274,31 @@ func Test_service_updateMovie(t *testing.T) {
 			name: "invalid id",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 			},
 			args: args{
-				id: 1,
+				id: 0,
 				updatedMovie: Movie{
-					ID:        0,
-					Title:     "Bhamsa",
-					Director:  "Paramveer",
-					IMDb:      9,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       0,
+					Title:    "Bhamsa",
+					Director: "Paramveer",
+					IMDb:     9,
+					Industry: IndustryBollywood,
 				},
 			},
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 			},
 			want:    Movie{},
@@ -215,33 +308,31 @@ func Test_service_updateMovie(t *testing.T) {
 			name: "invalid rating",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 			},
 			args: args{
 				id: 1,
 				updatedMovie: Movie{
-					ID:        1,
-					Title:     "Bhamsa",
-					Director:  "Hardik",
-					IMDb:      11,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Bhamsa",
+					Director: "Hardik",
+					IMDb:     11,
+					Industry: IndustryHollywood,
 				},
 			},
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 			},
 			want:    Movie{},
@@ -251,33 +342,31 @@ func Test_service_updateMovie(t *testing.T) {
 			name: "not found",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 			},
 			args: args{
 				id: 2,
 				updatedMovie: Movie{
-					ID:        1,
-					Title:     "Bhamsa",
-					Director:  "Hardik",
-					IMDb:      10,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       2,
+					Title:    "Bhamsa",
+					Director: "Hardik",
+					IMDb:     10,
+					Industry: IndustryHollywood,
 				},
 			},
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 			},
 			want:    Movie{},
@@ -287,58 +376,55 @@ func Test_service_updateMovie(t *testing.T) {
 			name: "updated movie",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 				{
-					ID:        2,
-					Title:     "Singh",
-					Director:  "Sharma",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       2,
+					Title:    "Singh",
+					Director: "Sharma",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 			},
 			args: args{
 				id: 2,
 				updatedMovie: Movie{
-					ID:        2,
-					Title:     "updated movie",
-					Director:  "updated director",
-					IMDb:      10,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       2,
+					Title:    "updated movie",
+					Director: "updated director",
+					IMDb:     10,
+					Industry: IndustryHollywood,
 				},
 			},
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 				{
-					ID:        2,
-					Title:     "updated movie",
-					Director:  "updated director",
-					IMDb:      10,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       2,
+					Title:    "updated movie",
+					Director: "updated director",
+					IMDb:     10,
+					Industry: IndustryHollywood,
+					Version:  2,
 				},
 			},
 			want: Movie{
-				ID:        2,
-				Title:     "updated movie",
-				Director:  "updated director",
-				IMDb:      10,
-				Hollywood: "yes",
-				Bollywood: "no",
+				ID:       2,
+				Title:    "updated movie",
+				Director: "updated director",
+				IMDb:     10,
+				Industry: IndustryHollywood,
+				Version:  2,
 			},
 			wantErr: nil,
 		},
@@ -346,12 +432,10 @@ func Test_service_updateMovie(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
+			repo := newSeededRepo(t, tt.existingMovies)
 			serv := Newservice(repo)
 
-			repo.movies = tt.existingMovies
-
-			getMovie, getErr := serv.UpdateMovie(tt.args.id, tt.args.updatedMovie)
+			getMovie, getErr := serv.UpdateMovie(context.Background(), tt.args.id, tt.args.updatedMovie)
 
 			if !errors.Is(getErr, tt.wantErr) {
 				t.Errorf("want error %q but got %q", tt.wantErr, getErr)
@@ -361,8 +445,8 @@ func Test_service_updateMovie(t *testing.T) {
 				t.Errorf("want %+v but got %+v", tt.want, getMovie)
 			}
 
-			if !reflect.DeepEqual(repo.movies, tt.wantMovies) {
-				t.Errorf("got %+v \n but want %+v", repo.movies, tt.wantMovies)
+			if !reflect.DeepEqual(storedMovies(t, repo), tt.wantMovies) {
+				t.Errorf("got %+v \n but want %+v", storedMovies(t, repo), tt.wantMovies)
 			}
 		})
 	}
@@ -383,12 +467,11 @@ func Test_service_GetMovieById(t *testing.T) {
 			name: "invalid id",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "Paramveer",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Paramveer",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 			},
 			args: args{
@@ -401,12 +484,11 @@ func Test_service_GetMovieById(t *testing.T) {
 			name: "movie not found",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "Paramveer",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Paramveer",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 			},
 			args: args{
@@ -419,32 +501,30 @@ func Test_service_GetMovieById(t *testing.T) {
 			name: "Valid id",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "Paramveer",
-					Director:  "hardik",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Paramveer",
+					Director: "hardik",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 				{
-					ID:        2,
-					Title:     "Paramveer singh ",
-					Director:  "hardik sharma",
-					IMDb:      8,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       2,
+					Title:    "Paramveer singh ",
+					Director: "hardik sharma",
+					IMDb:     8,
+					Industry: IndustryHollywood,
 				},
 			},
 			args: args{
 				id: 1,
 			},
 			wantMovie: Movie{
-				ID:        1,
-				Title:     "Paramveer",
-				Director:  "hardik",
-				IMDb:      8,
-				Hollywood: "yes",
-				Bollywood: "no",
+				ID:       1,
+				Title:    "Paramveer",
+				Director: "hardik",
+				IMDb:     8,
+				Industry: IndustryHollywood,
+				Version:  1,
 			},
 			wantErr: nil,
 		},
@@ -452,12 +532,10 @@ func Test_service_GetMovieById(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
+			repo := newSeededRepo(t, tt.existingMovies)
 			serv := Newservice(repo)
 
-			repo.movies = tt.existingMovies
-
-			getMovie, getErr := serv.GetMovieById(tt.args.id)
+			getMovie, getErr := serv.GetMovieById(context.Background(), tt.args.id)
 
 			if !errors.Is(getErr, tt.wantErr) {
 				t.Errorf("want error %q but got %q", tt.wantErr, getErr)
@@ -481,38 +559,36 @@ func Test_service_GetAllMovie(t *testing.T) {
 			name: "movies exist",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "Bhamsa",
-					Director:  "Paramveer",
-					IMDb:      7.7,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Bhamsa",
+					Director: "Paramveer",
+					IMDb:     7.7,
+					Industry: IndustryHollywood,
 				},
 				{
-					ID:        2,
-					Title:     "Paramveer",
-					Director:  "Bhamsa",
-					IMDb:      8.9,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Paramveer",
+					Director: "Bhamsa",
+					IMDb:     8.9,
+					Industry: IndustryBollywood,
 				},
 			},
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "Bhamsa",
-					Director:  "Paramveer",
-					IMDb:      7.7,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "Bhamsa",
+					Director: "Paramveer",
+					IMDb:     7.7,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 				{
-					ID:        2,
-					Title:     "Paramveer",
-					Director:  "Bhamsa",
-					IMDb:      8.9,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Paramveer",
+					Director: "Bhamsa",
+					IMDb:     8.9,
+					Industry: IndustryBollywood,
+					Version:  1,
 				},
 			},
 			wantErr: nil,
@@ -527,12 +603,10 @@ func Test_service_GetAllMovie(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
+			repo := newSeededRepo(t, tt.existingMovies)
 			serv := Newservice(repo)
 
-			repo.movies = tt.existingMovies
-
-			getMovies, getErr := serv.GetAllMovie()
+			getMovies, getErr := serv.GetAllMovie(context.Background())
 
 			if !errors.Is(getErr, tt.wantErr) {
 				t.Errorf("want error %q but got %q", tt.wantErr, getErr)
@@ -561,20 +635,18 @@ func Test_service_DeleteMovie(t *testing.T) {
 			name: "valid id",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      9,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     9,
+					Industry: IndustryHollywood,
 				},
 				{
-					ID:        2,
-					Title:     "Hardik Sharma",
-					Director:  "Paramveer singh sarangdevot",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Hardik Sharma",
+					Director: "Paramveer singh sarangdevot",
+					IMDb:     10,
+					Industry: IndustryBollywood,
 				},
 			},
 			args: args{
@@ -585,17 +657,18 @@ func Test_service_DeleteMovie(t *testing.T) {
 				Title:     "bhamsa",
 				Director:  "paramveer",
 				IMDb:      9,
-				Hollywood: "yes",
-				Bollywood: "no",
+				Industry:  IndustryHollywood,
+				Version:   2,
+				DeletedAt: &testDeletedAt,
 			},
 			wantMovies: []Movie{
 				{
-					ID:        2,
-					Title:     "Hardik Sharma",
-					Director:  "Paramveer singh sarangdevot",
-					IMDb:      10,
-					Hollywood: "no",
-					Bollywood: "yes",
+					ID:       2,
+					Title:    "Hardik Sharma",
+					Director: "Paramveer singh sarangdevot",
+					IMDb:     10,
+					Industry: IndustryBollywood,
+					Version:  1,
 				},
 			},
 			wantErr: nil,
@@ -604,12 +677,11 @@ func Test_service_DeleteMovie(t *testing.T) {
 			name: "invalid id",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      9,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     9,
+					Industry: IndustryHollywood,
 				},
 			},
 			args: args{
@@ -618,12 +690,12 @@ func Test_service_DeleteMovie(t *testing.T) {
 			want: Movie{},
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      9,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     9,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 			},
 			wantErr: errInvalidId,
@@ -632,12 +704,11 @@ func Test_service_DeleteMovie(t *testing.T) {
 			name: "movie not found",
 			existingMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      9,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     9,
+					Industry: IndustryHollywood,
 				},
 			},
 			args: args{
@@ -646,12 +717,12 @@ func Test_service_DeleteMovie(t *testing.T) {
 			want: Movie{},
 			wantMovies: []Movie{
 				{
-					ID:        1,
-					Title:     "bhamsa",
-					Director:  "paramveer",
-					IMDb:      9,
-					Hollywood: "yes",
-					Bollywood: "no",
+					ID:       1,
+					Title:    "bhamsa",
+					Director: "paramveer",
+					IMDb:     9,
+					Industry: IndustryHollywood,
+					Version:  1,
 				},
 			},
 			wantErr: errNotFound,
@@ -660,23 +731,210 @@ func Test_service_DeleteMovie(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repo := NewInMemoryRepo()
+			repo := newSeededRepo(t, tt.existingMovies)
+			repo.now = func() time.Time { return testDeletedAt }
 			serv := Newservice(repo)
 
-			repo.movies = tt.existingMovies
-
-			getMovie, getErr := serv.DeleteMovie(tt.args.id)
+			getMovie, getErr := serv.DeleteMovie(context.Background(), tt.args.id, 0)
 
 			if !errors.Is(getErr, tt.wantErr) {
 				t.Errorf("want error %q but got %q", tt.wantErr, getErr)
 			}
 
-			if getMovie != tt.want {
+			if !reflect.DeepEqual(getMovie, tt.want) {
 				t.Errorf("want %+v but got %+v", tt.want, getMovie)
 			}
 
-			if !reflect.DeepEqual(repo.movies, tt.wantMovies) {
-				t.Errorf("got movies %+v\n but want movies %+v", repo.movies, tt.wantMovies)
+			if !reflect.DeepEqual(storedMovies(t, repo), tt.wantMovies) {
+				t.Errorf("got movies %+v\n but want movies %+v", storedMovies(t, repo), tt.wantMovies)
+			}
+		})
+	}
+}
+
+// newRevisedRepo holds one movie at version 3: created with rating 8,
+// renamed, then rated 9.
+func newRevisedRepo(t *testing.T) *InMemoryRepo {
+	t.Helper()
+
+	repo := newSeededRepo(t, []Movie{{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood}})
+	for _, movie := range []Movie{
+		{ID: 1, Title: "singh", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood},
+		{ID: 1, Title: "singh", Director: "paramveer", IMDb: 9, Industry: IndustryBollywood},
+	} {
+		if _, err := repo.updateMovie(context.Background(), 1, movie); err != nil {
+			t.Fatalf("failed to revise movie: %q", err)
+		}
+	}
+	return repo
+}
+
+func Test_service_DiffRevisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       int
+		from, to int
+		want     revisionDiff
+		wantErr  error
+	}{
+		{
+			name: "latest change",
+			id:   1,
+			want: revisionDiff{From: 2, To: 3, Changes: []fieldChange{{Field: "imdb", From: 8.0, To: 9.0}}},
+		},
+		{
+			name: "several changes",
+			id:   1, from: 1, to: 3,
+			want: revisionDiff{From: 1, To: 3, Changes: []fieldChange{
+				{Field: "imdb", From: 8.0, To: 9.0},
+				{Field: "title", From: "bhamsa", To: "singh"},
+			}},
+		},
+		{
+			name: "backwards",
+			id:   1, from: 2, to: 1,
+			want: revisionDiff{From: 2, To: 1, Changes: []fieldChange{{Field: "title", From: "singh", To: "bhamsa"}}},
+		},
+		{
+			name: "same revision",
+			id:   1, from: 2, to: 2,
+			want: revisionDiff{From: 2, To: 2, Changes: []fieldChange{}},
+		},
+		{name: "unknown revision", id: 1, from: 1, to: 4, wantErr: errRevisionNotFound},
+		{name: "only one revision", id: 1, to: 1, wantErr: errRevisionNotFound},
+		{name: "movie not found", id: 2, wantErr: errNotFound},
+		{name: "invalid id", id: -1, wantErr: errInvalidId},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serv := Newservice(newRevisedRepo(t))
+
+			got, err := serv.DiffRevisions(context.Background(), tt.id, tt.from, tt.to)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %q but got %q", tt.wantErr, err)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v but want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_service_RevertMovie(t *testing.T) {
+	tests := []struct {
+		name    string
+		rev     int
+		version int
+		trash   bool
+		want    Movie
+		wantErr error
+	}{
+		{
+			name: "to the first revision",
+			rev:  1,
+			want: Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 4},
+		},
+		{
+			name: "with the current version",
+			rev:  2, version: 3,
+			want: Movie{ID: 1, Title: "singh", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 4},
+		},
+		{name: "stale version", rev: 1, version: 2, wantErr: errVersionConflict},
+		{name: "unknown revision", rev: 9, wantErr: errRevisionNotFound},
+		{name: "trashed movie", rev: 1, trash: true, wantErr: errNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRevisedRepo(t)
+			if tt.trash {
+				if _, err := repo.deleteMovie(context.Background(), 1, 0); err != nil {
+					t.Fatalf("unexpected error %q", err)
+				}
+			}
+			serv := Newservice(repo)
+
+			got, err := serv.RevertMovie(context.Background(), 1, tt.rev, tt.version)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %q but got %q", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v but want %+v", got, tt.want)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			// The revert is a revision of its own, so it can be undone too.
+			revisions, _ := repo.listRevisions(context.Background(), 1)
+			if last := revisions[len(revisions)-1]; last.Number != 4 || last.Change != movieUpdated || last.Movie != tt.want {
+				t.Errorf("got last revision %+v but want the reverted movie", last)
+			}
+		})
+	}
+}
+
+func Test_service_RevertMovieIsValidated(t *testing.T) {
+	// A revision written before the rules got stricter may not pass them
+	// any more; reverting to it has to fail like any other update.
+	repo := newSeededRepo(t, nil)
+	if err := repo.loadMovie(
+		Movie{ID: 1, Title: "singh", Director: "paramveer", IMDb: 9, Version: 2},
+		[]revision{
+			{Number: 1, Change: movieCreated, Movie: Movie{ID: 1, Title: "", Director: "paramveer", IMDb: 42, Version: 1}},
+			{Number: 2, Change: movieUpdated, Movie: Movie{ID: 1, Title: "singh", Director: "paramveer", IMDb: 9, Version: 2}},
+		},
+	); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	if _, err := Newservice(repo).RevertMovie(context.Background(), 1, 1, 0); !errors.Is(err, errInvalidMovie) {
+		t.Errorf("want error %q but got %q", errInvalidMovie, err)
+	}
+}
+
+func Test_service_MoveMovie(t *testing.T) {
+	bhamsa := Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood}
+	hardik := Movie{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9, Industry: IndustryHollywood}
+
+	tests := []struct {
+		name       string
+		id, to     int
+		version    int
+		want       Movie
+		wantErr    error
+		wantMovies []Movie
+	}{
+		{
+			name: "to a free id",
+			id:   1, to: 5,
+			want: Movie{ID: 5, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 2},
+			wantMovies: []Movie{
+				{ID: 5, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 2},
+				{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9, Industry: IndustryHollywood, Version: 1},
+			},
+		},
+		{name: "with the current version", id: 2, to: 3, version: 1, want: Movie{ID: 3, Title: "hardik", Director: "sharma", IMDb: 9, Industry: IndustryHollywood, Version: 2}},
+		{name: "stale version", id: 1, to: 5, version: 2, wantErr: errVersionConflict},
+		{name: "to a taken id", id: 1, to: 2, wantErr: errConflict},
+		{name: "to the same id", id: 1, to: 1, wantErr: errInvalidMove},
+		{name: "to an invalid id", id: 1, to: 0, wantErr: errInvalidMove},
+		{name: "invalid id", id: -1, to: 5, wantErr: errInvalidId},
+		{name: "missing movie", id: 9, to: 5, wantErr: errNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newSeededRepo(t, []Movie{bhamsa, hardik})
+			serv := Newservice(repo)
+
+			got, err := serv.MoveMovie(context.Background(), tt.id, tt.to, tt.version)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %q but got %q", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("want %+v but got %+v", tt.want, got)
+			}
+			if tt.wantMovies != nil && !reflect.DeepEqual(storedMovies(t, repo), tt.wantMovies) {
+				t.Errorf("got %+v \n but want %+v", storedMovies(t, repo), tt.wantMovies)
 			}
 		})
 	}
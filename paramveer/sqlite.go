@@ -0,0 +1,585 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteMigrations brings a database up to the current schema. Entry i
+// moves a database from user_version i to i+1; entries are never edited
+// once released, new changes are appended.
+var sqliteMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS movies (
+		seq       INTEGER PRIMARY KEY AUTOINCREMENT,
+		id        INTEGER NOT NULL UNIQUE,
+		title     TEXT    NOT NULL,
+		director  TEXT    NOT NULL,
+		imdb      REAL    NOT NULL,
+		hollywood TEXT    NOT NULL,
+		bollywood TEXT    NOT NULL
+	)`,
+	`ALTER TABLE movies ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+	CREATE TABLE movie_id_seq (last_id INTEGER NOT NULL);
+	INSERT INTO movie_id_seq (last_id) SELECT COALESCE(MAX(id), 0) FROM movies`,
+	`ALTER TABLE movies ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	`ALTER TABLE movies ADD COLUMN deleted_at INTEGER`,
+	`CREATE TABLE movie_revisions (
+		movie_id INTEGER NOT NULL REFERENCES movies (id) ON UPDATE CASCADE ON DELETE CASCADE,
+		revision INTEGER NOT NULL,
+		change   TEXT    NOT NULL,
+		time     INTEGER NOT NULL,
+		actor    TEXT    NOT NULL,
+		movie    TEXT    NOT NULL,
+		PRIMARY KEY (movie_id, revision)
+	)`,
+	// genres holds a Genres bit set.
+	`ALTER TABLE movies ADD COLUMN industry TEXT NOT NULL DEFAULT '';
+	ALTER TABLE movies ADD COLUMN genres INTEGER NOT NULL DEFAULT 0;
+	UPDATE movies SET industry = CASE
+		WHEN lower(hollywood) = 'yes' AND lower(bollywood) <> 'yes' THEN 'hollywood'
+		WHEN lower(bollywood) = 'yes' AND lower(hollywood) <> 'yes' THEN 'bollywood'
+		ELSE '' END;
+	ALTER TABLE movies DROP COLUMN hollywood;
+	ALTER TABLE movies DROP COLUMN bollywood`,
+}
+
+// sqliteDriver is go-sqlite3 with foldCase available to every connection,
+// as the fold() function and the FOLD collation. SQLite's own lower() and
+// NOCASE only fold ASCII letters.
+const sqliteDriver = "sqlite3_paramveer"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("fold", foldCase, true); err != nil {
+				return err
+			}
+			return conn.RegisterCollation("FOLD", func(a, b string) int {
+				return strings.Compare(foldCase(a), foldCase(b))
+			})
+		},
+	})
+}
+
+const sqliteMovieColumns = `id, uid, title, director, imdb, industry, genres, version, deleted_at`
+
+// SQLiteRepo stores movies in a SQLite database file. Rows are listed in
+// insertion order, matching InMemoryRepo. Trashed rows have deleted_at set,
+// in Unix nanoseconds. Revisions are kept in movie_revisions, with the movie
+// as JSON; the foreign key carries them along when a movie is re-keyed or
+// purged.
+type SQLiteRepo struct {
+	db *sql.DB
+}
+
+// NewSQLiteRepo opens (or creates) the database at path and migrates it to
+// the current schema.
+func NewSQLiteRepo(path string) (*SQLiteRepo, error) {
+	db, err := sql.Open(sqliteDriver, path+"?_busy_timeout=5000&_foreign_keys=on&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite %q: %w", path, err)
+	}
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteRepo{db: db}, nil
+}
+
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("read sqlite schema version: %w", err)
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate sqlite schema to version %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate sqlite schema to version %d: %w", version+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migrate sqlite schema to version %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+// ping checks that the database answers and is migrated to exactly the
+// schema this server knows. A database migrated by a newer server would
+// read wrong.
+func (s *SQLiteRepo) ping(ctx context.Context) error {
+	var version int
+	if err := s.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("read sqlite schema version: %w", err)
+	}
+	if version != len(sqliteMigrations) {
+		return fmt.Errorf("sqlite schema is at version %d but the server expects %d", version, len(sqliteMigrations))
+	}
+	return nil
+}
+
+func (s *SQLiteRepo) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteRepo) createMovie(ctx context.Context, newmovie Movie) error {
+	_, err := s.write(ctx, movieCreated, newRevisionStamp(ctx),
+		`INSERT INTO movies (id, uid, title, director, imdb, industry, genres, version) VALUES (?, ?, ?, ?, ?, ?, ?, 1)
+		RETURNING `+sqliteMovieColumns,
+		newmovie.ID, newmovie.UID, newmovie.Title, newmovie.Director, newmovie.IMDb, newmovie.Industry, newmovie.Genres,
+	)
+	if isUniqueViolation(err) {
+		return errConflict
+	}
+	return err
+}
+
+func (s *SQLiteRepo) getAllMovie(ctx context.Context) ([]Movie, error) {
+	return s.queryMovies(ctx, `SELECT `+sqliteMovieColumns+` FROM movies WHERE deleted_at IS NULL ORDER BY seq`)
+}
+
+func (s *SQLiteRepo) queryMovies(ctx context.Context, query string, args ...any) ([]Movie, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []Movie{}
+	for rows.Next() {
+		movie, err := scanMovie(rows)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, movie)
+	}
+	return movies, rows.Err()
+}
+
+func (s *SQLiteRepo) listMovies(ctx context.Context, q movieQuery) (moviePage, error) {
+	offset, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return moviePage{}, err
+	}
+
+	var (
+		where = []string{`deleted_at IS NULL`}
+		args  []any
+	)
+	if q.Director != "" {
+		where = append(where, `fold(director) = fold(?)`)
+		args = append(args, q.Director)
+	}
+	if q.TitleContains != "" {
+		where = append(where, `instr(fold(title), fold(?)) > 0`)
+		args = append(args, q.TitleContains)
+	}
+	if q.MinIMDb != nil {
+		where = append(where, `imdb >= ?`)
+		args = append(args, *q.MinIMDb)
+	}
+	if q.MaxIMDb != nil {
+		where = append(where, `imdb <= ?`)
+		args = append(args, *q.MaxIMDb)
+	}
+	if len(q.Industries) > 0 {
+		where = append(where, `industry IN (?`+strings.Repeat(`, ?`, len(q.Industries)-1)+`)`)
+		for _, industry := range q.Industries {
+			args = append(args, industry)
+		}
+	}
+	if q.Genres != 0 {
+		where = append(where, `genres & ? = ?`)
+		args = append(args, q.Genres, q.Genres)
+	}
+
+	filter := ` WHERE ` + strings.Join(where, ` AND `)
+
+	page := moviePage{Movies: []Movie{}}
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM movies`+filter, args...).Scan(&page.Total); err != nil {
+		return moviePage{}, err
+	}
+
+	var order []string
+	for _, key := range q.Sort {
+		// Field names were checked against sortableFields, so they are safe
+		// to splice into the statement.
+		column := key.Field
+		if column == "title" || column == "director" {
+			column += ` COLLATE FOLD`
+		}
+		if key.Desc {
+			column += ` DESC`
+		}
+		order = append(order, column)
+	}
+	order = append(order, `seq`)
+
+	limit := -1
+	if q.Limit > 0 {
+		limit = q.Limit
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+sqliteMovieColumns+` FROM movies`+filter+` ORDER BY `+strings.Join(order, `, `)+` LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return moviePage{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		movie, err := scanMovie(rows)
+		if err != nil {
+			return moviePage{}, err
+		}
+		page.Movies = append(page.Movies, movie)
+	}
+	if err := rows.Err(); err != nil {
+		return moviePage{}, err
+	}
+
+	if next := offset + len(page.Movies); q.Limit > 0 && next < page.Total {
+		page.NextCursor = encodeCursor(next)
+	}
+	return page, nil
+}
+
+// sqliteStatsKeys is the SQL for the key of each statsQuery grouping; it
+// matches statsKey.
+var sqliteStatsKeys = map[string]string{
+	"":              `''`,
+	groupByDirector: `director`,
+	groupByIndustry: `CASE industry WHEN '' THEN 'unknown' ELSE industry END`,
+}
+
+// movieStats leaves grouping, the rating aggregates and the histogram
+// buckets to SQLite. SQLite has no percentile function, so percentiles are
+// picked from the ratings of each group, read in order. The queries share a
+// transaction, so they see the same movies.
+func (s *SQLiteRepo) movieStats(ctx context.Context, q statsQuery) ([]statsGroup, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	key := sqliteStatsKeys[q.GroupBy]
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+key+` AS k, COUNT(*), AVG(imdb), MIN(imdb), MAX(imdb) FROM movies WHERE deleted_at IS NULL GROUP BY k ORDER BY k`)
+	if err != nil {
+		return nil, err
+	}
+	groups := []statsGroup{}
+	index := map[string]int{}
+	for rows.Next() {
+		var (
+			group          statsGroup
+			avg, low, high float64
+		)
+		if err := rows.Scan(&group.Key, &group.Count, &avg, &low, &high); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		group.AvgIMDb, group.MinIMDb, group.MaxIMDb = &avg, &low, &high
+		index[group.Key] = len(groups)
+		groups = append(groups, group)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if q.wants(metricHistogram) {
+		rows, err := tx.QueryContext(ctx,
+			`SELECT `+key+` AS k, MIN(CAST((imdb - ?) / ? + 1e-9 AS INTEGER), ?) AS bucket, COUNT(*)
+			FROM movies WHERE deleted_at IS NULL GROUP BY k, bucket`,
+			minRating, q.BucketWidth, histogramSize(q.BucketWidth)-1)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				k             string
+				bucket, count int
+			)
+			if err := rows.Scan(&k, &bucket, &count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			group := &groups[index[k]]
+			if group.Histogram == nil {
+				group.Histogram = newHistogram(q.BucketWidth)
+			}
+			group.Histogram[bucket].Count = count
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.wants(metricPercentiles) {
+		rows, err := tx.QueryContext(ctx,
+			`SELECT `+key+` AS k, imdb FROM movies WHERE deleted_at IS NULL ORDER BY k, imdb`)
+		if err != nil {
+			return nil, err
+		}
+		ratings := map[string][]float64{}
+		for rows.Next() {
+			var (
+				k    string
+				imdb float64
+			)
+			if err := rows.Scan(&k, &imdb); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ratings[k] = append(ratings[k], imdb)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		for k, values := range ratings {
+			groups[index[k]].Percentiles = percentilesOf(values)
+		}
+	}
+	return groups, nil
+}
+
+func (s *SQLiteRepo) getMovieById(ctx context.Context, id int) (Movie, error) {
+	movie, err := scanMovie(s.db.QueryRowContext(ctx, `SELECT `+sqliteMovieColumns+` FROM movies WHERE id = ? AND deleted_at IS NULL`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Movie{}, errNotFound
+	}
+	if err != nil {
+		return Movie{}, err
+	}
+	return movie, nil
+}
+
+func (s *SQLiteRepo) updateMovie(ctx context.Context, id int, newmovie Movie) (Movie, error) {
+	movie, err := s.write(ctx, movieUpdated, newRevisionStamp(ctx),
+		`UPDATE movies SET id = ?, title = ?, director = ?, imdb = ?, industry = ?, genres = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?) RETURNING `+sqliteMovieColumns,
+		newmovie.ID, newmovie.Title, newmovie.Director, newmovie.IMDb, newmovie.Industry, newmovie.Genres,
+		id, newmovie.Version, newmovie.Version,
+	)
+	if isUniqueViolation(err) {
+		return Movie{}, errConflict
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return Movie{}, s.missing(ctx, id)
+	}
+	if err != nil {
+		return Movie{}, err
+	}
+	return movie, nil
+}
+
+func (s *SQLiteRepo) deleteMovie(ctx context.Context, id int, version int) (Movie, error) {
+	stamp := newRevisionStamp(ctx)
+	movie, err := s.write(ctx, movieDeleted, stamp,
+		`UPDATE movies SET deleted_at = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?) RETURNING `+sqliteMovieColumns,
+		stamp.Time.UnixNano(), id, version, version,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Movie{}, s.missing(ctx, id)
+	}
+	if err != nil {
+		return Movie{}, err
+	}
+	return movie, nil
+}
+
+func (s *SQLiteRepo) listTrash(ctx context.Context) ([]Movie, error) {
+	return s.queryMovies(ctx, `SELECT `+sqliteMovieColumns+` FROM movies WHERE deleted_at IS NOT NULL ORDER BY seq`)
+}
+
+func (s *SQLiteRepo) countTrash(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM movies WHERE deleted_at IS NOT NULL`).Scan(&n)
+	return n, err
+}
+
+func (s *SQLiteRepo) restoreMovie(ctx context.Context, id int, version int) (Movie, error) {
+	movie, err := s.write(ctx, movieRestored, newRevisionStamp(ctx),
+		`UPDATE movies SET deleted_at = NULL, version = version + 1
+		WHERE id = ? AND deleted_at IS NOT NULL AND (? = 0 OR version = ?) RETURNING `+sqliteMovieColumns,
+		id, version, version,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		var trashed bool
+		err := s.db.QueryRowContext(ctx, `SELECT deleted_at IS NOT NULL FROM movies WHERE id = ?`, id).Scan(&trashed)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !trashed) {
+			return Movie{}, errNotFound
+		}
+		if err != nil {
+			return Movie{}, err
+		}
+		return Movie{}, errVersionConflict
+	}
+	if err != nil {
+		return Movie{}, err
+	}
+	return movie, nil
+}
+
+func (s *SQLiteRepo) purgeMovie(ctx context.Context, id int) (Movie, error) {
+	movie, err := scanMovie(s.db.QueryRowContext(ctx,
+		`DELETE FROM movies WHERE id = ? AND deleted_at IS NOT NULL RETURNING `+sqliteMovieColumns, id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Movie{}, errNotFound
+	}
+	if err != nil {
+		return Movie{}, err
+	}
+	return movie, nil
+}
+
+func (s *SQLiteRepo) purgeTrash(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM movies WHERE deleted_at < ?`, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLiteRepo) listRevisions(ctx context.Context, id int) ([]revision, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movies WHERE id = ?)`, id).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errNotFound
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT revision, change, time, actor, movie FROM movie_revisions WHERE movie_id = ? ORDER BY revision`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []revision{}
+	for rows.Next() {
+		var (
+			rev   revision
+			at    int64
+			movie []byte
+		)
+		if err := rows.Scan(&rev.Number, &rev.Change, &at, &rev.Actor, &movie); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(movie, &rev.Movie); err != nil {
+			return nil, fmt.Errorf("revision %d of movie %d: %w", rev.Number, id, err)
+		}
+		rev.Time = time.Unix(0, at).UTC()
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// write runs query, which changes one movie and returns its row, and records
+// the changed movie as a revision in the same transaction. It also moves the
+// ID sequence past the movie's ID, so that a movie created or moved with an
+// ID of its own keeps that ID from being handed out after it is purged.
+// Errors from query are returned as they are, so callers can tell what went
+// wrong.
+func (s *SQLiteRepo) write(ctx context.Context, change string, stamp revisionStamp, query string, args ...any) (Movie, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Movie{}, err
+	}
+	defer tx.Rollback()
+
+	movie, err := scanMovie(tx.QueryRowContext(ctx, query, args...))
+	if err != nil {
+		return Movie{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE movie_id_seq SET last_id = MAX(last_id, ?)`, movie.ID); err != nil {
+		return Movie{}, err
+	}
+
+	data, err := json.Marshal(movie)
+	if err != nil {
+		return Movie{}, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO movie_revisions (movie_id, revision, change, time, actor, movie) VALUES (?, ?, ?, ?, ?, ?)`,
+		movie.ID, movie.Version, change, stamp.Time.UnixNano(), stamp.Actor, data,
+	); err != nil {
+		return Movie{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Movie{}, err
+	}
+	return movie, nil
+}
+
+// missing explains why a conditional write on id matched no row: either the
+// movie is gone, or it is there with a version other than the one expected.
+func (s *SQLiteRepo) missing(ctx context.Context, id int) error {
+	if _, err := s.getMovieById(ctx, id); err != nil {
+		return err
+	}
+	return errVersionConflict
+}
+
+func (s *SQLiteRepo) nextMovieID(ctx context.Context) (int, error) {
+	var id int
+	err := s.db.QueryRowContext(ctx,
+		`UPDATE movie_id_seq SET last_id = MAX(last_id, (SELECT COALESCE(MAX(id), 0) FROM movies)) + 1 RETURNING last_id`,
+	).Scan(&id)
+	return id, err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMovie(row rowScanner) (Movie, error) {
+	var (
+		movie     Movie
+		deletedAt sql.NullInt64
+	)
+	err := row.Scan(&movie.ID, &movie.UID, &movie.Title, &movie.Director, &movie.IMDb, &movie.Industry, &movie.Genres, &movie.Version, &deletedAt)
+	if deletedAt.Valid {
+		at := time.Unix(0, deletedAt.Int64).UTC()
+		movie.DeletedAt = &at
+	}
+	return movie, err
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
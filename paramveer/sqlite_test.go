@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestSQLiteRepo(t *testing.T) *SQLiteRepo {
+	t.Helper()
+
+	repo, err := NewSQLiteRepo(filepath.Join(t.TempDir(), "movies.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite repo: %q", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestSQLiteRepo_crud(t *testing.T) {
+	repo := newTestSQLiteRepo(t)
+
+	first := Movie{ID: 2, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 1}
+	second := Movie{ID: 1, Title: "hardik", Director: "sharma", IMDb: 9.5, Industry: IndustryHollywood, Version: 1}
+
+	if err := repo.createMovie(context.Background(), first); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := repo.createMovie(context.Background(), second); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := repo.createMovie(context.Background(), first); !errors.Is(err, errConflict) {
+		t.Errorf("want error %q but got %q", errConflict, err)
+	}
+
+	movies, err := repo.getAllMovie(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if want := []Movie{first, second}; !reflect.DeepEqual(movies, want) {
+		t.Errorf("got %+v but want %+v", movies, want)
+	}
+
+	updated := first
+	updated.Title = "paramveer singh"
+	if _, err := repo.updateMovie(context.Background(), 2, updated); err != nil {
+		t.Errorf("unexpected error %q", err)
+	}
+	if _, err := repo.updateMovie(context.Background(), 2, updated); !errors.Is(err, errVersionConflict) {
+		t.Errorf("want error %q but got %q", errVersionConflict, err)
+	}
+	if _, err := repo.updateMovie(context.Background(), 3, updated); !errors.Is(err, errNotFound) {
+		t.Errorf("want error %q but got %q", errNotFound, err)
+	}
+
+	updated.Version = 2
+	if got, err := repo.getMovieById(context.Background(), 2); err != nil || got != updated {
+		t.Errorf("got %+v, %v but want %+v", got, err, updated)
+	}
+	if _, err := repo.deleteMovie(context.Background(), 2, 1); !errors.Is(err, errVersionConflict) {
+		t.Errorf("want error %q but got %q", errVersionConflict, err)
+	}
+
+	got, err := repo.deleteMovie(context.Background(), 2, 2)
+	if err != nil || got.DeletedAt == nil {
+		t.Fatalf("got %+v, %v but want it trashed", got, err)
+	}
+	got.DeletedAt = nil
+	updated.Version = 3
+	if got != updated {
+		t.Errorf("got %+v but want %+v", got, updated)
+	}
+	if _, err := repo.getMovieById(context.Background(), 2); !errors.Is(err, errNotFound) {
+		t.Errorf("want error %q but got %q", errNotFound, err)
+	}
+	if _, err := repo.deleteMovie(context.Background(), 2, 0); !errors.Is(err, errNotFound) {
+		t.Errorf("want error %q but got %q", errNotFound, err)
+	}
+}
+
+func TestSQLiteRepo_persistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movies.db")
+	movie := Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 1}
+
+	repo, err := NewSQLiteRepo(path)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if err := repo.createMovie(context.Background(), movie); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	repo.Close()
+
+	repo, err = NewSQLiteRepo(path)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer repo.Close()
+
+	if got, err := repo.getMovieById(context.Background(), 1); err != nil || got != movie {
+		t.Errorf("got %+v, %v but want %+v", got, err, movie)
+	}
+}
+
+func TestSQLiteRepo_migratesOldSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "movies.db")
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(sqliteMigrations[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`PRAGMA user_version = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO movies (id, title, director, imdb, hollywood, bollywood) VALUES
+		(4, 'bhamsa', 'paramveer', 8, 'no', 'yes'),
+		(2, 'hardik', 'sharma', 9, 'YES', ''),
+		(3, 'singh', 'paramveer', 7, 'yes', 'yes')`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	repo, err := NewSQLiteRepo(path)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer repo.Close()
+
+	want := Movie{ID: 4, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood, Version: 1}
+	if got, err := repo.getMovieById(context.Background(), 4); err != nil || got != want {
+		t.Errorf("got %+v, %v but want %+v", got, err, want)
+	}
+	// Contradicting flags say nothing about the industry.
+	industries := map[int]Industry{2: IndustryHollywood, 3: ""}
+	for id, industry := range industries {
+		if got, err := repo.getMovieById(context.Background(), id); err != nil || got.Industry != industry {
+			t.Errorf("got %+v, %v but want industry %q", got, err, industry)
+		}
+	}
+	if got, err := repo.nextMovieID(context.Background()); err != nil || got != 5 {
+		t.Errorf("got id %d, %v but want 5", got, err)
+	}
+}
+
+func TestSQLiteRepo_ping(t *testing.T) {
+	repo := newTestSQLiteRepo(t)
+	if err := repo.ping(context.Background()); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	// A newer server migrated the database past what this one knows.
+	if _, err := repo.db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(sqliteMigrations)+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ping(context.Background()); err == nil || !strings.Contains(err.Error(), "schema is at version") {
+		t.Errorf("got %v but want the schema version reported", err)
+	}
+
+	repo.Close()
+	if err := repo.ping(context.Background()); err == nil {
+		t.Errorf("ping succeeded on a closed database")
+	}
+}
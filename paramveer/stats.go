@@ -0,0 +1,242 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Ratings run from minRating to maxRating; histograms cover that range.
+const (
+	minRating = 1
+	maxRating = 10
+
+	defaultBucketWidth = 1.0
+	minBucketWidth     = 0.1
+)
+
+// Grouping keys for statsQuery.GroupBy. The empty string puts every movie
+// into a single group.
+const (
+	groupByDirector = "director"
+	groupByIndustry = "industry"
+)
+
+// Metrics a statsQuery can ask for. The count of each group is always
+// reported.
+const (
+	metricCount       = "count"
+	metricAvg         = "avg"
+	metricMin         = "min"
+	metricMax         = "max"
+	metricPercentiles = "percentiles"
+	metricHistogram   = "histogram"
+)
+
+var (
+	statsMetrics        = []string{metricCount, metricAvg, metricMin, metricMax, metricPercentiles, metricHistogram}
+	defaultStatsMetrics = []string{metricCount, metricAvg, metricMin, metricMax}
+
+	// statsPercentiles are the percentiles the percentiles metric reports.
+	statsPercentiles = []int{25, 50, 75, 90, 99}
+)
+
+// statsQuery asks for aggregates over the live movies. Zero values are
+// filled in by validate.
+type statsQuery struct {
+	GroupBy     string
+	Metrics     []string
+	BucketWidth float64
+}
+
+// movieStats is the response of GET /api/movies/stats. Total counts the
+// movies across all groups.
+type movieStats struct {
+	GroupBy string       `json:"group_by,omitempty"`
+	Total   int          `json:"total"`
+	Groups  []statsGroup `json:"groups"`
+}
+
+// statsGroup holds the metrics of one group, ordered by Key. Metrics that
+// were not asked for are left out. The IMDb rating metrics of a group are
+// never empty, since every group has at least one movie.
+type statsGroup struct {
+	Key         string             `json:"key,omitempty"`
+	Count       int                `json:"count"`
+	AvgIMDb     *float64           `json:"avg_imdb,omitempty"`
+	MinIMDb     *float64           `json:"min_imdb,omitempty"`
+	MaxIMDb     *float64           `json:"max_imdb,omitempty"`
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+	Histogram   []statsBucket      `json:"histogram,omitempty"`
+}
+
+// statsBucket counts the ratings from From up to To. The last bucket of a
+// histogram includes maxRating.
+type statsBucket struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int     `json:"count"`
+}
+
+func (q *statsQuery) validate() error {
+	switch q.GroupBy {
+	case "", groupByDirector, groupByIndustry:
+	default:
+		return fmt.Errorf("%w: group_by must be %s or %s", errInvalidQuery, groupByDirector, groupByIndustry)
+	}
+
+	if len(q.Metrics) == 0 {
+		q.Metrics = defaultStatsMetrics
+	}
+	for _, metric := range q.Metrics {
+		if !slices.Contains(statsMetrics, metric) {
+			return fmt.Errorf("%w: unknown metric %q, metrics are %s", errInvalidQuery, metric, strings.Join(statsMetrics, ", "))
+		}
+	}
+
+	switch {
+	case q.BucketWidth == 0:
+		q.BucketWidth = defaultBucketWidth
+	case math.IsNaN(q.BucketWidth) || q.BucketWidth < minBucketWidth || q.BucketWidth > maxRating-minRating:
+		return fmt.Errorf("%w: bucket_width must be between %g and %d", errInvalidQuery, minBucketWidth, maxRating-minRating)
+	}
+	return nil
+}
+
+func (q statsQuery) wants(metric string) bool {
+	return slices.Contains(q.Metrics, metric)
+}
+
+func statsKey(m Movie, groupBy string) string {
+	switch groupBy {
+	case groupByDirector:
+		return m.Director
+	case groupByIndustry:
+		if m.Industry == "" {
+			return "unknown"
+		}
+		return string(m.Industry)
+	default:
+		return ""
+	}
+}
+
+// histogramSize is how many buckets of the given width it takes to cover
+// the ratings.
+func histogramSize(width float64) int {
+	return int(math.Ceil((maxRating-minRating)/width - 1e-9))
+}
+
+// histogramBucket is the bucket a rating falls in. The small nudge keeps a
+// rating that sits on a bucket boundary, like 1.3 with width 0.1, from
+// landing in the bucket below through rounding.
+func histogramBucket(imdb, width float64) int {
+	return min(int((imdb-minRating)/width+1e-9), histogramSize(width)-1)
+}
+
+// newHistogram returns the empty buckets of a histogram.
+func newHistogram(width float64) []statsBucket {
+	buckets := make([]statsBucket, histogramSize(width))
+	for i := range buckets {
+		buckets[i].From = roundRating(minRating + float64(i)*width)
+		buckets[i].To = roundRating(min(minRating+float64(i+1)*width, maxRating))
+	}
+	return buckets
+}
+
+// percentilesOf picks statsPercentiles from sorted ratings by the nearest
+// rank method, so every percentile is a rating that was given.
+func percentilesOf(sorted []float64) map[string]float64 {
+	percentiles := make(map[string]float64, len(statsPercentiles))
+	for _, p := range statsPercentiles {
+		rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+		percentiles["p"+strconv.Itoa(p)] = sorted[max(rank, 1)-1]
+	}
+	return percentiles
+}
+
+func roundRating(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// aggregateMovies computes q over movies, for repos that cannot aggregate
+// natively. It fills in every rating metric, and percentiles and histograms
+// only when q asks for them.
+func aggregateMovies(movies []Movie, q statsQuery) []statsGroup {
+	ratings := map[string][]float64{}
+	for _, movie := range movies {
+		key := statsKey(movie, q.GroupBy)
+		ratings[key] = append(ratings[key], movie.IMDb)
+	}
+
+	groups := make([]statsGroup, 0, len(ratings))
+	for key, values := range ratings {
+		slices.Sort(values)
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		avg, low, high := sum/float64(len(values)), values[0], values[len(values)-1]
+		group := statsGroup{Key: key, Count: len(values), AvgIMDb: &avg, MinIMDb: &low, MaxIMDb: &high}
+
+		if q.wants(metricPercentiles) {
+			group.Percentiles = percentilesOf(values)
+		}
+		if q.wants(metricHistogram) {
+			group.Histogram = newHistogram(q.BucketWidth)
+			for _, v := range values {
+				group.Histogram[histogramBucket(v, q.BucketWidth)].Count++
+			}
+		}
+		groups = append(groups, group)
+	}
+	slices.SortFunc(groups, func(a, b statsGroup) int { return cmp.Compare(a.Key, b.Key) })
+	return groups
+}
+
+// parseStatsQuery reads ?group_by=, ?metrics= as a comma separated list,
+// and ?bucket_width=.
+func parseStatsQuery(r *http.Request) (statsQuery, error) {
+	values := r.URL.Query()
+	q := statsQuery{GroupBy: values.Get("group_by")}
+	if raw := values.Get("metrics"); raw != "" {
+		for _, metric := range strings.Split(raw, ",") {
+			q.Metrics = append(q.Metrics, strings.TrimSpace(metric))
+		}
+	}
+	if raw := values.Get("bucket_width"); raw != "" {
+		width, err := strconv.ParseFloat(raw, 64)
+		if err != nil || width <= 0 {
+			return statsQuery{}, fmt.Errorf("%w: bucket_width must be a positive number", errInvalidQuery)
+		}
+		q.BucketWidth = width
+	}
+	return q, nil
+}
+
+func (h *movieHandler) movieStats(w http.ResponseWriter, r *http.Request) {
+	q, err := parseStatsQuery(r)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	stats, err := h.serv.MovieStats(r.Context(), q)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
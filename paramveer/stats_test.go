@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_service_MovieStats(t *testing.T) {
+	movies := []Movie{
+		{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood},
+		{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9.5, Industry: IndustryHollywood},
+		{ID: 3, Title: "singh", Director: "paramveer", IMDb: 7.1, Industry: IndustryBollywood},
+		{ID: 4, Title: "kingdom", Director: "sharma", IMDb: 10, Industry: IndustryKorean},
+		{ID: 5, Title: "dunki", Director: "hirani", IMDb: 1},
+	}
+	rating := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name    string
+		q       statsQuery
+		want    movieStats
+		wantErr error
+	}{
+		{
+			name: "default metrics over everything",
+			want: movieStats{Total: 5, Groups: []statsGroup{
+				{Count: 5, AvgIMDb: rating(7.12), MinIMDb: rating(1), MaxIMDb: rating(10)},
+			}},
+		},
+		{
+			name: "count by industry",
+			q:    statsQuery{GroupBy: groupByIndustry, Metrics: []string{metricCount}},
+			want: movieStats{GroupBy: groupByIndustry, Total: 5, Groups: []statsGroup{
+				{Key: "bollywood", Count: 2},
+				{Key: "hollywood", Count: 1},
+				{Key: "korean", Count: 1},
+				{Key: "unknown", Count: 1},
+			}},
+		},
+		{
+			name: "percentiles and histogram by director",
+			q:    statsQuery{GroupBy: groupByDirector, Metrics: []string{metricPercentiles, metricHistogram}, BucketWidth: 3},
+			want: movieStats{GroupBy: groupByDirector, Total: 5, Groups: []statsGroup{
+				{
+					Key: "hirani", Count: 1,
+					Percentiles: map[string]float64{"p25": 1, "p50": 1, "p75": 1, "p90": 1, "p99": 1},
+					Histogram:   []statsBucket{{From: 1, To: 4, Count: 1}, {From: 4, To: 7}, {From: 7, To: 10}},
+				},
+				{
+					Key: "paramveer", Count: 2,
+					Percentiles: map[string]float64{"p25": 7.1, "p50": 7.1, "p75": 8, "p90": 8, "p99": 8},
+					Histogram:   []statsBucket{{From: 1, To: 4}, {From: 4, To: 7}, {From: 7, To: 10, Count: 2}},
+				},
+				{
+					Key: "sharma", Count: 2,
+					Percentiles: map[string]float64{"p25": 9.5, "p50": 9.5, "p75": 10, "p90": 10, "p99": 10},
+					Histogram:   []statsBucket{{From: 1, To: 4}, {From: 4, To: 7}, {From: 7, To: 10, Count: 2}},
+				},
+			}},
+		},
+		{name: "unknown grouping", q: statsQuery{GroupBy: "title"}, wantErr: errInvalidQuery},
+		{name: "unknown metric", q: statsQuery{Metrics: []string{"median"}}, wantErr: errInvalidQuery},
+		{name: "bucket too narrow", q: statsQuery{BucketWidth: 0.01}, wantErr: errInvalidQuery},
+		{name: "bucket too wide", q: statsQuery{BucketWidth: 12}, wantErr: errInvalidQuery},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serv := Newservice(newSeededRepo(t, movies))
+
+			got, err := serv.MovieStats(context.Background(), tt.q)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %q but got %q", tt.wantErr, err)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("got %s but want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func Test_histogramBucket(t *testing.T) {
+	tests := []struct {
+		imdb, width float64
+		want        int
+	}{
+		{1, 1, 0},
+		{1.9, 1, 0},
+		{2, 1, 1},
+		{10, 1, 8},
+		{1.3, 0.1, 3},
+		{10, 0.1, 89},
+		{9.9, 4, 2},
+	}
+	for _, tt := range tests {
+		if got := histogramBucket(tt.imdb, tt.width); got != tt.want {
+			t.Errorf("histogramBucket(%g, %g) = %d but want %d", tt.imdb, tt.width, got, tt.want)
+		}
+	}
+	if got := newHistogram(4); !reflect.DeepEqual(got, []statsBucket{{From: 1, To: 5}, {From: 5, To: 9}, {From: 9, To: 10}}) {
+		t.Errorf("got buckets %+v", got)
+	}
+}
+
+func Test_movieHandler_movieStats(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name:           "defaults",
+			wantStatusCode: http.StatusOK,
+			wantBody:       `{"total":2,"groups":[{"count":2,"avg_imdb":8.5,"min_imdb":8,"max_imdb":9}]}` + "\n",
+		},
+		{
+			name:           "grouped with chosen metrics",
+			query:          "?group_by=director&metrics=count,%20max",
+			wantStatusCode: http.StatusOK,
+			wantBody:       `{"group_by":"director","total":2,"groups":[{"key":"paramveer","count":1,"max_imdb":8},{"key":"sharma","count":1,"max_imdb":9}]}` + "\n",
+		},
+		{
+			name:           "histogram",
+			query:          "?metrics=histogram&bucket_width=4.5",
+			wantStatusCode: http.StatusOK,
+			wantBody:       `{"total":2,"groups":[{"count":2,"histogram":[{"from":1,"to":5.5,"count":0},{"from":5.5,"to":10,"count":2}]}]}` + "\n",
+		},
+		{name: "bad bucket width", query: "?bucket_width=wide", wantStatusCode: http.StatusBadRequest},
+		{name: "unknown metric", query: "?metrics=count,mode", wantStatusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newSeededRepo(t, []Movie{
+				{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8},
+				{ID: 2, Title: "hardik", Director: "sharma", IMDb: 9},
+			})
+			router := registerRoutes(NewMovieHandler(Newservice(repo)))
+
+			req := httptest.NewRequest("GET", "/api/movies/stats"+tt.query, nil)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatusCode {
+				t.Fatalf("want statuscode %d but got %d: %s", tt.wantStatusCode, res.Code, res.Body.String())
+			}
+			if tt.wantBody != "" && res.Body.String() != tt.wantBody {
+				t.Errorf("want body %s but got %s", tt.wantBody, res.Body.String())
+			}
+		})
+	}
+}
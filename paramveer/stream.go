@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	streamWriteWait  = 10 * time.Second
+	streamPongWait   = 60 * time.Second
+	streamPingPeriod = streamPongWait * 9 / 10
+
+	// eventStreamKeepAlive is how often an idle event stream sends a comment
+	// line, so proxies do not time the connection out.
+	eventStreamKeepAlive = 15 * time.Second
+)
+
+var streamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// streamMovies pushes movie events over a WebSocket. Clients pass the token
+// of the last event they saw as ?resume= to replay what they missed. A
+// client that cannot keep up is disconnected with CloseTryAgainLater and is
+// expected to reconnect with its last token.
+func (h *movieHandler) streamMovies(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		resolveError(w, r, errNoRoute)
+		return
+	}
+
+	// Counted before the upgrade hijacks the connection, while
+	// http.Server.Shutdown still waits on it.
+	h.streams.Add(1)
+	defer h.streams.Done()
+
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered the request.
+		log.Printf("request_id=%s websocket upgrade failed: %v", requestIDFrom(r.Context()), err)
+		return
+	}
+	defer conn.Close()
+
+	sub := h.events.subscribe(r.URL.Query().Get("resume"))
+	defer sub.cancel()
+
+	// Reading is needed to process pongs and the client's close frame;
+	// anything else the client sends is ignored.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(streamPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.events():
+			if !ok {
+				closeStream(conn, sub)
+				// Give the client a moment to answer the close frame, so
+				// the connection ends cleanly on both sides.
+				select {
+				case <-gone:
+				case <-time.After(streamWriteWait):
+				}
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// waitStreams waits for the WebSocket streams to end, which they do once
+// the change feed they serve is closed.
+func (h *movieHandler) waitStreams(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.streams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeStream tells the client why its subscription ended.
+func closeStream(conn *websocket.Conn, sub *subscription) {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	if sub.overflowed() {
+		msg = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow, resume from the last token")
+	}
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(streamWriteWait))
+}
+
+// movieEventStream serves the same feed as streamMovies as Server-Sent
+// Events, for clients that cannot use WebSockets. Every event carries its
+// token as the event ID, so a reconnecting EventSource resumes through
+// Last-Event-ID on its own. A client that cannot keep up has its stream
+// ended and reconnects the same way.
+func (h *movieHandler) movieEventStream(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		resolveError(w, r, errNoRoute)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		resolveError(w, r, errors.New("response writer cannot stream"))
+		return
+	}
+
+	sub := h.events.subscribe(r.Header.Get("Last-Event-ID"))
+	defer sub.cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	// The server's write timeout would end the stream; like the WebSocket
+	// stream, it gets a deadline per write instead. Writers that do not
+	// support deadlines have no timeout to lift.
+	rc := http.NewResponseController(w)
+
+	for {
+		select {
+		case event, ok := <-sub.events():
+			if !ok {
+				return
+			}
+			rc.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := writeServerSentEvent(w, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			rc.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, event movieEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Token, event.Type, data)
+	return err
+}
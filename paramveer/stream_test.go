@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMovieHandler_streamMovies(t *testing.T) {
+	events := newEventLog(0)
+	serv := Newservice(NewInMemoryRepo(), withEventLog(events))
+	server := httptest.NewServer(registerRoutes(NewMovieHandler(serv, withChangeFeed(events))))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/movies/stream"
+	dial := func(query string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(url+query, nil)
+		if err != nil {
+			t.Fatalf("failed to connect: %q", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	next := func(conn *websocket.Conn) movieEvent {
+		t.Helper()
+		var event movieEvent
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("failed to read event: %q", err)
+		}
+		return event
+	}
+	// subscribed waits until the server has registered n subscriptions, so
+	// the test does not publish before a connection is listening.
+	subscribed := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			events.mu.Lock()
+			got := len(events.subs)
+			events.mu.Unlock()
+			if got >= n {
+				return
+			}
+		}
+		t.Fatalf("stream did not subscribe")
+	}
+
+	conn := dial("")
+	subscribed(1)
+
+	movie, err := serv.CreateMovie(context.Background(), Movie{Title: "bhamsa", Director: "paramveer", IMDb: 8})
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	created := next(conn)
+	if created.Type != movieCreated || created.Movie == nil || *created.Movie != movie {
+		t.Errorf("got %+v but want the created movie", created)
+	}
+
+	if _, err := serv.DeleteMovie(context.Background(), movie.ID, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if deleted := next(conn); deleted.Type != movieDeleted || deleted.MovieID != movie.ID {
+		t.Errorf("got %+v but want the deletion", deleted)
+	}
+
+	// A client reconnecting with the token of the creation replays the
+	// deletion it missed.
+	resumed := dial("?resume=" + created.Token)
+	if replayed := next(resumed); replayed.Type != movieDeleted {
+		t.Errorf("got %+v but want the deletion replayed", replayed)
+	}
+
+	// Closing the log ends every stream.
+	events.close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("got %v but want a going away close", err)
+	}
+}
+
+func TestMovieHandler_movieEventStream(t *testing.T) {
+	events := newEventLog(0)
+	serv := Newservice(NewInMemoryRepo(), withEventLog(events))
+	server := httptest.NewServer(registerRoutes(NewMovieHandler(serv, withChangeFeed(events))))
+	defer server.Close()
+
+	movie, err := serv.CreateMovie(context.Background(), Movie{Title: "bhamsa", Director: "paramveer", IMDb: 8})
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	if _, err := serv.DeleteMovie(context.Background(), movie.ID, 0); err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	// Resuming from before the creation replays both changes.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/movies/events", nil)
+	req.Header.Set("Last-Event-ID", events.token(0))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer res.Body.Close()
+
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("got content type %q", got)
+	}
+
+	lines := bufio.NewScanner(res.Body)
+	read := func() (id, eventType string, event movieEvent) {
+		t.Helper()
+		for lines.Scan() {
+			field, value, _ := strings.Cut(lines.Text(), ": ")
+			switch field {
+			case "id":
+				id = value
+			case "event":
+				eventType = value
+			case "data":
+				if err := json.Unmarshal([]byte(value), &event); err != nil {
+					t.Fatalf("invalid event data %q: %q", value, err)
+				}
+			case "":
+				return id, eventType, event
+			}
+		}
+		t.Fatalf("stream ended early: %v", lines.Err())
+		return
+	}
+
+	for _, want := range []string{movieCreated, movieDeleted} {
+		id, eventType, event := read()
+		if eventType != want || event.Type != want || id != event.Token || event.MovieID != movie.ID {
+			t.Errorf("got id %q, event %q, %+v but want a %s event", id, eventType, event, want)
+		}
+	}
+}
+
+// TestMovieHandler_shutdown checks that shutting the server down ends open
+// streams promptly rather than at the drain deadline.
+func TestMovieHandler_shutdown(t *testing.T) {
+	events := newEventLog(0)
+	handler := NewMovieHandler(Newservice(NewInMemoryRepo(), withEventLog(events)), withChangeFeed(events))
+	server := httptest.NewServer(registerRoutes(handler))
+	defer server.Close()
+	server.Config.RegisterOnShutdown(events.close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/movies/stream", nil)
+	if err != nil {
+		t.Fatalf("failed to connect: %q", err)
+	}
+	defer conn.Close()
+	res, err := http.Get(server.URL + "/api/movies/events")
+	if err != nil {
+		t.Fatalf("unexpected error %q", err)
+	}
+	defer res.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Config.Shutdown(ctx) }()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("got %v but want a going away close", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("event stream held the shutdown up: %q", err)
+	}
+	if err := handler.waitStreams(ctx); err != nil {
+		t.Errorf("websocket stream held the shutdown up: %q", err)
+	}
+}
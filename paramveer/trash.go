@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultTrashRetention = 30 * 24 * time.Hour
+	trashPurgeInterval    = time.Hour
+)
+
+// trashPurge is the response of DELETE /api/movies/trash.
+type trashPurge struct {
+	Purged int `json:"purged"`
+}
+
+func (h *movieHandler) listTrash(w http.ResponseWriter, r *http.Request) {
+	movies, err := h.serv.ListTrash(r.Context())
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(moviePage{Movies: movies, Total: len(movies)}); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
+
+func (h *movieHandler) restoreMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	version, err := ifMatchVersion(r, h.requireIfMatch)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	movie, err := h.serv.RestoreMovie(r.Context(), id, version)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", movieETag(movie))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(movie); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
+
+func (h *movieHandler) purgeMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := pathId(r)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	movie, err := h.serv.PurgeMovie(r.Context(), id)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(movie); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
+
+// purgeTrash empties the trash, or with ?before=<RFC 3339 time> only removes
+// the movies trashed before then.
+func (h *movieHandler) purgeTrash(w http.ResponseWriter, r *http.Request) {
+	before := time.Now()
+	if value := r.URL.Query().Get("before"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			resolveError(w, r, fmt.Errorf("%w: before: %v", errInvalidQuery, err))
+			return
+		}
+		before = t
+	}
+
+	purged, err := h.serv.PurgeTrash(r.Context(), before)
+	if err != nil {
+		resolveError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(trashPurge{Purged: purged}); err != nil {
+		log.Println("failed to send response:", err)
+		return
+	}
+}
+
+// runTrashRetention purges movies that have been in the trash for longer
+// than retention, checking every interval until ctx is done.
+func runTrashRetention(ctx context.Context, serv movieService, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := serv.PurgeTrash(ctx, time.Now().Add(-retention))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			log.Println("trash retention failed:", err)
+		case purged > 0:
+			logf(levelInfo, "trash retention purged %d movies", purged)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
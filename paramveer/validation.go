@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTitleLength    = 200
+	maxDirectorLength = 100
+)
+
+var (
+	errInvalidMovie  = errors.New("invalid movie")
+	errInvalidId     = errors.New("invalid id")
+	errInvalidRating = errors.New("invalid imdb rating")
+	errRequired      = errors.New("required")
+	errTooLong       = errors.New("too long")
+	errTooPrecise    = errors.New("too precise")
+	errNotAllowed    = errors.New("value not allowed")
+)
+
+// fieldError points at a single invalid field of a request body. err is the
+// sentinel the problem maps to, so callers can still use errors.Is.
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	err error
+}
+
+func (e fieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+func (e fieldError) Unwrap() error {
+	return e.err
+}
+
+// validationErrors lists everything wrong with a movie. It matches
+// errInvalidMovie as well as the sentinel of every field error it holds.
+type validationErrors []fieldError
+
+func (v validationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Error()
+	}
+	return errInvalidMovie.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (v validationErrors) Unwrap() []error {
+	errs := []error{errInvalidMovie}
+	for _, e := range v {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// movieRule checks one aspect of a movie and reports a field error when it
+// does not hold.
+type movieRule func(Movie) (fieldError, bool)
+
+// movieRules is the full set of constraints on a stored movie, checked in
+// order. Rules run on the normalized movie.
+var movieRules = []movieRule{
+	positive("id", "invalid_id", errInvalidId, func(m Movie) int { return m.ID }),
+	required("title", func(m Movie) string { return m.Title }),
+	maxLength("title", maxTitleLength, func(m Movie) string { return m.Title }),
+	required("director", func(m Movie) string { return m.Director }),
+	maxLength("director", maxDirectorLength, func(m Movie) string { return m.Director }),
+	between("imdb", 1, 10, "invalid_rating", errInvalidRating, func(m Movie) float64 { return m.IMDb }),
+	decimals("imdb", 1, func(m Movie) float64 { return m.IMDb }),
+	oneOf("industry", industryNames(), func(m Movie) string { return string(m.Industry) }),
+}
+
+func validateId(id int) error {
+	if id < 0 {
+		return errInvalidId
+	}
+	return nil
+}
+
+// industryNames is what the industry rule allows: no industry, or one of
+// industries.
+func industryNames() []string {
+	names := []string{""}
+	for _, industry := range industries {
+		names = append(names, string(industry))
+	}
+	return names
+}
+
+// normalizeMovie cleans up free text before a movie is validated and
+// stored: surrounding whitespace is dropped and the industry is lower
+// cased.
+func normalizeMovie(movie Movie) Movie {
+	movie.Title = strings.TrimSpace(movie.Title)
+	movie.Director = strings.TrimSpace(movie.Director)
+	movie.Industry = Industry(strings.ToLower(strings.TrimSpace(string(movie.Industry))))
+	return movie
+}
+
+// validateMovie runs every rule and returns all failures as
+// validationErrors, or nil when the movie is valid.
+func validateMovie(movie Movie) error {
+	var errs validationErrors
+	for _, rule := range movieRules {
+		if e, failed := rule(movie); failed {
+			errs = append(errs, e)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateNewMovie validates a movie that may not have been given an ID
+// yet.
+func validateNewMovie(newmovie Movie) error {
+	if newmovie.ID == 0 {
+		// The sequence will give it an ID; any valid one stands in.
+		newmovie.ID = 1
+	}
+	return validateMovie(newmovie)
+}
+
+func positive(field, code string, sentinel error, get func(Movie) int) movieRule {
+	return func(m Movie) (fieldError, bool) {
+		if get(m) >= 1 {
+			return fieldError{}, false
+		}
+		return fieldError{Field: field, Code: code, Message: "must be a positive number", err: sentinel}, true
+	}
+}
+
+func required(field string, get func(Movie) string) movieRule {
+	return func(m Movie) (fieldError, bool) {
+		if get(m) != "" {
+			return fieldError{}, false
+		}
+		return fieldError{Field: field, Code: "required", Message: "is required", err: errRequired}, true
+	}
+}
+
+func maxLength(field string, limit int, get func(Movie) string) movieRule {
+	return func(m Movie) (fieldError, bool) {
+		if utf8.RuneCountInString(get(m)) <= limit {
+			return fieldError{}, false
+		}
+		return fieldError{
+			Field:   field,
+			Code:    "too_long",
+			Message: fmt.Sprintf("must be at most %d characters", limit),
+			err:     errTooLong,
+		}, true
+	}
+}
+
+func between(field string, lo, hi float64, code string, sentinel error, get func(Movie) float64) movieRule {
+	return func(m Movie) (fieldError, bool) {
+		if v := get(m); v >= lo && v <= hi {
+			return fieldError{}, false
+		}
+		return fieldError{
+			Field:   field,
+			Code:    code,
+			Message: fmt.Sprintf("must be between %g and %g", lo, hi),
+			err:     sentinel,
+		}, true
+	}
+}
+
+func decimals(field string, places int, get func(Movie) float64) movieRule {
+	scale := math.Pow10(places)
+	return func(m Movie) (fieldError, bool) {
+		scaled := get(m) * scale
+		if math.Abs(scaled-math.Round(scaled)) < 1e-9 {
+			return fieldError{}, false
+		}
+		return fieldError{
+			Field:   field,
+			Code:    "too_precise",
+			Message: fmt.Sprintf("must have at most %d decimal place(s)", places),
+			err:     errTooPrecise,
+		}, true
+	}
+}
+
+// oneOf restricts a field to the allowed values; an empty string in allowed
+// makes the field optional.
+func oneOf(field string, allowed []string, get func(Movie) string) movieRule {
+	var named []string
+	for _, a := range allowed {
+		if a != "" {
+			named = append(named, a)
+		}
+	}
+	message := "must be one of " + strings.Join(named, ", ")
+
+	return func(m Movie) (fieldError, bool) {
+		v := get(m)
+		for _, a := range allowed {
+			if v == a {
+				return fieldError{}, false
+			}
+		}
+		return fieldError{
+			Field:   field,
+			Code:    "not_allowed",
+			Message: message,
+			err:     errNotAllowed,
+		}, true
+	}
+}
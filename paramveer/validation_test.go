@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_validateMovie(t *testing.T) {
+	valid := Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8.5, Industry: IndustryBollywood}
+
+	tests := []struct {
+		name       string
+		movie      func(m Movie) Movie
+		wantFields []string
+		wantCodes  []string
+	}{
+		{
+			name:  "valid movie",
+			movie: func(m Movie) Movie { return m },
+		},
+		{
+			name: "industry is optional",
+			movie: func(m Movie) Movie {
+				m.Industry = ""
+				return m
+			},
+		},
+		{
+			name: "missing title and director",
+			movie: func(m Movie) Movie {
+				m.Title, m.Director = "", ""
+				return m
+			},
+			wantFields: []string{"title", "director"},
+			wantCodes:  []string{"required", "required"},
+		},
+		{
+			name: "too long",
+			movie: func(m Movie) Movie {
+				m.Title = strings.Repeat("a", maxTitleLength+1)
+				m.Director = strings.Repeat("ब", maxDirectorLength+1)
+				return m
+			},
+			wantFields: []string{"title", "director"},
+			wantCodes:  []string{"too_long", "too_long"},
+		},
+		{
+			name: "longest allowed title counts runes",
+			movie: func(m Movie) Movie {
+				m.Title = strings.Repeat("ब", maxTitleLength)
+				return m
+			},
+		},
+		{
+			name: "rating out of range and too precise",
+			movie: func(m Movie) Movie {
+				m.IMDb = 10.25
+				return m
+			},
+			wantFields: []string{"imdb", "imdb"},
+			wantCodes:  []string{"invalid_rating", "too_precise"},
+		},
+		{
+			name: "unknown industry",
+			movie: func(m Movie) Movie {
+				m.Industry = "nollywood"
+				return m
+			},
+			wantFields: []string{"industry"},
+			wantCodes:  []string{"not_allowed"},
+		},
+		{
+			name: "everything at once",
+			movie: func(m Movie) Movie {
+				return Movie{IMDb: 0.55, Industry: "maybe"}
+			},
+			wantFields: []string{"id", "title", "director", "imdb", "imdb", "industry"},
+			wantCodes:  []string{"invalid_id", "required", "required", "invalid_rating", "too_precise", "not_allowed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMovie(tt.movie(valid))
+
+			if len(tt.wantFields) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error %q", err)
+				}
+				return
+			}
+
+			var errs validationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("want validationErrors but got %#v", err)
+			}
+			if !errors.Is(err, errInvalidMovie) {
+				t.Errorf("error %q does not match %q", err, errInvalidMovie)
+			}
+
+			var gotFields, gotCodes []string
+			for _, e := range errs {
+				gotFields = append(gotFields, e.Field)
+				gotCodes = append(gotCodes, e.Code)
+			}
+			if !reflect.DeepEqual(gotFields, tt.wantFields) {
+				t.Errorf("got fields %q but want %q", gotFields, tt.wantFields)
+			}
+			if !reflect.DeepEqual(gotCodes, tt.wantCodes) {
+				t.Errorf("got codes %q but want %q", gotCodes, tt.wantCodes)
+			}
+		})
+	}
+}
+
+func Test_validateMovieSentinels(t *testing.T) {
+	err := validateMovie(Movie{ID: 0, Title: "bhamsa", Director: "paramveer", IMDb: 11})
+
+	if !errors.Is(err, errInvalidId) {
+		t.Errorf("error %q does not match %q", err, errInvalidId)
+	}
+	if !errors.Is(err, errInvalidRating) {
+		t.Errorf("error %q does not match %q", err, errInvalidRating)
+	}
+	if errors.Is(err, errRequired) {
+		t.Errorf("error %q should not match %q", err, errRequired)
+	}
+}
+
+func Test_normalizeMovie(t *testing.T) {
+	got := normalizeMovie(Movie{ID: 1, Title: "  bhamsa ", Director: "\tparamveer\n", IMDb: 8, Industry: " BollyWood"})
+	want := Movie{ID: 1, Title: "bhamsa", Director: "paramveer", IMDb: 8, Industry: IndustryBollywood}
+
+	if got != want {
+		t.Errorf("got %+v but want %+v", got, want)
+	}
+
+	if err := validateMovie(normalizeMovie(Movie{ID: 1, Title: "   ", Director: "paramveer", IMDb: 8})); !errors.Is(err, errRequired) {
+		t.Errorf("blank title should be required, got %q", err)
+	}
+}